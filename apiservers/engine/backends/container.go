@@ -15,22 +15,44 @@
 package vicbackends
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api/types/backend"
 	derr "github.com/docker/docker/errors"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/pkg/version"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
+	"github.com/docker/engine-api/types/filters"
+	apinet "github.com/docker/engine-api/types/network"
+	"github.com/docker/engine-api/types/strslice"
+	timetypes "github.com/docker/engine-api/types/time"
+	"github.com/docker/engine-api/types/versions/v1p20"
+	"github.com/docker/go-units"
 
 	"github.com/vmware/vic/apiservers/portlayer/client/exec"
+	"github.com/vmware/vic/apiservers/portlayer/client/interaction"
 	"github.com/vmware/vic/apiservers/portlayer/client/storage"
 	"github.com/vmware/vic/apiservers/portlayer/models"
 	"github.com/vmware/vic/pkg/trace"
@@ -40,215 +62,1676 @@ type Container struct {
 	ProductName string
 }
 
-var hackMap = map[string]string{
-	"busybox":         "bc744c4ab376115cc45c610d53f529dd2d4249ae6b35e5d6e7a96e58863545aa",
-	"tomcat":          "7b462938183d61aeae3cac6a7a2335b8806d561498a1495353b6650d65e1e403",
-	"golang":          "a443d5a72a2ed0af201ea5b30b3fc4e28146b86b9c3cdd8d177b2c77e00d39fd",
-	"photon":          "b12b5ead0dad7bd8bf02889ccf7eacc82ce89c7ed4bad48e9d5c9f7ac110fdc2",
-	"library/busybox": "bc744c4ab376115cc45c610d53f529dd2d4249ae6b35e5d6e7a96e58863545aa",
-	"library/tomcat":  "7b462938183d61aeae3cac6a7a2335b8806d561498a1495353b6650d65e1e403",
-	"library/golang":  "a443d5a72a2ed0af201ea5b30b3fc4e28146b86b9c3cdd8d177b2c77e00d39fd",
-	"library/photon":  "b12b5ead0dad7bd8bf02889ccf7eacc82ce89c7ed4bad48e9d5c9f7ac110fdc2",
-}
+// containerNameChars are the characters allowed in container names, which must start with a
+// letter or digit, as docker has them
+const containerNameChars = `[a-zA-Z0-9][a-zA-Z0-9_.-]`
+
+var validContainerName = regexp.MustCompile(`^` + containerNameChars + `+$`)
+
+// portLayerWaitInterval is the longest a single wait request to the port layer will block for
+const portLayerWaitInterval = 20 * time.Second
 
 // docker's container.execBackend
 
+// execSession records an exec created through the engine. Docker addresses execs by their own ID
+// alone, whereas the port layer needs the container the session was added to.
+type execSession struct {
+	containerID string
+	config      types.ExecConfig
+	started     bool
+}
+
+var (
+	execSessions      = make(map[string]*execSession)
+	execSessionsMutex sync.Mutex
+)
+
+// lookupExec returns the exec with the given ID, or a not found error
+func lookupExec(id string) (*execSession, error) {
+	execSessionsMutex.Lock()
+	defer execSessionsMutex.Unlock()
+
+	e, ok := execSessions[id]
+	if !ok {
+		return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance '%s' found in daemon", id))
+	}
+	return e, nil
+}
+
+// forgetExecs drops the execs created in the container, which the engine otherwise holds for
+// the life of the process
+func forgetExecs(containerID string) {
+	execSessionsMutex.Lock()
+	defer execSessionsMutex.Unlock()
+
+	for id, e := range execSessions {
+		if e.containerID == containerID {
+			delete(execSessions, id)
+		}
+	}
+}
+
+// ContainerExecCreate adds a session to the running container. It's not launched until the exec
+// is started.
 func (c *Container) ContainerExecCreate(config *types.ExecConfig) (string, error) {
-	return "", fmt.Errorf("%s does not implement container.ContainerExecCreate", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecCreate"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecCreate failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	name := config.Container
+	if config.User != "" {
+		log.Warnf("Running exec in %s as user %s is not supported, running as the container user", name, config.User)
+	}
+	if config.Privileged {
+		log.Warnf("Running privileged exec in %s is not supported, running with the container privileges", name)
+	}
+
+	// the session is held until a client joins unless the exec is detached
+	attach := !config.Detach
+
+	// TODO: We need a resolved ID from the name
+	plExecParams := &exec.ContainerExecCreateParams{
+		ID: name,
+		ExecConfig: &models.ExecCreateConfig{
+			Cmd:       config.Cmd,
+			Tty:       &config.Tty,
+			OpenStdin: &config.AttachStdin,
+			Attach:    &attach,
+		},
+	}
+	createResults, err := client.Exec.ContainerExecCreate(plExecParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerExecCreateBadRequest:
+			return "", derr.NewBadRequestError(fmt.Errorf("Cannot create exec in container %s: %s", name, err.Payload.Message))
+		case *exec.ContainerExecCreateNotFound:
+			return "", derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerExecCreateConflict:
+			return "", derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+				http.StatusConflict)
+		case *exec.ContainerExecCreateInternalServerError:
+			return "", derr.NewErrorWithStatusCode(fmt.Errorf("Cannot create exec in container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	if createResults.Payload.SessionID == nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("Cannot create exec in container %s: no session returned", name),
+			http.StatusInternalServerError)
+	}
+	id := *createResults.Payload.SessionID
+
+	execSessionsMutex.Lock()
+	execSessions[id] = &execSession{
+		containerID: name,
+		config:      *config,
+	}
+	execSessionsMutex.Unlock()
+
+	return id, nil
 }
 
+// ContainerExecInspect describes an exec from the state of its session in the container
 func (c *Container) ContainerExecInspect(id string) (*backend.ExecInspect, error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerExecInspect", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecInspect"))
+
+	e, err := lookupExec(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecInspect failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	plInspectParams := &exec.ContainerInspectParams{ID: e.containerID}
+	inspectResults, err := client.Exec.ContainerInspect(plInspectParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerInspectNotFound:
+			// the container has gone, e.g. removed on exit, so none of its execs can be used again
+			forgetExecs(e.containerID)
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", e.containerID))
+		case *exec.ContainerInspectInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot inspect container %s: %s", e.containerID, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	inspect := &backend.ExecInspect{
+		ID: id,
+		ProcessConfig: &backend.ExecProcessConfig{
+			Tty: e.config.Tty,
+		},
+		OpenStdin:   e.config.AttachStdin,
+		OpenStdout:  e.config.AttachStdout,
+		OpenStderr:  e.config.AttachStderr,
+		ContainerID: e.containerID,
+	}
+	if len(e.config.Cmd) > 0 {
+		inspect.ProcessConfig.Entrypoint = e.config.Cmd[0]
+		inspect.ProcessConfig.Arguments = e.config.Cmd[1:]
+	}
+
+	// the session is dropped from the container if it's restarted, leaving the exec stopped
+	for _, session := range inspectResults.Payload.Sessions {
+		if session.ID == nil || *session.ID != id {
+			continue
+		}
+
+		finished := session.Finished != nil && *session.Finished != 0
+		inspect.Running = e.started && !finished
+		if finished && session.ExitCode != nil {
+			exitCode := int(*session.ExitCode)
+			inspect.ExitCode = &exitCode
+		}
+		if session.Path != nil {
+			inspect.ProcessConfig.Entrypoint = *session.Path
+		}
+	}
+
+	return inspect, nil
 }
 
 func (c *Container) ContainerExecResize(name string, height, width int) error {
 	return fmt.Errorf("%s does not implement container.ContainerExecResize", c.ProductName)
 }
 
+// ContainerExecStart launches the exec session in the container and, unless the exec is
+// detached, joins the client streams to it until it exits. The streams are already
+// multiplexed by the caller if the exec has no tty.
 func (c *Container) ContainerExecStart(name string, stdin io.ReadCloser, stdout io.Writer, stderr io.Writer) error {
-	return fmt.Errorf("%s does not implement container.ContainerExecStart", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecStart"))
+
+	e, err := lookupExec(name)
+	if err != nil {
+		return err
+	}
+
+	execSessionsMutex.Lock()
+	started := e.started
+	e.started = true
+	execSessionsMutex.Unlock()
+
+	if started {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Error: Exec command %s is already running", name),
+			http.StatusConflict)
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecStart failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	plStartParams := &exec.ContainerExecStartParams{ID: e.containerID, Session: name}
+	_, err = client.Exec.ContainerExecStart(plStartParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerExecStartNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance '%s' found in daemon", name))
+		case *exec.ContainerExecStartConflict:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", e.containerID),
+				http.StatusConflict)
+		case *exec.ContainerExecStartInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot start exec in container %s: %s", e.containerID, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	// detached
+	if stdout == nil {
+		return nil
+	}
+	if stdin != nil {
+		defer stdin.Close()
+	}
+
+	plConn, plReader, err := joinSession(e.containerID, name, e.config.AttachStdin, e.config.AttachStdout, e.config.AttachStderr)
+	if err != nil {
+		return err
+	}
+	defer plConn.Close()
+
+	if !e.config.AttachStdin {
+		stdin = nil
+	}
+	copyJoinedStreams(name, plConn, plReader, e.config.Tty, stdin, stdout, stderr)
+
+	return nil
 }
 
 func (c *Container) ExecExists(name string) (bool, error) {
-	return false, fmt.Errorf("%s does not implement container.ExecExists", c.ProductName)
+	if _, err := lookupExec(name); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // docker's container.copyBackend
 
+// ContainerArchivePath returns a tar archive of the file or directory at the path in the
+// container, along with its description. Regular files and directories are copied out of the
+// container by the tether over the backchannel, and directories are copied with the regular
+// files and directories in them. A symlink is archived as it is without being followed.
 func (c *Container) ContainerArchivePath(name string, path string) (content io.ReadCloser, stat *types.ContainerPathStat, err error) {
-	return nil, nil, fmt.Errorf("%s does not implement container.ContainerArchivePath", c.ProductName)
+	defer trace.End(trace.Begin("ContainerArchivePath"))
+
+	stat, err = c.ContainerStatPath(name, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case stat.Mode&os.ModeSymlink != 0:
+		hdr := &tar.Header{
+			Name:     stat.Name,
+			Mode:     int64(stat.Mode.Perm()),
+			ModTime:  stat.Mtime,
+			Typeflag: tar.TypeSymlink,
+			Linkname: stat.LinkTarget,
+		}
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err = tw.WriteHeader(hdr); err == nil {
+			err = tw.Close()
+		}
+		if err != nil {
+			return nil, nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+		return ioutil.NopCloser(&buf), stat, nil
+	case !stat.Mode.IsRegular() && !stat.Mode.IsDir():
+		return nil, nil, derr.NewBadRequestError(fmt.Errorf("Cannot copy %s from container %s: only regular files, directories and symlinks can be copied", path, name))
+	}
+
+	res, err := http.Get(portLayerArchiveURL(name, path, url.Values{}))
+	if err != nil {
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot copy %s from container %s: %s", path, name, err),
+			http.StatusInternalServerError)
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, stat, nil
+	case http.StatusNotFound:
+		defer res.Body.Close()
+		return nil, nil, derr.NewRequestNotFoundError(fmt.Errorf("Could not find the file %s in container %s", path, name))
+	case http.StatusConflict:
+		defer res.Body.Close()
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+			http.StatusConflict)
+	default:
+		defer res.Body.Close()
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot copy %s from container %s: %s", path, name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
 }
 
 func (c *Container) ContainerCopy(name string, res string) (io.ReadCloser, error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerCopy", c.ProductName)
+	defer trace.End(trace.Begin("ContainerCopy"))
+
+	content, _, err := c.ContainerArchivePath(name, res)
+	return content, err
 }
 
+// ContainerExport writes the filesystem of a stopped container to out as a tar. The generated
+// client can't stream the tar, so it's requested directly.
 func (c *Container) ContainerExport(name string, out io.Writer) error {
-	return fmt.Errorf("%s does not implement container.ContainerExport", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExport"))
+
+	host, err := os.Hostname()
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExport got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	u := url.URL{
+		Scheme: "http",
+		Host:   PortLayerServer(),
+		Path:   fmt.Sprintf("/storage/%s/export/%s", host, name),
+	}
+
+	res, err := http.Get(u.String())
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot export container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+	case http.StatusConflict:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot export container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusConflict)
+	default:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot export container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
+
+	// the response has been committed once the copy starts, so failures can only be logged
+	if _, err = io.Copy(out, res.Body); err != nil {
+		log.Errorf("Export of %s ended: %s", name, err)
+	}
+
+	return nil
 }
 
+// ContainerExtractToDir writes the regular files and directories in the tar archive into the
+// directory at the path in the container. Links and other special files in the archive are not
+// supported by the tether. Unless noOverwriteDirNonDir is set, a directory in the archive replaces
+// a file at its path and a file replaces a directory. The archive is streamed into the container
+// as it's read, so if it can't all be extracted the error names the entries that were copied
+// before stopping.
 func (c *Container) ContainerExtractToDir(name, path string, noOverwriteDirNonDir bool, content io.Reader) error {
-	return fmt.Errorf("%s does not implement container.ContainerExtractToDir", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExtractToDir"))
+
+	stat, err := c.ContainerStatPath(name, path)
+	if err != nil {
+		return err
+	}
+	if !stat.Mode.IsDir() {
+		return derr.NewBadRequestError(fmt.Errorf("extraction point is not a directory"))
+	}
+
+	query := url.Values{}
+	query.Set("noOverwriteDirNonDir", strconv.FormatBool(noOverwriteDirNonDir))
+	req, err := http.NewRequest("PUT", portLayerArchiveURL(name, path, query), ioutil.NopCloser(content))
+	if err != nil {
+		return derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot copy into %s in container %s: %s", path, name, err),
+			http.StatusInternalServerError)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest:
+		return derr.NewBadRequestError(fmt.Errorf("Cannot copy into %s in container %s: %s", path, name, portLayerErrorMessage(res)))
+	case http.StatusNotFound:
+		return derr.NewRequestNotFoundError(fmt.Errorf("Could not find the file %s in container %s", path, name))
+	case http.StatusConflict:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+			http.StatusConflict)
+	default:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot copy into %s in container %s: %s", path, name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
+}
+
+// ContainerStatPath describes the path in the container without following symlinks
+func (c *Container) ContainerStatPath(name string, path string) (stat *types.ContainerPathStat, err error) {
+	defer trace.End(trace.Begin("ContainerStatPath"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerStatPath failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	plStatParams := &interaction.ContainerStatPathParams{ID: name, Path: containerPath(path)}
+	statResults, err := client.Interaction.ContainerStatPath(plStatParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *interaction.ContainerStatPathNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("Could not find the file %s in container %s: %s", path, name, err.Payload.Message))
+		case *interaction.ContainerStatPathConflict:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+				http.StatusConflict)
+		case *interaction.ContainerStatPathInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot stat %s in container %s: %s", path, name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the interaction port layer"),
+			http.StatusInternalServerError)
+	}
+
+	result := statResults.Payload
+	stat = &types.ContainerPathStat{}
+	if result.Name != nil {
+		stat.Name = *result.Name
+	}
+	if result.Size != nil {
+		stat.Size = *result.Size
+	}
+	if result.Mode != nil {
+		stat.Mode = os.FileMode(*result.Mode)
+	}
+	if result.Mtime != nil {
+		stat.Mtime = time.Unix(0, *result.Mtime)
+	}
+	if result.LinkTarget != nil {
+		stat.LinkTarget = *result.LinkTarget
+	}
+
+	return stat, nil
+}
+
+// containerPath makes a path from the docker client absolute, as paths are relative to the root
+// of the container filesystem
+func containerPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return "/" + path
+}
+
+// portLayerArchiveURL returns the port layer URL for copying a tar archive of the path in the
+// container, with any other query parameters of the copy. The generated client can't stream
+// archives, nor hold a request open for the duration of a large copy, so it's requested directly.
+func portLayerArchiveURL(name, path string, query url.Values) string {
+	query.Set("path", containerPath(path))
+
+	// TODO: We need a resolved ID from the name
+	u := url.URL{
+		Scheme:   "http",
+		Host:     PortLayerServer(),
+		Path:     fmt.Sprintf("/interaction/%s/archive", name),
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// docker's container.stateBackend
+
+func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.ContainerCreateResponse, error) {
+	defer trace.End(trace.Begin("ContainerCreate"))
+
+	//TODO: validate the config parameters
+	log.Printf("createConfig - container = %+v", config.Config)
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return types.ContainerCreateResponse{},
+			derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate failed to create a portlayer client"),
+				http.StatusInternalServerError)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return types.ContainerCreateResponse{},
+			derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate got unexpected error getting hostname"),
+				http.StatusInternalServerError)
+	}
+
+	// Check if the image exist
+	layerID, err := lookupImage(host, config.Config.Image)
+	if err != nil {
+		return types.ContainerCreateResponse{}, err
+	}
+
+	// images carry the configuration they were pulled or committed with
+	if imageConf := imageConfig(host, layerID); imageConf != nil {
+		mergeConfig(config.Config, imageConf)
+	}
+
+	// Call the Exec port layer to create the container
+
+	plCreateParams := c.dockerContainerCreateParamsToPortlayer(config, layerID, host)
+
+	// the size of the container VM, with the VCH defaults applying to anything left unset
+	var warnings []string
+	if config.HostConfig != nil {
+		plCreateParams.CreateConfig.Resources, warnings, err = portLayerResources(config.HostConfig.Resources)
+		if err != nil {
+			return types.ContainerCreateResponse{}, derr.NewBadRequestError(err)
+		}
+
+		plCreateParams.CreateConfig.RestartPolicy = portLayerRestartPolicy(config.HostConfig.RestartPolicy)
+
+		// the port layer removes the container once it exits
+		autoRemove := config.HostConfig.AutoRemove
+		plCreateParams.CreateConfig.AutoRemove = &autoRemove
+	}
+
+	createResults, err := client.Exec.ContainerCreate(plCreateParams)
+
+	// transfer port layer swagger based response to Docker backend data structs and return to the REST front-end
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerCreateNotFound:
+			return types.ContainerCreateResponse{}, derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", layerID))
+		case *exec.ContainerCreateBadRequest:
+			return types.ContainerCreateResponse{}, derr.NewBadRequestError(fmt.Errorf("Cannot create container: %s", err.Payload.Message))
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return types.ContainerCreateResponse{},
+			derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"), http.StatusInternalServerError)
+	}
+
+	// Success!
+	log.Printf("container.ContainerCreate succeeded.  Returning container id %s", *createResults.Payload.ContainerID)
+	return types.ContainerCreateResponse{ID: *createResults.Payload.ContainerID, Warnings: warnings}, nil
 }
 
-func (c *Container) ContainerStatPath(name string, path string) (stat *types.ContainerPathStat, err error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerStatPath", c.ProductName)
+func (c *Container) ContainerKill(name string, sig uint64) error {
+	defer trace.End(trace.Begin("ContainerKill"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerKill failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// docker sends 0 when no signal was specified
+	if sig == 0 {
+		sig = uint64(syscall.SIGKILL)
+	}
+
+	// TODO: We need a resolved ID from the name
+	plSignalParams := &exec.ContainerSignalParams{ID: name, Signal: int64(sig)}
+	_, err := client.Exec.ContainerSignal(plSignalParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerSignalNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerSignalInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot kill container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// ContainerPause suspends the container VM or freezes the processes in it, as configured for the
+// VCH. Either way the container shows as paused.
+func (c *Container) ContainerPause(name string) error {
+	defer trace.End(trace.Begin(name))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerPause failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	_, err := client.Exec.ContainerPause(&exec.ContainerPauseParams{ID: name})
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerPauseNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerPauseConflict:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+				http.StatusConflict)
+		case *exec.ContainerPauseInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot pause container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// ContainerRename gives the container a new name, which must not be in use by another container
+func (c *Container) ContainerRename(oldName, newName string) error {
+	defer trace.End(trace.Begin(oldName))
+
+	newName = strings.TrimPrefix(newName, "/")
+	if !validContainerName.MatchString(newName) {
+		return derr.NewBadRequestError(fmt.Errorf("Invalid container name (%s), only %s are allowed", newName, containerNameChars))
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerRename failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	_, err := client.Exec.ContainerRename(&exec.ContainerRenameParams{ID: oldName, Name: newName})
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerRenameNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", oldName))
+		case *exec.ContainerRenameConflict:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Conflict. The name %q is already in use by another container", newName),
+				http.StatusConflict)
+		case *exec.ContainerRenameInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot rename container %s: %s", oldName, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+func (c *Container) ContainerResize(name string, height, width int) error {
+	return fmt.Errorf("%s does not implement container.ContainerResize", c.ProductName)
+}
+
+// ContainerRestart stops the container, giving it seconds to exit before it's powered off, and
+// starts it again
+func (c *Container) ContainerRestart(name string, seconds int) error {
+	defer trace.End(trace.Begin(name))
+
+	if err := c.ContainerStop(name, seconds); err != nil {
+		return err
+	}
+
+	return c.ContainerStart(name, nil)
+}
+
+func (c *Container) ContainerRm(name string, config *types.ContainerRmConfig) error {
+	defer trace.End(trace.Begin("ContainerRm"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerRm failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	force := config.ForceRemove
+	plRemoveParams := &exec.ContainerRemoveParams{ID: name, Force: &force}
+	_, err := client.Exec.ContainerRemove(plRemoveParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerRemoveNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerRemoveConflict:
+			return derr.NewRequestConflictError(fmt.Errorf("You cannot remove a running container %s. Stop the container before attempting removal or use -f", name))
+		case *exec.ContainerRemoveInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot remove container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	forgetExecs(name)
+
+	return nil
+}
+
+func (c *Container) ContainerStart(name string, hostConfig *container.HostConfig) error {
+	defer trace.End(trace.Begin("ContainerStart"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// handle legancy hostConfig
+	if hostConfig != nil {
+		// hostConfig exist for backwards compatibility.  TODO: Figure out which parameters we
+		// need to look at in hostConfig
+	}
+
+	// Start the container
+	// TODO: We need a resolved ID from the name
+	plStartParams := &exec.ContainerStartParams{ID: name}
+	_, err := client.Exec.ContainerStart(plStartParams)
+	if err != nil {
+		if _, isa := err.(*exec.ContainerStartNotFound); isa {
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+func (c *Container) ContainerStop(name string, seconds int) error {
+	defer trace.End(trace.Begin("ContainerStop"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerStop failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	timeout := int64(seconds)
+	plStopParams := &exec.ContainerStopParams{ID: name, Timeout: &timeout}
+	_, err := client.Exec.ContainerStop(plStopParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerStopNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerStopInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot stop container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// ContainerUnpause resumes a paused container
+func (c *Container) ContainerUnpause(name string) error {
+	defer trace.End(trace.Begin(name))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerUnpause failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	_, err := client.Exec.ContainerUnpause(&exec.ContainerUnpauseParams{ID: name})
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerUnpauseNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerUnpauseConflict:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not paused", name),
+				http.StatusConflict)
+		case *exec.ContainerUnpauseInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot unpause container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// ContainerUpdate changes the CPU and memory of the container VM. Those that can't be changed
+// while the container is running take effect when it is next started, and are reported as
+// warnings along with any settings that container VMs don't support.
+func (c *Container) ContainerUpdate(name string, hostConfig *container.HostConfig) ([]string, error) {
+	defer trace.End(trace.Begin(name))
+
+	if hostConfig == nil {
+		return nil, derr.NewBadRequestError(fmt.Errorf("No update config for container %s", name))
+	}
+
+	res, warnings, err := portLayerResources(hostConfig.Resources)
+	if err != nil {
+		return nil, derr.NewBadRequestError(err)
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerUpdate failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	updated, err := client.Exec.ContainerUpdate(&exec.ContainerUpdateParams{ID: name, Resources: res})
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerUpdateNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerUpdateBadRequest:
+			return nil, derr.NewBadRequestError(fmt.Errorf("Cannot update container %s: %s", name, err.Payload.Message))
+		case *exec.ContainerUpdateInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot update container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	// the restart policy is only changed if one is given, and only once the resources have been,
+	// so that an update the container can't take leaves the policy as it was
+	if policy := portLayerRestartPolicy(hostConfig.RestartPolicy); policy != nil {
+		// TODO: We need a resolved ID from the name
+		_, err = client.Exec.ContainerSetRestartPolicy(&exec.ContainerSetRestartPolicyParams{ID: name, Policy: policy})
+		if err != nil {
+			switch err := err.(type) {
+			case *exec.ContainerSetRestartPolicyNotFound:
+				return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+			case *exec.ContainerSetRestartPolicyBadRequest:
+				return nil, derr.NewBadRequestError(fmt.Errorf("Invalid restart policy for container %s: %s", name, err.Payload.Message))
+			case *exec.ContainerSetRestartPolicyInternalServerError:
+				return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot update container %s: %s", name, err.Payload.Message),
+					http.StatusInternalServerError)
+			}
+
+			// If we get here, most likely something went wrong with the port layer API server
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+				http.StatusInternalServerError)
+		}
+	}
+
+	for _, setting := range updated.Payload {
+		warnings = append(warnings, fmt.Sprintf("The %s of container %s will change when it is next started", setting, name))
+	}
+
+	return warnings, nil
+}
+
+// portLayerResources converts the docker resource settings that apply to container VMs, returning
+// warnings for any that are set but have no equivalent. The vCPU count is taken from the CPU count
+// or, failing that, the number of CPUs in the cpuset.
+func portLayerResources(res container.Resources) (*models.ContainerResources, []string, error) {
+	plres := &models.ContainerResources{}
+	var warnings []string
+
+	cpus := res.CPUCount
+	if cpus == 0 && res.CpusetCpus != "" {
+		n, err := cpusetCount(res.CpusetCpus)
+		if err != nil {
+			return nil, nil, err
+		}
+		cpus = int64(n)
+	}
+	if cpus > 0 {
+		plres.CPUCount = &cpus
+	}
+
+	if res.CPUShares > 0 {
+		shares := res.CPUShares
+		plres.CPUShares = &shares
+	}
+
+	// memory is allocated to VMs in megabytes
+	if res.Memory > 0 {
+		mb := (res.Memory + units.MiB - 1) / units.MiB
+		plres.MemoryMB = &mb
+	}
+	if res.MemoryReservation > 0 {
+		mb := (res.MemoryReservation + units.MiB - 1) / units.MiB
+		plres.MemoryReservationMB = &mb
+	}
+
+	unsupported := map[string]bool{
+		"blkio-weight":  res.BlkioWeight != 0,
+		"cpu-period":    res.CPUPeriod != 0,
+		"cpu-quota":     res.CPUQuota != 0,
+		"cpuset-mems":   res.CpusetMems != "",
+		"kernel-memory": res.KernelMemory != 0,
+		"memory-swap":   res.MemorySwap > 0,
+	}
+	var names []string
+	for name, set := range unsupported {
+		if set {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		warnings = append(warnings, fmt.Sprintf("The %s setting is not supported by container VMs and has been ignored", name))
+	}
+
+	return plres, warnings, nil
+}
+
+// portLayerRestartPolicy converts the docker restart policy, returning nil if none was given
+func portLayerRestartPolicy(policy container.RestartPolicy) *models.RestartPolicy {
+	if policy.Name == "" {
+		return nil
+	}
+
+	name := policy.Name
+	retries := int64(policy.MaximumRetryCount)
+	return &models.RestartPolicy{Name: &name, MaximumRetryCount: &retries}
+}
+
+// cpusetCount returns the number of CPUs in a cpuset list such as 0-2,4
+func cpusetCount(cpuset string) (int, error) {
+	cpus := make(map[int]bool)
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		low, err := strconv.Atoi(bounds[0])
+		if err != nil || low < 0 {
+			return 0, fmt.Errorf("Invalid cpuset %q", cpuset)
+		}
+		high := low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(bounds[1]); err != nil || high < low {
+				return 0, fmt.Errorf("Invalid cpuset %q", cpuset)
+			}
+		}
+
+		for cpu := low; cpu <= high; cpu++ {
+			cpus[cpu] = true
+		}
+	}
+
+	return len(cpus), nil
+}
+
+func (c *Container) ContainerWait(name string, timeout time.Duration) (int, error) {
+	defer trace.End(trace.Begin("ContainerWait"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return -1, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerWait failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// a negative timeout means wait forever
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		// keep each request well inside the client timeout, retrying until the container exits
+		wait := portLayerWaitInterval
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return -1, derr.NewErrorWithStatusCode(fmt.Errorf("Timed out waiting for container %s", name),
+					http.StatusRequestTimeout)
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		// TODO: We need a resolved ID from the name
+		seconds := int64(wait / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		plWaitParams := &exec.ContainerWaitParams{ID: name, Timeout: &seconds}
+		waitResults, err := client.Exec.ContainerWait(plWaitParams)
+		if err == nil {
+			if waitResults.Payload.ExitCode == nil {
+				return 0, nil
+			}
+			return int(*waitResults.Payload.ExitCode), nil
+		}
+
+		switch err := err.(type) {
+		case *exec.ContainerWaitRequestTimeout:
+			continue
+		case *exec.ContainerWaitNotFound:
+			return -1, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerWaitInternalServerError:
+			return -1, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot wait for container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return -1, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+}
+
+// docker's container.monitorBackend
+
+// ContainerChanges lists the changes made to the filesystem of a stopped container relative to
+// its image. Comparing the disks can take longer than the generated client allows for a request,
+// so the changes are requested directly.
+func (c *Container) ContainerChanges(name string) ([]archive.Change, error) {
+	defer trace.End(trace.Begin("ContainerChanges"))
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerChanges got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	u := url.URL{
+		Scheme: "http",
+		Host:   PortLayerServer(),
+		Path:   fmt.Sprintf("/storage/%s/changes/%s", host, name),
+	}
+
+	res, err := http.Get(u.String())
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list changes in container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+	case http.StatusConflict:
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list changes in container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusConflict)
+	default:
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list changes in container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
+
+	var plChanges []*models.Change
+	if err = json.NewDecoder(res.Body).Decode(&plChanges); err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list changes in container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+
+	changes := make([]archive.Change, 0, len(plChanges))
+	for _, change := range plChanges {
+		changes = append(changes, archive.Change{
+			Path: change.Path,
+			Kind: archive.ChangeType(change.Kind),
+		})
+	}
+
+	return changes, nil
+}
+
+func (c *Container) ContainerInspect(name string, size bool, version version.Version) (interface{}, error) {
+	defer trace.End(trace.Begin("ContainerInspect"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerInspect failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// TODO: We need a resolved ID from the name
+	plInspectParams := &exec.ContainerInspectParams{ID: name}
+	inspectResults, err := client.Exec.ContainerInspect(plInspectParams)
+	if err != nil {
+		switch err := err.(type) {
+		case *exec.ContainerInspectNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerInspectInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot inspect container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
+		}
+
+		// If we get here, most likely something went wrong with the port layer API server
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
+
+	return portlayerContainerDetailToDocker(inspectResults.Payload), nil
+}
+
+// ContainerLogs streams the session log of the container from the port layer. The generated
+// client can't stream a response or hold a request open while following, so the log endpoint is
+// requested directly.
+func (c *Container) ContainerLogs(name string, config *backend.ContainerLogsConfig, started chan struct{}) error {
+	defer trace.End(trace.Begin("ContainerLogs"))
+
+	if !(config.ShowStdout || config.ShowStderr) {
+		return derr.NewBadRequestError(fmt.Errorf("You must choose at least one stream"))
+	}
+
+	tty, err := c.containerTty(name)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("follow", strconv.FormatBool(config.Follow))
+	query.Set("timestamps", strconv.FormatBool(config.Timestamps))
+
+	if config.Tail != "" && config.Tail != "all" {
+		tail, err := strconv.ParseInt(config.Tail, 10, 64)
+		if err != nil || tail < 0 {
+			return derr.NewBadRequestError(fmt.Errorf("Invalid tail value: %s", config.Tail))
+		}
+		query.Set("tail", strconv.FormatInt(tail, 10))
+	}
+
+	if config.Since != "" {
+		since, _, err := timetypes.ParseTimestamps(config.Since, 0)
+		if err != nil {
+			return derr.NewBadRequestError(err)
+		}
+		query.Set("since", strconv.FormatInt(since, 10))
+	}
+
+	// TODO: We need a resolved ID from the name
+	u := url.URL{
+		Scheme:   "http",
+		Host:     PortLayerServer(),
+		Path:     fmt.Sprintf("/exec/%s/logs", name),
+		RawQuery: query.Encode(),
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	// abandon the request if the client goes away
+	cancel := make(chan struct{})
+	req.Cancel = cancel
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-config.Stop:
+			close(cancel)
+		case <-done:
+		}
+	}()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot get logs for container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+	default:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot get logs for container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
+
+	close(started)
+
+	// output is multiplexed unless the container has a tty; the session log does not separate
+	// the streams so it's all presented as stdout
+	out := config.OutStream
+	if !tty {
+		out = stdcopy.NewStdWriter(out, stdcopy.Stdout)
+	}
+	if _, err = io.Copy(out, res.Body); err != nil {
+		log.Debugf("Log stream for %s ended: %s", name, err)
+	}
+
+	return nil
 }
 
-// docker's container.stateBackend
+// portLayerErrorMessage extracts the message from an error response made without the generated client
+func portLayerErrorMessage(res *http.Response) string {
+	var payload models.Error
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil || payload.Message == "" {
+		return res.Status
+	}
 
-func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.ContainerCreateResponse, error) {
-	defer trace.End(trace.Begin("ContainerCreate"))
+	return payload.Message
+}
 
-	//TODO: validate the config parameters
-	log.Printf("createConfig - container = %+v", config.Config)
+// ContainerStats writes docker stats for the container, built from the vSphere performance samples
+// of its VM. The usage docker reports as a running total is accumulated from the samples seen since
+// the request was made. vSphere samples VMs every 20 seconds, so that's how often stats change when
+// streaming.
+func (c *Container) ContainerStats(name string, config *backend.ContainerStatsConfig) error {
+	defer trace.End(trace.Begin("ContainerStats"))
 
 	// Get an API client to the portlayer
 	client := PortLayerClient()
 	if client == nil {
-		return types.ContainerCreateResponse{},
-			derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate failed to create a portlayer client"),
-				http.StatusInternalServerError)
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerStats failed to create a portlayer client"),
+			http.StatusInternalServerError)
 	}
 
-	// Check if the image exist
-	layerID, found := hackMap[config.Config.Image]
-	if !found {
-		return types.ContainerCreateResponse{},
-			derr.NewErrorWithStatusCode(fmt.Errorf("Container look up failed"),
-				http.StatusInternalServerError)
+	// the earlier sample gives the first stats a previous CPU reading to compare against
+	samples, err := containerStats(name, 2)
+	if err != nil {
+		return err
 	}
 
-	// Call the Exec port layer to create the container
-	host, err := os.Hostname()
-	if err != nil {
-		return types.ContainerCreateResponse{},
-			derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate got unexpected error getting hostname"),
-				http.StatusInternalServerError)
+	acc := &statsAccumulator{}
+	for _, sample := range samples {
+		acc.add(sample)
 	}
 
-	plCreateParams := c.dockerContainerCreateParamsToPortlayer(config, layerID, host)
-	createResults, err := client.Exec.ContainerCreate(plCreateParams)
+	enc := json.NewEncoder(config.OutStream)
+	if err = acc.encode(enc, config.Version); err != nil || !config.Stream {
+		return nil
+	}
 
-	// transfer port layer swagger based response to Docker backend data structs and return to the REST front-end
+	for {
+		select {
+		case <-config.Stop:
+			return nil
+		case <-time.After(statsPollInterval):
+		}
+
+		samples, err = containerStats(name, 1)
+		if err != nil {
+			// the response has been committed so failures can only be logged
+			log.Errorf("Stats stream for %s ended: %s", name, err)
+			return nil
+		}
+
+		// there are no samples once the container has stopped
+		if len(samples) == 0 {
+			return nil
+		}
+
+		if !acc.add(samples[0]) {
+			continue
+		}
+
+		if err = acc.encode(enc, config.Version); err != nil {
+			log.Debugf("Stats stream for %s ended: %s", name, err)
+			return nil
+		}
+	}
+}
+
+// statsPollInterval is how often the port layer is checked for a new sample when streaming stats
+var statsPollInterval = 5 * time.Second
+
+// containerStats returns up to the given number of the most recent performance samples of the
+// container, oldest first
+func containerStats(name string, samples int64) ([]*models.ContainerStats, error) {
+	// TODO: We need a resolved ID from the name
+	plStatsParams := &exec.ContainerStatsParams{ID: name, Samples: &samples}
+	results, err := PortLayerClient().Exec.ContainerStats(plStatsParams)
 	if err != nil {
-		if _, isa := err.(*exec.ContainerCreateNotFound); isa {
-			return types.ContainerCreateResponse{}, derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", layerID))
+		switch err := err.(type) {
+		case *exec.ContainerStatsNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *exec.ContainerStatsInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot get stats for container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
 		}
 
 		// If we get here, most likely something went wrong with the port layer API server
-		return types.ContainerCreateResponse{},
-			derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"), http.StatusInternalServerError)
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
 	}
 
-	// Success!
-	log.Printf("container.ContainerCreate succeeded.  Returning container id %s", *createResults.Payload.ContainerID)
-	return types.ContainerCreateResponse{ID: *createResults.Payload.ContainerID}, nil
+	return results.Payload, nil
 }
 
-func (c *Container) ContainerKill(name string, sig uint64) error {
-	return fmt.Errorf("%s does not implement container.ContainerKill", c.ProductName)
-}
+// statsNetwork is the name the network usage of a container VM is reported under, as the
+// samples cover all of its NICs
+const statsNetwork = "eth0"
 
-func (c *Container) ContainerPause(name string) error {
-	return fmt.Errorf("%s does not implement container.ContainerPause", c.ProductName)
-}
+// statsAccumulator builds docker stats from successive performance samples
+type statsAccumulator struct {
+	last  *models.ContainerStats
+	stats types.StatsJSON
 
-func (c *Container) ContainerRename(oldName, newName string) error {
-	return fmt.Errorf("%s does not implement container.ContainerRename", c.ProductName)
+	diskReadBytes  uint64
+	diskWriteBytes uint64
+	diskReads      uint64
+	diskWrites     uint64
 }
 
-func (c *Container) ContainerResize(name string, height, width int) error {
-	return fmt.Errorf("%s does not implement container.ContainerResize", c.ProductName)
+// add folds the sample into the stats, returning false if it isn't newer than the last one added
+func (a *statsAccumulator) add(sample *models.ContainerStats) bool {
+	if a.last != nil && sample.Read <= a.last.Read {
+		return false
+	}
+	a.last = sample
+
+	s := &a.stats
+	s.Read = time.Unix(sample.Read, 0).UTC()
+	s.PreCPUStats = s.CPUStats
+
+	// docker works out the CPU percentage relative to the system usage of all of the CPUs
+	cpu := &s.CPUStats
+	cpu.CPUUsage.TotalUsage += uint64(sample.CPUUsed)
+	cpu.SystemUsage += uint64(sample.Interval * int64(time.Second) * sample.NumCpus)
+
+	// the usage of each vCPU isn't sampled, but docker needs to know how many there are
+	if sample.NumCpus > 0 {
+		percpu := make([]uint64, sample.NumCpus)
+		for i := range percpu {
+			percpu[i] = cpu.CPUUsage.TotalUsage / uint64(sample.NumCpus)
+		}
+		cpu.CPUUsage.PercpuUsage = percpu
+	}
+
+	s.MemoryStats.Usage = uint64(sample.MemoryUsage)
+	if s.MemoryStats.Usage > s.MemoryStats.MaxUsage {
+		s.MemoryStats.MaxUsage = s.MemoryStats.Usage
+	}
+	s.MemoryStats.Limit = uint64(sample.MemoryLimit)
+
+	if s.Networks == nil {
+		s.Networks = make(map[string]types.NetworkStats)
+	}
+	network := s.Networks[statsNetwork]
+	network.RxBytes += uint64(sample.NetworkRxBytes)
+	network.TxBytes += uint64(sample.NetworkTxBytes)
+	network.RxPackets += uint64(sample.NetworkRxPackets)
+	network.TxPackets += uint64(sample.NetworkTxPackets)
+	s.Networks[statsNetwork] = network
+
+	a.diskReadBytes += uint64(sample.DiskReadBytes)
+	a.diskWriteBytes += uint64(sample.DiskWriteBytes)
+	a.diskReads += uint64(sample.DiskReads)
+	a.diskWrites += uint64(sample.DiskWrites)
+	s.BlkioStats.IoServiceBytesRecursive = blkioEntries(a.diskReadBytes, a.diskWriteBytes)
+	s.BlkioStats.IoServicedRecursive = blkioEntries(a.diskReads, a.diskWrites)
+
+	return true
 }
 
-func (c *Container) ContainerRestart(name string, seconds int) error {
-	return fmt.Errorf("%s does not implement container.ContainerRestart", c.ProductName)
+// blkioEntries returns the block IO stats entries for the read and write totals
+func blkioEntries(read, write uint64) []types.BlkioStatEntry {
+	return []types.BlkioStatEntry{
+		{Op: "Read", Value: read},
+		{Op: "Write", Value: write},
+		{Op: "Total", Value: read + write},
+	}
 }
 
-func (c *Container) ContainerRm(name string, config *types.ContainerRmConfig) error {
-	return fmt.Errorf("%s does not implement container.ContainerRm", c.ProductName)
+// encode writes the stats in the form expected by clients of the API version
+func (a *statsAccumulator) encode(enc *json.Encoder, apiVersion string) error {
+	stats := a.stats
+	if a.last == nil {
+		// a container that isn't running has nothing to report
+		stats.Read = time.Now().UTC()
+	}
+
+	if version.Version(apiVersion).LessThan("1.21") {
+		return enc.Encode(&v1p20.StatsJSON{
+			Stats:   stats.Stats,
+			Network: stats.Networks[statsNetwork],
+		})
+	}
+
+	return enc.Encode(&stats)
 }
 
-func (c *Container) ContainerStart(name string, hostConfig *container.HostConfig) error {
-	defer trace.End(trace.Begin("ContainerStart"))
+// ContainerTop lists the processes running in the container. The ps arguments select the columns
+// of the list but not which processes are listed, as the container has its own VM.
+func (c *Container) ContainerTop(name string, psArgs string) (*types.ContainerProcessList, error) {
+	defer trace.End(trace.Begin(name))
 
 	// Get an API client to the portlayer
 	client := PortLayerClient()
 	if client == nil {
-		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerCreate failed to create a portlayer client"),
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerTop failed to create a portlayer client"),
 			http.StatusInternalServerError)
 	}
 
-	// handle legancy hostConfig
-	if hostConfig != nil {
-		// hostConfig exist for backwards compatibility.  TODO: Figure out which parameters we
-		// need to look at in hostConfig
+	// docker lists processes with ps -ef unless told otherwise
+	if psArgs == "" {
+		psArgs = "-ef"
 	}
 
-	// Start the container
 	// TODO: We need a resolved ID from the name
-	plStartParams := &exec.ContainerStartParams{ID: name}
-	_, err := client.Exec.ContainerStart(plStartParams)
+	params := &interaction.ContainerTopParams{ID: name, PsArgs: &psArgs}
+	res, err := client.Interaction.ContainerTop(params)
 	if err != nil {
-		if _, isa := err.(*exec.ContainerStartNotFound); isa {
-			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		switch err := err.(type) {
+		case *interaction.ContainerTopNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		case *interaction.ContainerTopConflict:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Container %s is not running", name),
+				http.StatusConflict)
+		case *interaction.ContainerTopInternalServerError:
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list processes in container %s: %s", name, err.Payload.Message),
+				http.StatusInternalServerError)
 		}
 
 		// If we get here, most likely something went wrong with the port layer API server
-		return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the interaction port layer"),
 			http.StatusInternalServerError)
 	}
 
-	return nil
+	return &types.ContainerProcessList{
+		Titles:    res.Payload.Titles,
+		Processes: res.Payload.Processes,
+	}, nil
 }
 
-func (c *Container) ContainerStop(name string, seconds int) error {
-	return fmt.Errorf("%s does not implement container.ContainerStop", c.ProductName)
-}
+func (c *Container) Containers(config *types.ContainerListOptions) ([]*types.Container, error) {
+	defer trace.End(trace.Begin("Containers"))
 
-func (c *Container) ContainerUnpause(name string) error {
-	return fmt.Errorf("%s does not implement container.ContainerUnpause", c.ProductName)
-}
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.Containers failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
 
-func (c *Container) ContainerUpdate(name string, hostConfig *container.HostConfig) ([]string, error) {
-	return make([]string, 0, 0), fmt.Errorf("%s does not implement container.ContainerUpdate", c.ProductName)
-}
+	if err := config.Filter.Validate(acceptedPsFilterTags); err != nil {
+		return nil, derr.NewBadRequestError(err)
+	}
 
-func (c *Container) ContainerWait(name string, timeout time.Duration) (int, error) {
-	return 0, fmt.Errorf("%s does not implement container.ContainerWait", c.ProductName)
-}
+	// --last and --latest consider containers in any state, as does filtering on status
+	all := config.All || config.Limit > 0
+	err := config.Filter.WalkValues("status", func(value string) error {
+		if !validPsStatusFilterValues[value] {
+			return fmt.Errorf("Unrecognised filter value for status: %s", value)
+		}
+		all = true
+		return nil
+	})
+	if err != nil {
+		return nil, derr.NewBadRequestError(err)
+	}
 
-// docker's container.monitorBackend
+	plListParams := &exec.ContainerListParams{All: &all}
+	listResults, err := client.Exec.ContainerList(plListParams)
+	if err != nil {
+		if e, isa := err.(*exec.ContainerListInternalServerError); isa {
+			return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot list containers: %s", e.Payload.Message),
+				http.StatusInternalServerError)
+		}
 
-func (c *Container) ContainerChanges(name string) ([]archive.Change, error) {
-	return make([]archive.Change, 0, 0), fmt.Errorf("%s does not implement container.ContainerChanges", c.ProductName)
-}
+		// If we get here, most likely something went wrong with the port layer API server
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the exec port layer"),
+			http.StatusInternalServerError)
+	}
 
-func (c *Container) ContainerInspect(name string, size bool, version version.Version) (interface{}, error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerInspect", c.ProductName)
-}
+	containers := make([]*types.Container, 0, len(listResults.Payload))
+	for _, info := range listResults.Payload {
+		container := portlayerContainerInfoToDocker(info)
+		if !includeContainer(container, config.Filter) {
+			continue
+		}
+		containers = append(containers, container)
+	}
 
-func (c *Container) ContainerLogs(name string, config *backend.ContainerLogsConfig, started chan struct{}) error {
-	return fmt.Errorf("%s does not implement container.ContainerLogs", c.ProductName)
+	// newest first, as docker presents them
+	sort.Sort(containersByCreated(containers))
+	if config.Limit > 0 && len(containers) > config.Limit {
+		containers = containers[:config.Limit]
+	}
+
+	return containers, nil
 }
 
-func (c *Container) ContainerStats(name string, config *backend.ContainerStatsConfig) error {
-	return fmt.Errorf("%s does not implement container.ContainerStats", c.ProductName)
+// docker's container.attachBackend
+
+// ContainerAttach joins the client streams to the container through the port layer interaction
+// service.
+func (c *Container) ContainerAttach(name string, cac *backend.ContainerAttachConfig) error {
+	defer trace.End(trace.Begin("ContainerAttach"))
+
+	tty, err := c.containerTty(name)
+	if err != nil {
+		return err
+	}
+
+	if cac.Logs {
+		log.Warnf("Replaying logs on attach is not supported, attaching to %s without logs", name)
+	}
+	if !cac.Stream {
+		return nil
+	}
+
+	plConn, plReader, err := joinSession(name, "", cac.UseStdin, cac.UseStdout, cac.UseStderr)
+	if err != nil {
+		return err
+	}
+	defer plConn.Close()
+
+	stdin, stdout, stderr, err := cac.GetStreams()
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot attach to container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+	if stdin != nil {
+		defer stdin.Close()
+	}
+
+	if !cac.UseStdin {
+		stdin = nil
+	}
+	if !tty && cac.MuxStreams {
+		stdout = stdcopy.NewStdWriter(stdout, stdcopy.Stdout)
+		stderr = stdcopy.NewStdWriter(stderr, stdcopy.Stderr)
+	}
+	copyJoinedStreams(name, plConn, plReader, tty, stdin, stdout, stderr)
+
+	return nil
 }
 
-func (c *Container) ContainerTop(name string, psArgs string) (*types.ContainerProcessList, error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerTop", c.ProductName)
+// joinSession makes a join request to the port layer interaction service for a session of the
+// container, the primary session if none is given. The port layer hijacks the join request, so
+// it's made directly rather than through the generated client. The connection is returned once
+// the join has been accepted, with a reader for the session output.
+func joinSession(name, session string, useStdin, useStdout, useStderr bool) (net.Conn, *bufio.Reader, error) {
+	query := url.Values{}
+	query.Set("stdin", strconv.FormatBool(useStdin))
+	query.Set("stdout", strconv.FormatBool(useStdout))
+	query.Set("stderr", strconv.FormatBool(useStderr))
+	if session != "" {
+		query.Set("session", session)
+	}
+
+	// TODO: We need a resolved ID from the name
+	u := url.URL{
+		Scheme:   "http",
+		Host:     PortLayerServer(),
+		Path:     fmt.Sprintf("/interaction/%s/join", name),
+		RawQuery: query.Encode(),
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return nil, nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	plConn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot attach to container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+
+	if err = req.Write(plConn); err != nil {
+		plConn.Close()
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot attach to container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+
+	// the port layer responds once the join is registered, which must happen before the client
+	// is told it's attached as it may then start the container
+	plReader := bufio.NewReader(plConn)
+	res, err := http.ReadResponse(plReader, req)
+	if err != nil {
+		plConn.Close()
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot attach to container %s: %s", name, err),
+			http.StatusInternalServerError)
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return plConn, plReader, nil
+	case http.StatusNotFound:
+		plConn.Close()
+		if session != "" {
+			return nil, nil, derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance '%s' found in daemon", session))
+		}
+		return nil, nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+	default:
+		plConn.Close()
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Cannot attach to container %s: %s", name, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
 }
 
-func (c *Container) Containers(config *types.ContainerListOptions) ([]*types.Container, error) {
-	return nil, fmt.Errorf("%s does not implement container.Containers", c.ProductName)
+// copyJoinedStreams copies stdin, if not nil, to a joined session and its output to stdout and
+// stderr until the session ends. The port layer multiplexes the output of sessions without a tty.
+func copyJoinedStreams(name string, plConn net.Conn, plReader io.Reader, tty bool, stdin io.Reader, stdout, stderr io.Writer) {
+	if stdin != nil {
+		go func() {
+			// TODO: detach keys are not yet supported
+			if _, err := io.Copy(plConn, stdin); err != nil {
+				log.Debugf("Input to %s ended: %s", name, err)
+			}
+			if tcp, ok := plConn.(*net.TCPConn); ok {
+				tcp.CloseWrite()
+			}
+		}()
+	}
+
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, plReader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, plReader)
+	}
+	if err != nil {
+		log.Debugf("Output from %s ended: %s", name, err)
+	}
 }
 
-// docker's container.attachBackend
+// containerTty returns whether the primary session of the container has a tty, which
+// determines whether its output streams are multiplexed
+func (c *Container) containerTty(name string) (bool, error) {
+	info, err := c.ContainerInspect(name, false, "")
+	if err != nil {
+		return false, err
+	}
 
-func (c *Container) ContainerAttach(name string, cac *backend.ContainerAttachConfig) error {
-	return fmt.Errorf("%s does not implement container.ContainerAttach", c.ProductName)
+	detail := info.(*types.ContainerJSON)
+	return detail.Config != nil && detail.Config.Tty, nil
 }
 
 //----------
@@ -256,15 +1739,26 @@ func (c *Container) ContainerAttach(name string, cac *backend.ContainerAttachCon
 //----------
 
 func (c *Container) dockerContainerCreateParamsToPortlayer(cc types.ContainerCreateConfig, layerID string, imageStore string) *exec.ContainerCreateParams {
-	//TODO: Fill in the name
 	portLayerConfig := &exec.ContainerCreateParams{Name: nil}
 
+	// Name - the port layer generates one if not supplied
+	if name := strings.TrimPrefix(cc.Name, "/"); name != "" {
+		portLayerConfig.Name = &name
+	}
+
 	portLayerConfig.CreateConfig = &models.ContainerCreateConfig{}
 
 	// Image
 	portLayerConfig.CreateConfig.Image = new(string)
 	*portLayerConfig.CreateConfig.Image = layerID
 
+	// the image reference as the user supplied it
+	portLayerConfig.CreateConfig.ImageName = new(string)
+	*portLayerConfig.CreateConfig.ImageName = cc.Config.Image
+
+	// labels
+	portLayerConfig.CreateConfig.Labels = cc.Config.Labels
+
 	// copy the cmd array
 	portLayerConfig.CreateConfig.Cmd = make([]string, len(cc.Config.Cmd))
 	copy(portLayerConfig.CreateConfig.Cmd, cc.Config.Cmd)
@@ -288,9 +1782,248 @@ func (c *Container) dockerContainerCreateParamsToPortlayer(cc types.ContainerCre
 	portLayerConfig.CreateConfig.WorkingDir = new(string)
 	*portLayerConfig.CreateConfig.WorkingDir = cc.Config.WorkingDir
 
+	// interaction
+	portLayerConfig.CreateConfig.Tty = new(bool)
+	*portLayerConfig.CreateConfig.Tty = cc.Config.Tty
+	portLayerConfig.CreateConfig.OpenStdin = new(bool)
+	*portLayerConfig.CreateConfig.OpenStdin = cc.Config.OpenStdin
+	portLayerConfig.CreateConfig.StdinOnce = new(bool)
+	*portLayerConfig.CreateConfig.StdinOnce = cc.Config.StdinOnce
+
 	return portLayerConfig
 }
 
+// acceptedPsFilterTags are the filters supported by docker ps
+var acceptedPsFilterTags = map[string]bool{
+	"id":     true,
+	"label":  true,
+	"name":   true,
+	"status": true,
+}
+
+// validPsStatusFilterValues are the container states docker ps can filter on
+var validPsStatusFilterValues = map[string]bool{
+	"created":    true,
+	"dead":       true,
+	"exited":     true,
+	"paused":     true,
+	"restarting": true,
+	"running":    true,
+}
+
+// portlayerContainerInfoToDocker converts the port layer view of a container to the docker ps form
+func portlayerContainerInfoToDocker(info *models.ContainerInfo) *types.Container {
+	container := &types.Container{
+		Command: strings.Join(info.Cmd, " "),
+		Labels:  info.Labels,
+	}
+
+	if info.ContainerID != nil {
+		container.ID = *info.ContainerID
+	}
+	if info.Name != nil {
+		container.Names = []string{"/" + *info.Name}
+	}
+	if info.ImageID != nil {
+		container.ImageID = *info.ImageID
+		container.Image = *info.ImageID
+	}
+	if info.ImageName != nil && *info.ImageName != "" {
+		container.Image = *info.ImageName
+	}
+	if info.Created != nil {
+		container.Created = *info.Created
+	}
+	if info.State != nil {
+		container.State, container.Status = dockerState(*info.State)
+	}
+
+	return container
+}
+
+// portlayerContainerDetailToDocker converts the port layer container detail to the docker inspect form
+func portlayerContainerDetailToDocker(detail *models.ContainerDetail) *types.ContainerJSON {
+	base := &types.ContainerJSONBase{
+		State:      &types.ContainerState{},
+		Driver:     "vsphere",
+		HostConfig: &container.HostConfig{},
+	}
+	config := &container.Config{
+		Labels: detail.Labels,
+	}
+	settings := &types.NetworkSettings{
+		Networks: make(map[string]*apinet.EndpointSettings),
+	}
+
+	if detail.ContainerID != nil {
+		base.ID = *detail.ContainerID
+		config.Hostname = stringid.TruncateID(*detail.ContainerID)
+	}
+	if detail.Name != nil {
+		base.Name = "/" + *detail.Name
+	}
+	if detail.ImageID != nil {
+		base.Image = *detail.ImageID
+		config.Image = *detail.ImageID
+	}
+	if detail.ImageName != nil && *detail.ImageName != "" {
+		config.Image = *detail.ImageName
+	}
+	if detail.Created != nil {
+		base.Created = time.Unix(*detail.Created, 0).UTC().Format(time.RFC3339Nano)
+	}
+	if detail.State != nil {
+		base.State.Status, _ = dockerState(*detail.State)
+		// as with docker, a paused container is still running
+		base.State.Paused = *detail.State == "Suspended"
+		base.State.Running = *detail.State == "Running" || base.State.Paused
+	}
+	if detail.ExitCode != nil {
+		base.State.ExitCode = int(*detail.ExitCode)
+	}
+	if detail.RestartCount != nil {
+		base.RestartCount = int(*detail.RestartCount)
+	}
+	if detail.AutoRemove != nil {
+		base.HostConfig.AutoRemove = *detail.AutoRemove
+	}
+	if detail.RestartPolicy != nil {
+		if detail.RestartPolicy.Name != nil {
+			base.HostConfig.RestartPolicy.Name = *detail.RestartPolicy.Name
+		}
+		if detail.RestartPolicy.MaximumRetryCount != nil {
+			base.HostConfig.RestartPolicy.MaximumRetryCount = int(*detail.RestartPolicy.MaximumRetryCount)
+		}
+	}
+
+	// the primary session is presented first and describes the container process
+	if len(detail.Sessions) > 0 {
+		session := detail.Sessions[0]
+		if session.Path != nil {
+			base.Path = *session.Path
+		}
+		if len(session.Args) > 0 {
+			base.Args = session.Args[1:]
+		}
+		config.Cmd = strslice.StrSlice(session.Args)
+		config.Env = session.Env
+		if session.WorkingDir != nil {
+			config.WorkingDir = *session.WorkingDir
+		}
+		if session.Tty != nil {
+			config.Tty = *session.Tty
+		}
+		if session.Finished != nil && *session.Finished != 0 && !base.State.Running {
+			base.State.FinishedAt = time.Unix(*session.Finished, 0).UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	mounts := make([]types.MountPoint, 0, len(detail.Mounts))
+	for _, m := range detail.Mounts {
+		mount := types.MountPoint{Driver: "vsphere"}
+		if m.Name != nil {
+			mount.Name = *m.Name
+		}
+		if m.Source != nil {
+			mount.Source = *m.Source
+		}
+		if m.Destination != nil {
+			mount.Destination = *m.Destination
+		}
+		if m.Mode != nil {
+			mount.Mode = *m.Mode
+			mount.RW = !strings.Contains(*m.Mode, "ro")
+		}
+		mounts = append(mounts, mount)
+	}
+
+	for i, ep := range detail.Endpoints {
+		endpoint := &apinet.EndpointSettings{}
+		if ep.IPAddress != nil {
+			endpoint.IPAddress = *ep.IPAddress
+		}
+		if ep.IPPrefixLen != nil {
+			endpoint.IPPrefixLen = int(*ep.IPPrefixLen)
+		}
+		if ep.Gateway != nil {
+			endpoint.Gateway = *ep.Gateway
+		}
+		if ep.MacAddress != nil {
+			endpoint.MacAddress = *ep.MacAddress
+		}
+
+		name := fmt.Sprintf("network%d", i)
+		if ep.Network != nil && *ep.Network != "" {
+			name = *ep.Network
+		}
+		settings.Networks[name] = endpoint
+
+		// the first endpoint stands in for the default network
+		if i == 0 {
+			settings.IPAddress = endpoint.IPAddress
+			settings.IPPrefixLen = endpoint.IPPrefixLen
+			settings.Gateway = endpoint.Gateway
+			settings.MacAddress = endpoint.MacAddress
+		}
+	}
+
+	return &types.ContainerJSON{
+		ContainerJSONBase: base,
+		Mounts:            mounts,
+		Config:            config,
+		NetworkSettings:   settings,
+	}
+}
+
+// dockerState maps the port layer container state to the docker state and status strings
+func dockerState(state string) (string, string) {
+	switch state {
+	case "Running":
+		return "running", "Up"
+	case "Suspended":
+		return "paused", "Up (Paused)"
+	default:
+		return "exited", "Exited"
+	}
+}
+
+// includeContainer applies the docker ps filters to the container
+func includeContainer(container *types.Container, psFilters filters.Args) bool {
+	if !psFilters.Match("id", container.ID) {
+		return false
+	}
+
+	if psFilters.Include("name") {
+		matched := false
+		for _, name := range container.Names {
+			if psFilters.Match("name", name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if !psFilters.MatchKVList("label", container.Labels) {
+		return false
+	}
+
+	if psFilters.Include("status") && !psFilters.ExactMatch("status", container.State) {
+		return false
+	}
+
+	return true
+}
+
+// containersByCreated sorts containers newest first
+type containersByCreated []*types.Container
+
+func (c containersByCreated) Len() int           { return len(c) }
+func (c containersByCreated) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c containersByCreated) Less(i, j int) bool { return c[i].Created > c[j].Created }
+
 func (c *Container) imageExist(imageID string) (storeName string, err error) {
 	// Call the storage port layer to determine if the image currently exist
 	host, err := os.Hostname()
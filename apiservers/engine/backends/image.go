@@ -15,49 +15,830 @@
 package vicbackends
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
-
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	derr "github.com/docker/docker/errors"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/reference"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
+	"github.com/docker/engine-api/types/filters"
 	"github.com/docker/engine-api/types/registry"
+	"github.com/go-swagger/go-swagger/swag"
+
+	"github.com/vmware/vic/apiservers/portlayer/client/storage"
+	"github.com/vmware/vic/apiservers/portlayer/models"
+	"github.com/vmware/vic/pkg/trace"
 )
 
 type Image struct {
 	ProductName string
 }
 
+// the configuration of images, by image ID, as saved in the metadata of their layers
+var (
+	imageConfigs      = make(map[string]*image.V1Image)
+	imageConfigsMutex sync.Mutex
+)
+
+// scratchImageID is the ID of the root layer of the image store, which isn't an image itself
+const scratchImageID = "scratch"
+
+// imageMetadataKey is the key the configuration of an image is saved under in the metadata of
+// its layer, by imagec when images are pulled and by Commit
+const imageMetadataKey = "v1Compatibility"
+
+// acceptedImageFilterTags are the filters docker images supports
+var acceptedImageFilterTags = map[string]bool{
+	"dangling": true,
+}
+
+// lookupImage returns the ID of the top layer of the image the name refers to. The name is
+// either a reference as the client supplied it, with references to the default tag also
+// available by the repository name alone, or the ID of the image or an unambiguous prefix of it.
+func lookupImage(storeName, name string) (string, error) {
+	refs, err := listReferences(storeName)
+	if err != nil {
+		return "", err
+	}
+
+	if ref, err := reference.ParseNamed(name); err == nil {
+		if id, ok := resolveReference(refs, reference.WithDefaultTag(ref)); ok {
+			return id, nil
+		}
+	}
+
+	layers, err := listImages(storeName)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, layer := range layers {
+		if layer.ID == name {
+			return layer.ID, nil
+		}
+		if name != "" && layer.ID != scratchImageID && strings.HasPrefix(layer.ID, name) {
+			ids = append(ids, layer.ID)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", name))
+	case 1:
+		return ids[0], nil
+	default:
+		return "", derr.NewBadRequestError(fmt.Errorf("Short identifier %s is ambiguous", name))
+	}
+}
+
+// resolveReference returns the ID of the image the reference refers to, if any
+func resolveReference(refs []*models.ImageReference, ref reference.Named) (string, bool) {
+	for _, r := range refs {
+		if r.Repository != ref.Name() {
+			continue
+		}
+
+		switch ref := ref.(type) {
+		case reference.NamedTagged:
+			if r.Tag != nil && *r.Tag == ref.Tag() {
+				return r.ImageID, true
+			}
+		case reference.Canonical:
+			if r.Digest != nil && *r.Digest == ref.Digest().String() {
+				return r.ImageID, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// listReferences returns the references to images in the image store, which has none until
+// the first image is pulled into it
+func listReferences(storeName string) ([]*models.ImageReference, error) {
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("image.listReferences failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	res, err := client.Storage.ListReferences(storage.NewListReferencesParams().WithStoreName(storeName))
+	if err != nil {
+		if _, ok := err.(*storage.ListReferencesNotFound); ok {
+			return nil, nil
+		}
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unable to list image references: %s", err),
+			http.StatusInternalServerError)
+	}
+
+	return res.Payload, nil
+}
+
+// listImages returns the layers in the image store, which has none until the first image is
+// pulled into it
+func listImages(storeName string) ([]*models.Image, error) {
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("image.listImages failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	res, err := client.Storage.ListImages(storage.NewListImagesParams().WithStoreName(storeName))
+	if err != nil {
+		if _, ok := err.(*storage.ListImagesNotFound); ok {
+			return nil, nil
+		}
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Unable to list images: %s", err),
+			http.StatusInternalServerError)
+	}
+
+	return res.Payload, nil
+}
+
+// addReference makes the reference refer to the image in the image store. References without
+// a tag or digest refer to the default tag, as with docker.
+func addReference(storeName string, ref reference.Named, id string) error {
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("image.addReference failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	ref = reference.WithDefaultTag(ref)
+
+	plRef := &models.ImageReference{
+		Repository: ref.Name(),
+		ImageID:    id,
+	}
+	switch ref := ref.(type) {
+	case reference.NamedTagged:
+		tag := ref.Tag()
+		plRef.Tag = &tag
+	case reference.Canonical:
+		digest := ref.Digest().String()
+		plRef.Digest = &digest
+	}
+
+	_, err := client.Storage.AddReference(storage.NewAddReferenceParams().WithStoreName(storeName).WithReference(plRef))
+	if err != nil {
+		switch err := err.(type) {
+		case *storage.AddReferenceNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", id))
+		case *storage.AddReferenceBadRequest:
+			return derr.NewBadRequestError(fmt.Errorf("Unable to tag %s: %s", id, err.Payload.Message))
+		}
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unable to tag %s: %s", id, err),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// imageConfig returns the configuration recorded for the image, or nil if there isn't one
+func imageConfig(storeName, id string) *container.Config {
+	imageConfigsMutex.Lock()
+	img, ok := imageConfigs[id]
+	imageConfigsMutex.Unlock()
+
+	if !ok {
+		client := PortLayerClient()
+		if client == nil {
+			log.Printf("image.imageConfig failed to create a portlayer client")
+			return nil
+		}
+
+		res, err := client.Storage.GetImage(storage.NewGetImageParams().WithStoreName(storeName).WithID(id))
+		if err != nil {
+			log.Printf("Unable to get image %s: %s", id, err)
+			return nil
+		}
+
+		img = layerImageConfig(res.Payload)
+	}
+
+	if img == nil {
+		return nil
+	}
+	return img.Config
+}
+
+// layerImageConfig returns the configuration saved in the metadata of the layer, or nil if
+// there isn't any. The configuration is cached once it's been decoded.
+func layerImageConfig(layer *models.Image) *image.V1Image {
+	imageConfigsMutex.Lock()
+	defer imageConfigsMutex.Unlock()
+
+	if img, ok := imageConfigs[layer.ID]; ok {
+		return img
+	}
+
+	meta, ok := layer.Metadata[imageMetadataKey]
+	if !ok {
+		return nil
+	}
+
+	img := &image.V1Image{}
+	if err := json.Unmarshal([]byte(meta), img); err != nil {
+		log.Printf("Unable to decode the configuration of image %s: %s", layer.ID, err)
+		return nil
+	}
+
+	imageConfigs[layer.ID] = img
+	return img
+}
+
+// Commit creates a new image from the changes made in a stopped container. The configuration
+// of the container, with any changes supplied merged in, becomes the configuration of the image.
 func (i *Image) Commit(name string, config *types.ContainerCommitConfig) (imageID string, err error) {
-	return "", fmt.Errorf("%s does not implement image.Commit", i.ProductName)
+	defer trace.End(trace.Begin("Commit"))
+
+	// the name is checked up front so an invalid one doesn't leave an untagged layer behind
+	var ref reference.Named
+	if config.Repo != "" {
+		ref, err = reference.WithName(config.Repo)
+		if err == nil && config.Tag != "" {
+			ref, err = reference.WithTag(ref, config.Tag)
+		}
+		if err != nil {
+			return "", derr.NewBadRequestError(err)
+		}
+	}
+
+	c := &Container{ProductName: i.ProductName}
+	info, err := c.ContainerInspect(name, false, "")
+	if err != nil {
+		return "", err
+	}
+	detail := info.(*types.ContainerJSON)
+
+	// the root disk can only be read consistently once the container has stopped
+	if detail.State.Running || detail.State.Paused {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("Cannot commit container %s while it is running, stop it first", name),
+			http.StatusConflict)
+	}
+
+	newConfig := config.Config
+	if newConfig == nil {
+		newConfig = &container.Config{}
+	}
+	if config.MergeConfigs {
+		mergeConfig(newConfig, detail.Config)
+	}
+
+	img := &image.V1Image{
+		Parent:          detail.Image,
+		Comment:         config.Comment,
+		Created:         time.Now().UTC(),
+		Container:       detail.ID,
+		ContainerConfig: *detail.Config,
+		Author:          config.Author,
+		Config:          newConfig,
+		Architecture:    "amd64",
+		OS:              "linux",
+	}
+
+	// the ID is derived from the content of the image, as docker's are
+	blob, err := json.Marshal(img)
+	if err != nil {
+		return "", derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+	img.ID = fmt.Sprintf("%x", sha256.Sum256(blob))
+
+	// the configuration is saved with the layer so it outlives this process
+	meta, err := json.Marshal(img)
+	if err != nil {
+		return "", derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("image.Commit got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	if err = commitContainer(host, detail.ID, img.ID, string(meta)); err != nil {
+		return "", err
+	}
+
+	imageConfigsMutex.Lock()
+	imageConfigs[img.ID] = img
+	imageConfigsMutex.Unlock()
+
+	if ref != nil {
+		if err = addReference(host, ref, img.ID); err != nil {
+			return "", err
+		}
+	}
+
+	return img.ID, nil
+}
+
+// commitContainer has the port layer write the changes made in the container to a new image,
+// with the configuration of the image saved in its metadata. Writing the layer can take longer
+// than the generated client allows for a request, so it's requested directly.
+func commitContainer(storeName, containerID, imageID, config string) error {
+	query := url.Values{}
+	query.Set("container_id", containerID)
+	query.Set("image_id", imageID)
+
+	u := url.URL{
+		Scheme:   "http",
+		Host:     PortLayerServer(),
+		Path:     fmt.Sprintf("/storage/%s/commitImage", storeName),
+		RawQuery: query.Encode(),
+	}
+
+	// the configuration can be too large for the query, so the metadata goes in a header
+	meta, err := json.Marshal(map[string]string{imageMetadataKey: config})
+	if err != nil {
+		return derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	req, err := http.NewRequest("POST", u.String(), nil)
+	if err != nil {
+		return derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Image-Metadata", string(meta))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot commit container %s: %s", containerID, err),
+			http.StatusInternalServerError)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusNotFound:
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", containerID))
+	case http.StatusConflict:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot commit container %s: %s", containerID, portLayerErrorMessage(res)),
+			http.StatusConflict)
+	default:
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Cannot commit container %s: %s", containerID, portLayerErrorMessage(res)),
+			http.StatusInternalServerError)
+	}
+}
+
+// mergeConfig fills in the parts of the user supplied configuration that weren't set from the
+// configuration of the image, as docker does when creating and committing containers
+func mergeConfig(userConf, imageConf *container.Config) {
+	if userConf.User == "" {
+		userConf.User = imageConf.User
+	}
+
+	if len(userConf.ExposedPorts) == 0 {
+		userConf.ExposedPorts = imageConf.ExposedPorts
+	} else {
+		for port := range imageConf.ExposedPorts {
+			if _, exists := userConf.ExposedPorts[port]; !exists {
+				userConf.ExposedPorts[port] = struct{}{}
+			}
+		}
+	}
+
+	// variables set by the user take precedence over those of the same name from the image
+	for _, imageEnv := range imageConf.Env {
+		key := strings.SplitN(imageEnv, "=", 2)[0]
+
+		found := false
+		for _, userEnv := range userConf.Env {
+			if strings.SplitN(userEnv, "=", 2)[0] == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			userConf.Env = append(userConf.Env, imageEnv)
+		}
+	}
+
+	if len(imageConf.Labels) > 0 {
+		labels := make(map[string]string, len(imageConf.Labels)+len(userConf.Labels))
+		for k, v := range imageConf.Labels {
+			labels[k] = v
+		}
+		for k, v := range userConf.Labels {
+			labels[k] = v
+		}
+		userConf.Labels = labels
+	}
+
+	// the command from the image only makes sense with its entrypoint
+	if len(userConf.Entrypoint) == 0 {
+		if len(userConf.Cmd) == 0 {
+			userConf.Cmd = imageConf.Cmd
+		}
+		if userConf.Entrypoint == nil {
+			userConf.Entrypoint = imageConf.Entrypoint
+		}
+	}
+
+	if userConf.WorkingDir == "" {
+		userConf.WorkingDir = imageConf.WorkingDir
+	}
+
+	if len(userConf.Volumes) == 0 {
+		userConf.Volumes = imageConf.Volumes
+	} else {
+		for k, v := range imageConf.Volumes {
+			userConf.Volumes[k] = v
+		}
+	}
+
+	if userConf.StopSignal == "" {
+		userConf.StopSignal = imageConf.StopSignal
+	}
 }
 
 func (i *Image) Exists(containerName string) bool {
 	return false
 }
 
+// ImageDelete untags the image, then deletes it once nothing refers to it. An image named by a
+// reference only loses that reference while others remain, whereas one named by ID loses them
+// all, which has to be forced if it's in more than one repository. Parents left without
+// references are deleted along with the image when pruning.
 func (i *Image) ImageDelete(imageRef string, force, prune bool) ([]types.ImageDelete, error) {
-	return []types.ImageDelete{}, fmt.Errorf("%s does not implement image.Delete", i.ProductName)
+	defer trace.End(trace.Begin(imageRef))
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("image.ImageDelete got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	refs, err := listReferences(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var named reference.Named
+	var id string
+	if ref, err := reference.ParseNamed(imageRef); err == nil {
+		named = reference.WithDefaultTag(ref)
+		id, _ = resolveReference(refs, named)
+	}
+	if id == "" {
+		named = nil
+		if id, err = lookupImage(host, imageRef); err != nil {
+			return nil, err
+		}
+	}
+
+	// the other references to the image, and the repositories they're in
+	repositories := make(map[string]bool)
+	var others int
+	for _, r := range refs {
+		if r.ImageID != id {
+			continue
+		}
+		if named != nil {
+			if _, ok := resolveReference([]*models.ImageReference{r}, named); ok {
+				continue
+			}
+		}
+
+		others++
+		repositories[r.Repository] = true
+	}
+
+	if named != nil && others > 0 {
+		if err = removeReference(host, named); err != nil {
+			return nil, err
+		}
+		return []types.ImageDelete{{Untagged: named.String()}}, nil
+	}
+
+	if named == nil && len(repositories) > 1 && !force {
+		return nil, derr.NewRequestConflictError(fmt.Errorf("conflict: unable to delete %s (must be forced) - image is referenced in one or more repositories",
+			stringid.TruncateID(id)))
+	}
+
+	// the parents have to be known before the layer is gone
+	layers, err := listImages(host)
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]string)
+	for _, layer := range layers {
+		if layer.Parent != nil {
+			parents[layer.ID] = path.Base(*layer.Parent)
+		}
+	}
+
+	results, deleted, err := deleteImage(host, id, force)
+	if err != nil {
+		return nil, err
+	}
+
+	// parents go too while nothing else refers to or is derived from them
+	for parent := parents[id]; prune && deleted && parent != "" && parent != scratchImageID; parent = parents[parent] {
+		if referenced(refs, parent) {
+			break
+		}
+
+		var parentResults []types.ImageDelete
+		parentResults, deleted, err = deleteImage(host, parent, false)
+		if err != nil {
+			log.Printf("Not pruning %s: %s", parent, err)
+			break
+		}
+		results = append(results, parentResults...)
+	}
+
+	return results, nil
+}
+
+// referenced reports whether any of the references refer to the image
+func referenced(refs []*models.ImageReference, id string) bool {
+	for _, r := range refs {
+		if r.ImageID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteImage has the port layer untag the image then delete it, returning what was untagged
+// and deleted. Forcing the deletion of an image that other images or containers are derived
+// from only untags it.
+func deleteImage(storeName, id string, force bool) ([]types.ImageDelete, bool, error) {
+	client := PortLayerClient()
+	if client == nil {
+		return nil, false, derr.NewErrorWithStatusCode(fmt.Errorf("image.deleteImage failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	res, err := client.Storage.DeleteImage(storage.NewDeleteImageParams().WithStoreName(storeName).WithID(id).WithForce(&force))
+	if err != nil {
+		switch err := err.(type) {
+		case *storage.DeleteImageNotFound:
+			return nil, false, derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", id))
+		case *storage.DeleteImageConflict:
+			return nil, false, derr.NewRequestConflictError(fmt.Errorf("conflict: unable to delete %s (must be forced) - %s",
+				stringid.TruncateID(id), err.Payload.Message))
+		}
+		return nil, false, derr.NewErrorWithStatusCode(fmt.Errorf("Unable to delete %s: %s", id, err),
+			http.StatusInternalServerError)
+	}
+
+	var results []types.ImageDelete
+	for _, ref := range res.Payload.Untagged {
+		results = append(results, types.ImageDelete{Untagged: referenceString(ref)})
+	}
+
+	if res.Payload.Deleted {
+		results = append(results, types.ImageDelete{Deleted: id})
+
+		imageConfigsMutex.Lock()
+		delete(imageConfigs, id)
+		imageConfigsMutex.Unlock()
+	}
+
+	return results, res.Payload.Deleted, nil
+}
+
+// removeReference removes the reference from the image store, leaving the image it referred to
+func removeReference(storeName string, ref reference.Named) error {
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("image.removeReference failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	params := storage.NewRemoveReferenceParams().WithStoreName(storeName).WithRepository(ref.Name())
+	switch ref := ref.(type) {
+	case reference.NamedTagged:
+		tag := ref.Tag()
+		params.WithTag(&tag)
+	case reference.Canonical:
+		digest := ref.Digest().String()
+		params.WithDigest(&digest)
+	}
+
+	if _, err := client.Storage.RemoveReference(params); err != nil {
+		if _, ok := err.(*storage.RemoveReferenceNotFound); ok {
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such image: %s", ref))
+		}
+		return derr.NewErrorWithStatusCode(fmt.Errorf("Unable to untag %s: %s", ref, err),
+			http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// referenceString formats the reference as docker does
+func referenceString(ref *models.ImageReference) string {
+	if ref.Tag != nil {
+		return ref.Repository + ":" + *ref.Tag
+	}
+	return ref.Repository + "@" + swag.StringValue(ref.Digest)
 }
 
 func (i *Image) ImageHistory(imageName string) ([]*types.ImageHistory, error) {
 	return nil, fmt.Errorf("%s does not implement image.History", i.ProductName)
 }
 
+// Images lists the images in the image store, by the references to them. Intermediate layers
+// are only listed when all are requested.
 func (i *Image) Images(filterArgs string, filter string, all bool) ([]*types.Image, error) {
-	return nil, fmt.Errorf("%s does not implement image.Images", i.ProductName)
+	defer trace.End(trace.Begin("Images"))
+
+	imageFilters, err := filters.FromParam(filterArgs)
+	if err != nil {
+		return nil, derr.NewBadRequestError(err)
+	}
+	if err = imageFilters.Validate(acceptedImageFilterTags); err != nil {
+		return nil, derr.NewBadRequestError(err)
+	}
+
+	var danglingOnly bool
+	for _, value := range imageFilters.Get("dangling") {
+		switch v := strings.ToLower(value); v {
+		case "true":
+			danglingOnly = true
+		case "false":
+		default:
+			return nil, derr.NewBadRequestError(fmt.Errorf("Invalid filter 'dangling=%s'", v))
+		}
+	}
+
+	// a tagged filter has to match the whole reference, otherwise it's a pattern for the name
+	var filterTagged reference.NamedTagged
+	if filter != "" {
+		if ref, err := reference.ParseNamed(filter); err == nil {
+			filterTagged, _ = ref.(reference.NamedTagged)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("image.Images got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	refs, err := listReferences(host)
+	if err != nil {
+		return nil, err
+	}
+
+	layers, err := listImages(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// the references to each image, with images that are only referred to included in case
+	// their layers aren't listed
+	parents := make(map[string]string)
+	children := make(map[string]bool)
+	configs := make(map[string]*image.V1Image)
+	for _, layer := range layers {
+		if layer.ID == scratchImageID {
+			continue
+		}
+
+		if img := layerImageConfig(layer); img != nil {
+			configs[layer.ID] = img
+		}
+
+		parents[layer.ID] = ""
+		if layer.Parent != nil {
+			if parent := path.Base(*layer.Parent); parent != scratchImageID {
+				parents[layer.ID] = parent
+				children[parent] = true
+			}
+		}
+	}
+
+	tags := make(map[string][]string)
+	digests := make(map[string][]string)
+	for _, ref := range refs {
+		if _, ok := parents[ref.ImageID]; !ok {
+			parents[ref.ImageID] = ""
+		}
+
+		if filterTagged != nil {
+			if ref.Tag == nil || ref.Repository != filterTagged.Name() || *ref.Tag != filterTagged.Tag() {
+				continue
+			}
+		} else if filter != "" {
+			if matched, err := path.Match(filter, ref.Repository); !matched || err != nil {
+				continue
+			}
+		}
+
+		if ref.Tag != nil {
+			tags[ref.ImageID] = append(tags[ref.ImageID], ref.Repository+":"+*ref.Tag)
+		}
+		if ref.Digest != nil {
+			digests[ref.ImageID] = append(digests[ref.ImageID], ref.Repository+"@"+*ref.Digest)
+		}
+	}
+
+	images := []*types.Image{}
+	for id, parent := range parents {
+		tagged := len(tags[id]) > 0 || len(digests[id]) > 0
+
+		switch {
+		case filter != "" && !tagged:
+			// only images with a reference can match the filter
+			continue
+		case danglingOnly && (tagged || children[id]):
+			continue
+		case !all && !tagged && children[id]:
+			// an intermediate layer
+			continue
+		}
+
+		image := &types.Image{
+			ID:          id,
+			ParentID:    parent,
+			RepoTags:    tags[id],
+			RepoDigests: digests[id],
+		}
+		if len(image.RepoTags) == 0 {
+			image.RepoTags = []string{"<none>:<none>"}
+		}
+		if len(image.RepoDigests) == 0 {
+			image.RepoDigests = []string{"<none>@<none>"}
+		}
+
+		if img, ok := configs[id]; ok {
+			image.Created = img.Created.Unix()
+			image.Size = img.Size
+			if img.Config != nil {
+				image.Labels = img.Config.Labels
+			}
+		}
+
+		// the virtual size includes the layers the image is built on
+		for layer := id; layer != ""; layer = parents[layer] {
+			if img, ok := configs[layer]; ok {
+				image.VirtualSize += img.Size
+			}
+		}
+
+		images = append(images, image)
+	}
+
+	sort.Sort(sort.Reverse(byCreated(images)))
+
+	return images, nil
+}
+
+// byCreated sorts images by when they were created, then by ID for images created together
+type byCreated []*types.Image
+
+func (r byCreated) Len() int      { return len(r) }
+func (r byCreated) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byCreated) Less(i, j int) bool {
+	if r[i].Created != r[j].Created {
+		return r[i].Created < r[j].Created
+	}
+	return r[i].ID < r[j].ID
 }
 
 func (i *Image) LookupImage(name string) (*types.ImageInspect, error) {
 	return nil, fmt.Errorf("%s does not implement image.LookupImage", i.ProductName)
 }
 
+// TagImage makes the new reference refer to the named image, in place of any image it referred
+// to before
 func (i *Image) TagImage(newTag reference.Named, imageName string) error {
-	return fmt.Errorf("%s does not implement image.Tag", i.ProductName)
+	defer trace.End(trace.Begin(newTag.String()))
+
+	host, err := os.Hostname()
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("image.TagImage got unexpected error getting hostname"),
+			http.StatusInternalServerError)
+	}
+
+	id, err := lookupImage(host, imageName)
+	if err != nil {
+		return err
+	}
+
+	return addReference(host, newTag, id)
 }
 
 func (i *Image) LoadImage(inTar io.ReadCloser, outStream io.Writer, quiet bool) error {
@@ -72,6 +853,8 @@ func (i *Image) ExportImage(names []string, outStream io.Writer) error {
 	return fmt.Errorf("%s does not implement image.ExportImage", i.ProductName)
 }
 
+// PullImage has imagec pull the image into the image store, which also makes the reference
+// refer to it
 func (i *Image) PullImage(ref reference.Named, metaHeaders map[string][]string, authConfig *types.AuthConfig, outStream io.Writer) error {
 	log.Printf("PullImage: ref = %+v, metaheaders = %+v\n", ref, metaHeaders)
 
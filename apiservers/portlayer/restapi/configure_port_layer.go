@@ -31,10 +31,11 @@ import (
 // This file is safe to edit. Once it exists it will not be overwritten
 
 type portlayerhandlers struct {
-	storageHandlers handlers.StorageHandlersImpl
-	miscHandlers    handlers.MiscHandlersImpl
-	scopesHandlers  handlers.ScopesHandlersImpl
-	execHandlers    handlers.ExecHandlersImpl
+	storageHandlers     handlers.StorageHandlersImpl
+	miscHandlers        handlers.MiscHandlersImpl
+	scopesHandlers      handlers.ScopesHandlersImpl
+	execHandlers        handlers.ExecHandlersImpl
+	interactionHandlers handlers.InteractionHandlersImpl
 }
 
 func configureFlags(api *operations.PortLayerAPI) {
@@ -63,12 +64,15 @@ func configureAPI(api *operations.PortLayerAPI) http.Handler {
 
 	api.TxtProducer = httpkit.TextProducer()
 
+	api.BinProducer = httpkit.ByteStreamProducer()
+
 	allhandlers := portlayerhandlers{}
 
 	allhandlers.storageHandlers.Configure(api)
 	allhandlers.miscHandlers.Configure(api)
 	allhandlers.scopesHandlers.Configure(api)
 	allhandlers.execHandlers.Configure(api)
+	allhandlers.interactionHandlers.Configure(api)
 
 	api.ServerShutdown = func() {}
 	return setupGlobalMiddleware(api.Serve(setupMiddlewares))
@@ -16,12 +16,26 @@ package handlers
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/namesgenerator"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/go-swagger/go-swagger/httpkit"
 	middleware "github.com/go-swagger/go-swagger/httpkit/middleware"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/context"
 
 	log "github.com/Sirupsen/logrus"
@@ -30,6 +44,7 @@ import (
 	"github.com/vmware/vic/apiservers/portlayer/restapi/operations"
 	"github.com/vmware/vic/apiservers/portlayer/restapi/operations/exec"
 	"github.com/vmware/vic/apiservers/portlayer/restapi/options"
+	"github.com/vmware/vic/cmd/tether/msgs"
 	"github.com/vmware/vic/metadata"
 	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/guest"
@@ -37,17 +52,39 @@ import (
 	"github.com/vmware/vic/pkg/vsphere/spec"
 	"github.com/vmware/vic/pkg/vsphere/tasks"
 	"github.com/vmware/vic/pkg/vsphere/vm"
+	"github.com/vmware/vic/portlayer/attach"
+
+	epl "github.com/vmware/vic/portlayer/exec"
 )
 
 // ExecHandlersImpl is the receiver for all of the exec handler methods
 type ExecHandlersImpl struct{}
 
 var (
-	execSession = &session.Session{}
+	execSession   = &session.Session{}
+	execConnector *attach.Connector
+
+	// the size of container VMs that don't ask for one, from the VCH configuration
+	containerVMSize epl.Resources
 )
 
 const (
 	serialOverLANPort = 2377
+
+	// how long to wait for the tether in a running container to connect
+	tetherConnectTimeout = 5 * time.Second
+	// default grace period for a container to exit after SIGTERM
+	defaultStopTimeout = 10
+	// default time to wait for a container to exit before asking the caller to retry
+	defaultWaitTimeout = 20
+	// how long the exit status of a container removed on exit is kept for clients waiting on it
+	removedExitRetention = 5 * time.Minute
+)
+
+var (
+	// removedExits holds the exit status of containers removed once they exited, keyed by ID
+	removedExits      = make(map[string]*models.ContainerExit)
+	removedExitsMutex sync.Mutex
 )
 
 // Configure assigns functions to all the exec api handlers
@@ -56,6 +93,21 @@ func (handler *ExecHandlersImpl) Configure(api *operations.PortLayerAPI) {
 
 	api.ExecContainerCreateHandler = exec.ContainerCreateHandlerFunc(handler.ContainerCreateHandler)
 	api.ExecContainerStartHandler = exec.ContainerStartHandlerFunc(handler.ContainerStartHandler)
+	api.ExecContainerSignalHandler = exec.ContainerSignalHandlerFunc(handler.ContainerSignalHandler)
+	api.ExecContainerStopHandler = exec.ContainerStopHandlerFunc(handler.ContainerStopHandler)
+	api.ExecContainerPauseHandler = exec.ContainerPauseHandlerFunc(handler.ContainerPauseHandler)
+	api.ExecContainerUnpauseHandler = exec.ContainerUnpauseHandlerFunc(handler.ContainerUnpauseHandler)
+	api.ExecContainerRenameHandler = exec.ContainerRenameHandlerFunc(handler.ContainerRenameHandler)
+	api.ExecContainerUpdateHandler = exec.ContainerUpdateHandlerFunc(handler.ContainerUpdateHandler)
+	api.ExecContainerSetRestartPolicyHandler = exec.ContainerSetRestartPolicyHandlerFunc(handler.ContainerSetRestartPolicyHandler)
+	api.ExecContainerRemoveHandler = exec.ContainerRemoveHandlerFunc(handler.ContainerRemoveHandler)
+	api.ExecContainerListHandler = exec.ContainerListHandlerFunc(handler.ContainerListHandler)
+	api.ExecContainerInspectHandler = exec.ContainerInspectHandlerFunc(handler.ContainerInspectHandler)
+	api.ExecContainerWaitHandler = exec.ContainerWaitHandlerFunc(handler.ContainerWaitHandler)
+	api.ExecContainerLogsHandler = exec.ContainerLogsHandlerFunc(handler.ContainerLogsHandler)
+	api.ExecContainerStatsHandler = exec.ContainerStatsHandlerFunc(handler.ContainerStatsHandler)
+	api.ExecContainerExecCreateHandler = exec.ContainerExecCreateHandlerFunc(handler.ContainerExecCreateHandler)
+	api.ExecContainerExecStartHandler = exec.ContainerExecStartHandlerFunc(handler.ContainerExecStartHandler)
 
 	ctx := context.Background()
 
@@ -73,6 +125,26 @@ func (handler *ExecHandlersImpl) Configure(api *operations.PortLayerAPI) {
 	if err != nil {
 		log.Fatalf("ERROR: %s", err)
 	}
+
+	fallback := epl.Resources{
+		CPUCount: options.PortLayerOptions.ContainerCPUs,
+		MemoryMB: options.PortLayerOptions.ContainerMemoryMB,
+	}
+	containerVMSize, err = epl.LoadContainerVMSize(ctx, execSession, options.PortLayerOptions.VCHName, fallback)
+	if err != nil {
+		log.Warnf("Failed to read the container VM size from the VCH configuration, using the options: %s", err)
+	}
+	log.Infof("Container VMs default to %d vCPUs and %dMB of memory", containerVMSize.CPUCount, containerVMSize.MemoryMB)
+
+	// listen for the tethers in the container VMs
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", serialOverLANPort))
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	execConnector = attach.NewConnector(listener, tetherHostKey, recordExit)
+	execConnector.Start()
+
+	go reconcileContainers()
 }
 
 // ContainerCreateHandler creates a new container
@@ -113,24 +185,71 @@ func (handler *ExecHandlersImpl) ContainerCreateHandler(params exec.ContainerCre
 			ID:   id,
 			Name: name,
 		},
-		Sessions: map[string]metadata.SessionConfig{
-			id: metadata.SessionConfig{
+		Sessions: map[string]*metadata.SessionConfig{
+			id: &metadata.SessionConfig{
 				Common: metadata.Common{
 					ID: id,
 				},
-				Tty: false,
-				Cmd: cmd,
+				Tty:       params.CreateConfig.Tty != nil && *params.CreateConfig.Tty,
+				OpenStdin: params.CreateConfig.OpenStdin != nil && *params.CreateConfig.OpenStdin,
+				StdinOnce: params.CreateConfig.StdinOnce != nil && *params.CreateConfig.StdinOnce,
+				Cmd:       cmd,
 			},
 		},
+		ImageID: *params.CreateConfig.Image,
+		Labels:  params.CreateConfig.Labels,
+		Created: time.Now().Unix(),
+	}
+	if params.CreateConfig.ImageName != nil {
+		m.ImageName = *params.CreateConfig.ImageName
 	}
 	log.Infof("Metadata: %#v", m)
 
+	connector := fmt.Sprintf("tcp://%s:%d", "127.0.0.1", serialOverLANPort)
+	vmconfig := &metadata.ContainerVM{
+		Common: metadata.Common{
+			ID:   id,
+			Name: name,
+		},
+	}
+	if interaction, err := url.Parse(connector); err == nil {
+		vmconfig.Interaction = *interaction
+	}
+	if params.CreateConfig.RestartPolicy != nil {
+		vmconfig.RestartPolicy = restartPolicy(params.CreateConfig.RestartPolicy)
+		if err := epl.ValidateRestartPolicy(vmconfig.RestartPolicy); err != nil {
+			return exec.NewContainerCreateBadRequest().WithPayload(&models.Error{Message: err.Error()})
+		}
+	}
+	vmconfig.AutoRemove = params.CreateConfig.AutoRemove != nil && *params.CreateConfig.AutoRemove
+	if vmconfig.AutoRemove && epl.Restarts(vmconfig.RestartPolicy) {
+		return exec.NewContainerCreateBadRequest().WithPayload(&models.Error{Message: epl.ErrAutoRemoveConflict.Error()})
+	}
+
+	res := resources(params.CreateConfig.Resources)
+	if res.CPUCount < 0 || res.MemoryMB < 0 || res.CPUShares < 0 || res.MemoryReservationMB < 0 {
+		return exec.NewContainerCreateBadRequest().WithPayload(&models.Error{Message: "Container resources can't be negative"})
+	}
+
+	// the VCH defaults apply to anything the client doesn't ask for
+	if res.CPUCount == 0 {
+		res.CPUCount = containerVMSize.CPUCount
+	}
+	if res.MemoryMB == 0 {
+		res.MemoryMB = containerVMSize.MemoryMB
+	}
+	// the memory of a VM is a multiple of 4MB
+	res.MemoryMB = (res.MemoryMB + 3) / 4 * 4
+	if res.MemoryReservationMB > res.MemoryMB {
+		msg := fmt.Sprintf("Memory reservation of %dMB exceeds the memory limit of %dMB", res.MemoryReservationMB, res.MemoryMB)
+		return exec.NewContainerCreateBadRequest().WithPayload(&models.Error{Message: msg})
+	}
+
 	specconfig := &spec.VirtualMachineConfigSpecConfig{
-		// FIXME: hardcoded values
-		NumCPUs:  2,
-		MemoryMB: 2048,
+		NumCPUs:  res.CPUCount,
+		MemoryMB: res.MemoryMB,
 		// FIXME: hardcoded value
-		ConnectorURI: fmt.Sprintf("tcp://%s:%d", "127.0.0.1", serialOverLANPort),
+		ConnectorURI: connector,
 
 		// They will be redundant with the Metadata
 		ID:   id,
@@ -145,16 +264,32 @@ func (handler *ExecHandlersImpl) ContainerCreateHandler(params exec.ContainerCre
 
 		ImageStoreName: params.CreateConfig.ImageStore.Name,
 
-		Metadata: m,
+		Metadata:    m,
+		ContainerVM: vmconfig,
 	}
 	log.Debugf("Config: %#v", specconfig)
 
+	// the tether proves which container it's in with this key when it connects. It's added once
+	// the config has been logged.
+	key, err := epl.NewHostKey()
+	if err != nil {
+		return exec.NewContainerCreateNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("Error generating host key: %s", err)})
+	}
+	specconfig.Metadata.Key = key
+
 	// Create a linux guest
 	linux, err := guest.NewLinuxGuest(ctx, session, specconfig)
 	if err != nil {
 		return exec.NewContainerCreateNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("Error constructing container vm specification: %s", err)})
 	}
 
+	if res.CPUShares > 0 {
+		linux.Spec().CpuAllocation = epl.CPUAllocation(res.CPUShares, res.CPUCount)
+	}
+	if res.MemoryReservationMB > 0 {
+		linux.Spec().MemoryAllocation = &types.ResourceAllocationInfo{Reservation: res.MemoryReservationMB}
+	}
+
 	// Find the Virtual Machine folder that we use
 	folders, err := session.Datacenter.Folders(ctx)
 	if err != nil {
@@ -163,11 +298,15 @@ func (handler *ExecHandlersImpl) ContainerCreateHandler(params exec.ContainerCre
 	parent := folders.VmFolder
 
 	// FIXME: Replace this simple logic with DRS placement
-	// Pick a random host
+	// Pick a random host that can run the container VM
 	hosts, err := session.Datastore.AttachedClusterHosts(ctx, session.Cluster)
 	if err != nil {
 		return exec.NewContainerCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
 	}
+	hosts, err = epl.CheckHostLimits(ctx, hosts, res.CPUCount, res.MemoryMB)
+	if err != nil {
+		return exec.NewContainerCreateBadRequest().WithPayload(&models.Error{Message: err.Error()})
+	}
 	host := hosts[rand.Intn(len(hosts))]
 
 	// Create the vm
@@ -193,21 +332,1178 @@ func (handler *ExecHandlersImpl) ContainerStartHandler(params exec.ContainerStar
 	session := execSession
 	ctx := context.Background()
 
-	foundvm, err := session.Finder.VirtualMachine(ctx, params.ID)
+	c, err := epl.ContainerByID(ctx, session, params.ID)
 	if err != nil {
-		return exec.NewContainerCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerStartInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerStartNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	// a client starting the container lifts a stop that overrode the restart policy
+	if c.VMConfig != nil && (c.VMConfig.StoppedByUser || c.VMConfig.RestartCount > 0) {
+		if err = epl.SetStoppedByUser(ctx, session, params.ID, false); err != nil {
+			return exec.NewContainerStartNotFound().WithPayload(&models.Error{Message: err.Error()})
+		}
+	}
+
+	if err = startContainer(ctx, c, joinPending(params.ID)); err != nil {
+		return exec.NewContainerStartNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerStartOK()
+}
+
+// startContainer readies the container VM and powers it on. The primary session waits for a
+// client to attach if attach is set.
+func startContainer(ctx context.Context, c *epl.Container, attach bool) error {
+	session := execSession
+	id := c.ExecConfig.ID
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
 	}
 
 	// Wrap the result with our version of VirtualMachine
 	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
 
+	// the processes of a container frozen before it was powered off start out running
+	if c.Frozen {
+		if err = epl.SetFrozen(ctx, session, id, false); err != nil {
+			return err
+		}
+	}
+
+	// resource changes that couldn't be made while the container was running
+	if c.PendingResources != nil {
+		if err = epl.ApplyPendingResources(ctx, session, id, c.PendingResources); err != nil {
+			return err
+		}
+	}
+
+	// hold the primary session until any waiting client has attached, and drop exec sessions
+	// from a previous run
+	if primary, ok := c.ExecConfig.Sessions[id]; !ok || primary.Attach != attach || len(c.ExecConfig.Sessions) > 1 {
+		if err = epl.PrepareStart(ctx, session, id, attach); err != nil {
+			return err
+		}
+	}
+
 	// Power on
 	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
 		return vm.PowerOn(ctx)
 	})
+	return err
+}
+
+// ContainerSignalHandler sends a signal to the primary process of the container
+func (handler *ExecHandlersImpl) ContainerSignalHandler(params exec.ContainerSignalParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerSignal(%s, %d)", params.ID, params.Signal)))
+
+	session := execSession
+	ctx := context.Background()
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, params.ID)
 	if err != nil {
-		return exec.NewContainerCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerSignalNotFound().WithPayload(&models.Error{Message: err.Error()})
 	}
 
-	return exec.NewContainerStartOK()
+	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
+
+	state, err := vm.PowerState(ctx)
+	if err != nil {
+		return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+	if state != types.VirtualMachinePowerStatePoweredOn {
+		return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not running", params.ID)})
+	}
+
+	// as with docker, a container that's been sent a signal isn't restarted by its policy
+	if err = epl.SetStoppedByUser(ctx, session, params.ID, true); err != nil {
+		return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	// the primary session shares the container ID
+	conn, err := execConnector.Get(ctx, params.ID, tetherConnectTimeout)
+	if err == nil {
+		err = conn.Signal(params.ID, params.Signal)
+	}
+
+	if err != nil {
+		// there's no need for the tether to be reachable if all we want is the container gone
+		if params.Signal != int64(syscall.SIGKILL) {
+			return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		log.Warnf("Unable to deliver SIGKILL to %s, powering off: %s", params.ID, err)
+		if err = powerOff(ctx, vm); err != nil {
+			return exec.NewContainerSignalInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+	}
+
+	return exec.NewContainerSignalOK()
+}
+
+// ContainerStopHandler stops the container, sending SIGTERM to the primary process and then
+// powering off the container VM if it hasn't exited within the timeout
+func (handler *ExecHandlersImpl) ContainerStopHandler(params exec.ContainerStopParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerStop"))
+
+	session := execSession
+	ctx := context.Background()
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerStopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerStopNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
+
+	// mark the container before it exits so that its restart policy doesn't start it again. This
+	// also covers a container that's stopped while waiting to be restarted.
+	if err = epl.SetStoppedByUser(ctx, session, params.ID, true); err != nil {
+		return exec.NewContainerStopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	state, err := vm.PowerState(ctx)
+	if err != nil {
+		return exec.NewContainerStopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+	if state == types.VirtualMachinePowerStatePoweredOff {
+		return exec.NewContainerStopOK()
+	}
+
+	timeout := int64(defaultStopTimeout)
+	if params.Timeout != nil {
+		timeout = *params.Timeout
+	}
+
+	conn, err := execConnector.Get(ctx, params.ID, tetherConnectTimeout)
+	if err == nil {
+		err = conn.Signal(params.ID, int64(syscall.SIGTERM))
+	}
+
+	if err == nil {
+		// the tether powers off the VM once the primary process has exited
+		wctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		err = vm.WaitForPowerState(wctx, types.VirtualMachinePowerStatePoweredOff)
+		cancel()
+
+		if err == nil {
+			return exec.NewContainerStopOK()
+		}
+
+		log.Infof("Container %s did not exit within %ds, powering off", params.ID, timeout)
+	} else {
+		log.Warnf("Unable to deliver SIGTERM to %s, powering off: %s", params.ID, err)
+	}
+
+	if err = powerOff(ctx, vm); err != nil {
+		return exec.NewContainerStopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerStopOK()
+}
+
+// ContainerPauseHandler pauses the container, either suspending the container VM or having the
+// tether freeze the processes in it, according to the pause mode of the VCH
+func (handler *ExecHandlersImpl) ContainerPauseHandler(params exec.ContainerPauseParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	session := execSession
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, session, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerPauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerPauseNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	switch c.State {
+	case epl.StateRunning:
+	case epl.StateSuspended:
+		return exec.NewContainerPauseConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is already paused", params.ID)})
+	default:
+		return exec.NewContainerPauseConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not running", params.ID)})
+	}
+
+	if options.PortLayerOptions.PauseMode == "freeze" {
+		conn, err := execConnector.Get(ctx, params.ID, tetherConnectTimeout)
+		if err == nil {
+			err = conn.Freeze()
+		}
+		if err != nil {
+			return exec.NewContainerPauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		if err = epl.SetFrozen(ctx, session, params.ID, true); err != nil {
+			// a container that can't be shown as paused is better left running
+			if terr := conn.Thaw(); terr != nil {
+				log.Errorf("Unable to thaw %s after failing to record it as paused: %s", params.ID, terr)
+			}
+			return exec.NewContainerPauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		return exec.NewContainerPauseOK()
+	}
+
+	vm := vm.NewVirtualMachine(ctx, session, c.Ref)
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Suspend(ctx)
+	})
+	if err != nil {
+		return exec.NewContainerPauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerPauseOK()
+}
+
+// ContainerUnpauseHandler resumes a paused container in whichever way it was paused, regardless
+// of the current pause mode
+func (handler *ExecHandlersImpl) ContainerUnpauseHandler(params exec.ContainerUnpauseParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	session := execSession
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, session, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerUnpauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerUnpauseNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if c.State != epl.StateSuspended {
+		return exec.NewContainerUnpauseConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not paused", params.ID)})
+	}
+
+	if c.Frozen {
+		conn, err := execConnector.Get(ctx, params.ID, tetherConnectTimeout)
+		if err == nil {
+			err = conn.Thaw()
+		}
+		if err == nil {
+			err = epl.SetFrozen(ctx, session, params.ID, false)
+		}
+		if err != nil {
+			return exec.NewContainerUnpauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		return exec.NewContainerUnpauseOK()
+	}
+
+	// powering on a suspended VM resumes it
+	vm := vm.NewVirtualMachine(ctx, session, c.Ref)
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.PowerOn(ctx)
+	})
+	if err != nil {
+		return exec.NewContainerUnpauseInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerUnpauseOK()
+}
+
+// ContainerRenameHandler renames the container, rejecting names in use by other containers
+func (handler *ExecHandlersImpl) ContainerRenameHandler(params exec.ContainerRenameParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerRename(%s, %s)", params.ID, params.Name)))
+
+	session := execSession
+	ctx := context.Background()
+
+	if _, err := epl.ContainerByID(ctx, session, params.ID); err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerRenameInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerRenameNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if err := epl.Rename(ctx, session, params.ID, params.Name); err != nil {
+		if err == epl.ErrNameInUse {
+			return exec.NewContainerRenameConflict().WithPayload(&models.Error{Message: fmt.Sprintf("name %s is already in use", params.Name)})
+		}
+		return exec.NewContainerRenameInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerRenameOK()
+}
+
+// ContainerUpdateHandler changes the CPU and memory of the container, reporting the settings that
+// have to wait for it to be restarted
+func (handler *ExecHandlersImpl) ContainerUpdateHandler(params exec.ContainerUpdateParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	session := execSession
+	ctx := context.Background()
+
+	if _, err := epl.ContainerByID(ctx, session, params.ID); err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerUpdateInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerUpdateNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	restart, err := epl.UpdateResources(ctx, session, params.ID, resources(params.Resources))
+	if err != nil {
+		if _, ok := err.(*epl.ResourcesError); ok {
+			return exec.NewContainerUpdateBadRequest().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerUpdateInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if restart == nil {
+		restart = []string{}
+	}
+	return exec.NewContainerUpdateOK().WithPayload(restart)
+}
+
+// ContainerSetRestartPolicyHandler changes the restart policy of the container, which applies
+// from the next exit of its primary process
+func (handler *ExecHandlersImpl) ContainerSetRestartPolicyHandler(params exec.ContainerSetRestartPolicyParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	session := execSession
+	ctx := context.Background()
+
+	if _, err := epl.ContainerByID(ctx, session, params.ID); err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerSetRestartPolicyInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerSetRestartPolicyNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	policy := restartPolicy(params.Policy)
+	if err := epl.ValidateRestartPolicy(policy); err != nil {
+		return exec.NewContainerSetRestartPolicyBadRequest().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if err := epl.SetRestartPolicy(ctx, session, params.ID, policy); err != nil {
+		if err == epl.ErrAutoRemoveConflict {
+			return exec.NewContainerSetRestartPolicyBadRequest().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerSetRestartPolicyInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerSetRestartPolicyOK()
+}
+
+// ContainerRemoveHandler destroys the container VM and removes its datastore folder, which
+// holds the files backing the serial ports that are not removed along with the VM
+func (handler *ExecHandlersImpl) ContainerRemoveHandler(params exec.ContainerRemoveParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerRemove"))
+
+	session := execSession
+	ctx := context.Background()
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerRemoveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerRemoveNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
+
+	state, err := vm.PowerState(ctx)
+	if err != nil {
+		return exec.NewContainerRemoveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if state != types.VirtualMachinePowerStatePoweredOff {
+		if params.Force == nil || !*params.Force {
+			return exec.NewContainerRemoveConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is running", params.ID)})
+		}
+
+		if err = powerOff(ctx, vm); err != nil {
+			return exec.NewContainerRemoveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+	}
+
+	if err = destroyContainer(ctx, vm); err != nil {
+		return exec.NewContainerRemoveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerRemoveOK()
+}
+
+// destroyContainer destroys the powered off container VM and removes its datastore folder
+func destroyContainer(ctx context.Context, vm *vm.VirtualMachine) error {
+	session := execSession
+
+	// find the folder before the VM goes away
+	var mvm mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"config.files.vmPathName"}, &mvm); err != nil {
+		return err
+	}
+	folder := path.Dir(mvm.Config.Files.VmPathName)
+
+	// unregisters the VM and deletes the files it knows about, including the container disk
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Destroy(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the serial port files are left behind, so remove the folder as a whole
+	fm := object.NewFileManager(session.Vim25())
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return fm.DeleteDatastoreFile(ctx, folder, session.Datacenter)
+	})
+	if err != nil {
+		// the VM is gone, so the container is removed as far as the caller is concerned
+		log.Warnf("Failed to remove container folder %s: %s", folder, err)
+	}
+
+	return nil
+}
+
+// ContainerListHandler lists the containers hosted by the VCH
+func (handler *ExecHandlersImpl) ContainerListHandler(params exec.ContainerListParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerList"))
+
+	ctx := context.Background()
+
+	all := params.All != nil && *params.All
+	containers, err := epl.Containers(ctx, execSession, all)
+	if err != nil {
+		return exec.NewContainerListInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	payload := make([]*models.ContainerInfo, 0, len(containers))
+	for _, c := range containers {
+		payload = append(payload, containerInfo(c))
+	}
+
+	return exec.NewContainerListOK().WithPayload(payload)
+}
+
+// ContainerInspectHandler returns the detailed configuration and state of the container
+func (handler *ExecHandlersImpl) ContainerInspectHandler(params exec.ContainerInspectParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerInspect"))
+
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, execSession, params.ID)
+	if err != nil {
+		if err == epl.ErrNotContainer {
+			return exec.NewContainerInspectNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("%s is not a container", params.ID)})
+		}
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerInspectInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerInspectNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerInspectOK().WithPayload(containerDetail(c))
+}
+
+// ContainerWaitHandler blocks until the container has exited, or the timeout has expired,
+// and returns the exit status of the primary process
+func (handler *ExecHandlersImpl) ContainerWaitHandler(params exec.ContainerWaitParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerWait"))
+
+	session := execSession
+	ctx := context.Background()
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerWaitInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		// the container may have been removed as soon as it exited
+		if exit := removedExit(params.ID); exit != nil {
+			return exec.NewContainerWaitOK().WithPayload(exit)
+		}
+		return exec.NewContainerWaitNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
+
+	timeout := int64(defaultWaitTimeout)
+	if params.Timeout != nil {
+		timeout = *params.Timeout
+	}
+
+	// the tether powers off the VM once the primary process has exited and the exit status
+	// has been recorded
+	wctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	err = vm.WaitForPowerState(wctx, types.VirtualMachinePowerStatePoweredOff)
+	cancel()
+	if err != nil {
+		if wctx.Err() == context.DeadlineExceeded {
+			return exec.NewContainerWaitRequestTimeout().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is still running", params.ID)})
+		}
+		if exit := removedExit(params.ID); exit != nil {
+			return exec.NewContainerWaitOK().WithPayload(exit)
+		}
+		return exec.NewContainerWaitInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	payload, err := waitedExit(params.ID, func() (*epl.Container, error) {
+		return epl.ContainerByID(ctx, session, params.ID)
+	})
+	if err != nil {
+		return exec.NewContainerWaitInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerWaitOK().WithPayload(payload)
+}
+
+// containerExit returns the exit status of the primary process of the container
+func containerExit(c *epl.Container) *models.ContainerExit {
+	exit := &models.ContainerExit{}
+	if primary, ok := c.ExecConfig.Sessions[c.ExecConfig.ID]; ok {
+		exitCode := int64(primary.ExitStatus)
+		finished := primary.Finished
+		exit.ExitCode = &exitCode
+		exit.Finished = &finished
+	}
+
+	return exit
+}
+
+// waitedExit returns the exit status of the container once it has powered off. A container
+// removed on exit may be gone by the time it's looked up, in which case the status kept from
+// before it was removed is returned.
+func waitedExit(id string, lookup func() (*epl.Container, error)) (*models.ContainerExit, error) {
+	c, err := lookup()
+	if err != nil {
+		if exit := removedExit(id); exit != nil {
+			return exit, nil
+		}
+		return nil, err
+	}
+
+	return containerExit(c), nil
+}
+
+// removedExit returns the exit status kept for the container removed on exit, or nil
+func removedExit(id string) *models.ContainerExit {
+	removedExitsMutex.Lock()
+	defer removedExitsMutex.Unlock()
+
+	return removedExits[id]
+}
+
+// removeExited removes a container that has exited, keeping its exit status for a while first so
+// that clients waiting on it are answered rather than finding it gone
+func removeExited(c *epl.Container, destroy func() error) error {
+	id := c.ExecConfig.ID
+
+	removedExitsMutex.Lock()
+	removedExits[id] = containerExit(c)
+	removedExitsMutex.Unlock()
+
+	time.AfterFunc(removedExitRetention, func() {
+		removedExitsMutex.Lock()
+		delete(removedExits, id)
+		removedExitsMutex.Unlock()
+	})
+
+	return destroy()
+}
+
+// ContainerExecCreateHandler adds a session to a running container. It isn't launched until
+// the session is started.
+func (handler *ExecHandlersImpl) ContainerExecCreateHandler(params exec.ContainerExecCreateParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerExecCreate"))
+
+	config := params.ExecConfig
+	if len(config.Cmd) == 0 {
+		return exec.NewContainerExecCreateBadRequest().WithPayload(&models.Error{Message: "no command specified"})
+	}
+
+	session := execSession
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, session, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerExecCreateInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerExecCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if c.State != epl.StateRunning {
+		return exec.NewContainerExecCreateConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not running", params.ID)})
+	}
+
+	// the session runs in the environment of the primary session unless told otherwise
+	var env []string
+	var dir string
+	if primary, ok := c.ExecConfig.Sessions[c.ExecConfig.ID]; ok {
+		env = append(env, primary.Cmd.Env...)
+		dir = primary.Cmd.Dir
+	}
+	env = append(env, config.Env...)
+	if config.WorkingDir != nil && *config.WorkingDir != "" {
+		dir = *config.WorkingDir
+	}
+
+	id := stringid.GenerateNonCryptoID()
+	s := &metadata.SessionConfig{
+		Common: metadata.Common{
+			ID: id,
+		},
+		Tty:       config.Tty != nil && *config.Tty,
+		OpenStdin: config.OpenStdin != nil && *config.OpenStdin,
+		Attach:    config.Attach != nil && *config.Attach,
+		Cmd: metadata.Cmd{
+			Path: config.Cmd[0],
+			Args: config.Cmd,
+			Env:  env,
+			Dir:  dir,
+		},
+	}
+	// the input of an exec ends with that of the client
+	s.StdinOnce = s.OpenStdin
+
+	if err = epl.AddSession(ctx, session, params.ID, s); err != nil {
+		return exec.NewContainerExecCreateInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerExecCreateOK().WithPayload(&models.ExecCreatedInfo{SessionID: &id})
+}
+
+// ContainerExecStartHandler pushes the executor config to the tether in the container, which
+// launches the sessions it hasn't already
+func (handler *ExecHandlersImpl) ContainerExecStartHandler(params exec.ContainerExecStartParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerExecStart(%s, %s)", params.ID, params.Session)))
+
+	session := execSession
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, session, params.ID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerExecStartInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerExecStartNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if _, ok := c.ExecConfig.Sessions[params.Session]; !ok {
+		return exec.NewContainerExecStartNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("container %s has no session %s", params.ID, params.Session)})
+	}
+
+	if c.State != epl.StateRunning {
+		return exec.NewContainerExecStartConflict().WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not running", params.ID)})
+	}
+
+	blob, err := metadata.New().StoreConfig(c.ExecConfig)
+	if err != nil {
+		return exec.NewContainerExecStartInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	conn, err := execConnector.Get(ctx, params.ID, tetherConnectTimeout)
+	if err != nil {
+		return exec.NewContainerExecStartInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	if err = conn.Reload(blob); err != nil {
+		return exec.NewContainerExecStartInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return exec.NewContainerExecStartOK()
+}
+
+// ContainerLogsHandler streams the session log of a container
+func (handler *ExecHandlersImpl) ContainerLogsHandler(params exec.ContainerLogsParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerLogs"))
+
+	session := execSession
+	ctx := context.Background()
+
+	if _, err := epl.ContainerByID(ctx, session, params.ID); err != nil {
+		if err == epl.ErrNotContainer {
+			return exec.NewContainerLogsNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("%s is not a container", params.ID)})
+		}
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerLogsInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerLogsNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	opts := epl.LogOptions{
+		Tail: -1,
+	}
+	if params.Tail != nil {
+		opts.Tail = *params.Tail
+	}
+	if params.Since != nil {
+		opts.Since = time.Unix(*params.Since, 0)
+	}
+	if params.Follow != nil {
+		opts.Follow = *params.Follow
+	}
+	if params.Timestamps != nil {
+		opts.Timestamps = *params.Timestamps
+	}
+
+	return &logsResponder{
+		id:   params.ID,
+		opts: opts,
+	}
+}
+
+// logsResponder streams the log once the response header has been written. Errors after that
+// point can only be logged.
+type logsResponder struct {
+	id   string
+	opts epl.LogOptions
+}
+
+// WriteResponse implements middleware.Responder
+func (r *logsResponder) WriteResponse(rw http.ResponseWriter, producer httpkit.Producer) {
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// stop following once the client goes away
+	if notifier, ok := rw.(http.CloseNotifier); ok {
+		closed := notifier.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	out := io.Writer(rw)
+	if flusher, ok := rw.(http.Flusher); ok {
+		out = &flushWriter{w: rw, f: flusher}
+	}
+
+	if err := epl.Logs(ctx, execSession, r.id, r.opts, out); err != nil {
+		log.Errorf("Failed to stream logs for %s: %s", r.id, err)
+	}
+}
+
+// flushWriter flushes after every write so that followed output is seen promptly
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// ContainerStatsHandler returns the most recent performance samples of the container VM
+func (handler *ExecHandlersImpl) ContainerStatsHandler(params exec.ContainerStatsParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerStats"))
+
+	session := execSession
+	ctx := context.Background()
+
+	if _, err := epl.ContainerByID(ctx, session, params.ID); err != nil {
+		if err == epl.ErrNotContainer {
+			return exec.NewContainerStatsNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("%s is not a container", params.ID)})
+		}
+		if !epl.IsNotFound(err) {
+			return exec.NewContainerStatsInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return exec.NewContainerStatsNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	samples := 1
+	if params.Samples != nil && *params.Samples > 0 {
+		samples = int(*params.Samples)
+	}
+
+	stats, err := epl.ContainerStats(ctx, session, params.ID, samples)
+	if err != nil {
+		return exec.NewContainerStatsInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	payload := make([]*models.ContainerStats, 0, len(stats))
+	for _, s := range stats {
+		payload = append(payload, &models.ContainerStats{
+			Read:             s.Read.Unix(),
+			Interval:         int64(s.Interval / time.Second),
+			NumCpus:          int64(s.NumCPUs),
+			CPUUsed:          int64(s.CPUUsed),
+			MemoryUsage:      int64(s.MemoryUsage),
+			MemoryLimit:      int64(s.MemoryLimit),
+			NetworkRxBytes:   int64(s.NetworkRxBytes),
+			NetworkTxBytes:   int64(s.NetworkTxBytes),
+			NetworkRxPackets: int64(s.NetworkRxPackets),
+			NetworkTxPackets: int64(s.NetworkTxPackets),
+			DiskReadBytes:    int64(s.DiskReadBytes),
+			DiskWriteBytes:   int64(s.DiskWriteBytes),
+			DiskReads:        int64(s.DiskReads),
+			DiskWrites:       int64(s.DiskWrites),
+		})
+	}
+
+	return exec.NewContainerStatsOK().WithPayload(payload)
+}
+
+// tetherHostKey returns the host key the tether in the container must present on the backchannel
+func tetherHostKey(id string) (ssh.PublicKey, error) {
+	c, err := epl.ContainerByID(context.Background(), execSession, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return epl.HostPublicKey(c.ExecConfig)
+}
+
+// recordExit persists the exit status reported by the tether in the container VM, and acts on
+// the exit if it was the primary process that exited
+func recordExit(id string, exit *msgs.ExitMsg) error {
+	err := epl.RecordExit(context.Background(), execSession, id, exit.ID, int(exit.ExitStatus), int64(exit.Finished))
+	if err != nil {
+		return err
+	}
+
+	if exit.ID == id {
+		go handlePrimaryExit(id, int(exit.ExitStatus))
+	}
+
+	return nil
+}
+
+// handlePrimaryExit removes the container once its VM has powered off if it was created to be
+// removed when it exits, or starts it again if its restart policy calls for it after the primary
+// process exited with status
+func handlePrimaryExit(id string, status int) {
+	defer trace.End(trace.Begin(id))
+
+	session := execSession
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, session, id)
+	if err != nil {
+		log.Errorf("Unable to check the exit policies of %s: %s", id, err)
+		return
+	}
+	autoRemove := c.VMConfig != nil && c.VMConfig.AutoRemove
+	if !autoRemove && !epl.ShouldRestart(c.VMConfig, status) {
+		return
+	}
+
+	foundvm, err := session.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		log.Errorf("Unable to find %s after it exited: %s", id, err)
+		return
+	}
+	vm := vm.NewVirtualMachine(ctx, session, foundvm.Reference())
+
+	// the tether powers off the VM once all of its sessions have exited, so exec sessions can
+	// hold it up - they're ended with the container as they would be with docker
+	wctx, cancel := context.WithTimeout(ctx, defaultStopTimeout*time.Second)
+	err = vm.WaitForPowerState(wctx, types.VirtualMachinePowerStatePoweredOff)
+	cancel()
+	if err != nil {
+		if err = powerOff(ctx, vm); err != nil {
+			log.Errorf("Unable to power off %s after it exited: %s", id, err)
+			return
+		}
+	}
+
+	if autoRemove {
+		log.Infof("Removing %s after exit status %d, as requested when it was created", id, status)
+		err = removeExited(c, func() error {
+			return destroyContainer(ctx, vm)
+		})
+		if err != nil {
+			log.Errorf("Unable to remove %s: %s", id, err)
+		}
+		return
+	}
+
+	time.Sleep(epl.RestartDelay(c.VMConfig.RestartCount))
+
+	// a client may have stopped, started or removed the container in the meantime
+	c, err = epl.ContainerByID(ctx, session, id)
+	if err != nil {
+		log.Infof("Not restarting %s: %s", id, err)
+		return
+	}
+	if c.State != epl.StateStopped || !epl.ShouldRestart(c.VMConfig, status) {
+		return
+	}
+
+	if err = epl.CountRestart(ctx, session, id); err != nil {
+		log.Errorf("Unable to restart %s: %s", id, err)
+		return
+	}
+
+	log.Infof("Restarting %s after exit status %d, as required by its %q restart policy", id, status, c.VMConfig.RestartPolicy.Name)
+	if err = startContainer(ctx, c, false); err != nil {
+		log.Errorf("Unable to restart %s: %s", id, err)
+	}
+}
+
+// reconcileContainers applies the exit policies of stopped containers, as their exits may have
+// gone unreported or unhandled while the port layer was down. Containers to be removed once they
+// exit are removed if their exit was recorded, as one that's yet to be started looks no different
+// otherwise. Containers restarted whatever their exit status are started unless a client stopped
+// them.
+func reconcileContainers() {
+	defer trace.End(trace.Begin(""))
+
+	session := execSession
+	ctx := context.Background()
+
+	containers, err := epl.Containers(ctx, session, true)
+	if err != nil {
+		log.Errorf("Unable to list containers to apply exit policies: %s", err)
+		return
+	}
+
+	for _, c := range containers {
+		if c.State != epl.StateStopped || c.VMConfig == nil {
+			continue
+		}
+
+		id := c.ExecConfig.ID
+		if c.VMConfig.AutoRemove {
+			if primary, ok := c.ExecConfig.Sessions[id]; ok && primary.Finished != 0 {
+				log.Infof("Removing %s, which exited without being removed", id)
+				err = removeExited(c, func() error {
+					return destroyContainer(ctx, vm.NewVirtualMachine(ctx, session, c.Ref))
+				})
+				if err != nil {
+					log.Errorf("Unable to remove %s: %s", id, err)
+				}
+			}
+			continue
+		}
+
+		if c.VMConfig.StoppedByUser {
+			continue
+		}
+
+		switch c.VMConfig.RestartPolicy.Name {
+		case epl.RestartAlways, epl.RestartUnlessStopped:
+		default:
+			continue
+		}
+
+		log.Infof("Starting %s, as required by its %q restart policy", id, c.VMConfig.RestartPolicy.Name)
+		if err = startContainer(ctx, c, false); err != nil {
+			log.Errorf("Unable to start %s: %s", id, err)
+		}
+	}
+}
+
+// restartPolicy converts the restart policy of a request
+func restartPolicy(p *models.RestartPolicy) metadata.RestartPolicy {
+	var policy metadata.RestartPolicy
+	if p == nil {
+		return policy
+	}
+
+	if p.Name != nil {
+		policy.Name = *p.Name
+	}
+	if p.MaximumRetryCount != nil {
+		policy.MaximumRetryCount = int(*p.MaximumRetryCount)
+	}
+
+	return policy
+}
+
+// resources converts the resource settings of a request
+func resources(r *models.ContainerResources) epl.Resources {
+	var res epl.Resources
+	if r == nil {
+		return res
+	}
+
+	if r.CPUCount != nil {
+		res.CPUCount = int32(*r.CPUCount)
+	}
+	if r.CPUShares != nil {
+		res.CPUShares = *r.CPUShares
+	}
+	if r.MemoryMB != nil {
+		res.MemoryMB = *r.MemoryMB
+	}
+	if r.MemoryReservationMB != nil {
+		res.MemoryReservationMB = *r.MemoryReservationMB
+	}
+
+	return res
+}
+
+// containerDetail converts the port layer container into the detailed API representation
+func containerDetail(c *epl.Container) *models.ContainerDetail {
+	config := c.ExecConfig
+	state := string(c.State)
+
+	detail := &models.ContainerDetail{
+		ContainerID: &config.ID,
+		Name:        &config.Name,
+		ImageID:     &config.ImageID,
+		ImageName:   &config.ImageName,
+		State:       &state,
+		Created:     &config.Created,
+		Labels:      config.Labels,
+	}
+
+	if c.VMConfig != nil {
+		detail.Version = &c.VMConfig.Version
+		detail.Aliases = c.VMConfig.Aliases
+
+		name := c.VMConfig.RestartPolicy.Name
+		retries := int64(c.VMConfig.RestartPolicy.MaximumRetryCount)
+		detail.RestartPolicy = &models.RestartPolicy{Name: &name, MaximumRetryCount: &retries}
+		count := int64(c.VMConfig.RestartCount)
+		detail.RestartCount = &count
+		detail.AutoRemove = &c.VMConfig.AutoRemove
+	}
+
+	// present the primary session first and the rest in a stable order
+	var ids []string
+	for id := range config.Sessions {
+		if id != config.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if _, ok := config.Sessions[config.ID]; ok {
+		ids = append([]string{config.ID}, ids...)
+	}
+
+	for _, id := range ids {
+		session := config.Sessions[id]
+		exitCode := int64(session.ExitStatus)
+		tty := session.Tty
+
+		detail.Sessions = append(detail.Sessions, &models.SessionDetail{
+			ID:         &session.ID,
+			Name:       &session.Name,
+			Path:       &session.Cmd.Path,
+			Args:       session.Cmd.Args,
+			Env:        session.Cmd.Env,
+			WorkingDir: &session.Cmd.Dir,
+			Tty:        &tty,
+			OpenStdin:  &session.OpenStdin,
+			ExitCode:   &exitCode,
+			Finished:   &session.Finished,
+		})
+
+		if id == config.ID {
+			detail.ExitCode = &exitCode
+		}
+	}
+
+	var names []string
+	for name := range config.Mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mount := config.Mounts[name]
+		mountName := name
+		source := mount.Source.String()
+
+		detail.Mounts = append(detail.Mounts, &models.MountDetail{
+			Name:        &mountName,
+			Source:      &source,
+			Destination: &mount.Path,
+			Mode:        &mount.Mode,
+		})
+	}
+
+	names = names[:0]
+	for name := range config.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		endpoint := config.Networks[name]
+		network := endpoint.Network.Name
+		mac := endpoint.MAC
+
+		ep := &models.EndpointDetail{
+			Network:    &network,
+			MacAddress: &mac,
+		}
+
+		if endpoint.IP.IP != nil {
+			ip := endpoint.IP.IP.String()
+			ones, _ := endpoint.IP.Mask.Size()
+			prefix := int64(ones)
+
+			ep.IPAddress = &ip
+			ep.IPPrefixLen = &prefix
+		}
+
+		if endpoint.Network.Gateway.IP != nil {
+			gateway := endpoint.Network.Gateway.IP.String()
+			ep.Gateway = &gateway
+		}
+
+		for _, ns := range endpoint.Network.Nameservers {
+			ep.Nameservers = append(ep.Nameservers, ns.String())
+		}
+
+		detail.Endpoints = append(detail.Endpoints, ep)
+	}
+
+	return detail
+}
+
+// containerInfo converts the port layer container into the API representation
+func containerInfo(c *epl.Container) *models.ContainerInfo {
+	config := c.ExecConfig
+	state := string(c.State)
+
+	info := &models.ContainerInfo{
+		ContainerID: &config.ID,
+		Name:        &config.Name,
+		ImageID:     &config.ImageID,
+		ImageName:   &config.ImageName,
+		State:       &state,
+		Created:     &config.Created,
+		Labels:      config.Labels,
+	}
+
+	// the primary session shares the container ID
+	if session, ok := config.Sessions[config.ID]; ok {
+		info.Cmd = session.Cmd.Args
+	}
+
+	return info
+}
+
+// powerOff powers off the container VM, tolerating it having already stopped
+func powerOff(ctx context.Context, vm *vm.VirtualMachine) error {
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.PowerOff(ctx)
+	})
+	if err == nil {
+		return nil
+	}
+
+	// the container may have exited of its own accord in the meantime
+	state, serr := vm.PowerState(ctx)
+	if serr == nil && state == types.VirtualMachinePowerStatePoweredOff {
+		return nil
+	}
+
+	return err
 }
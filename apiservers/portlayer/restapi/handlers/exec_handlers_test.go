@@ -0,0 +1,180 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/vic/metadata"
+
+	epl "github.com/vmware/vic/portlayer/exec"
+)
+
+func testContainer() *epl.Container {
+	return &epl.Container{
+		ExecConfig: &metadata.ExecutorConfig{
+			Common: metadata.Common{
+				ID:   "deadbeef",
+				Name: "test_container",
+			},
+			Sessions: map[string]*metadata.SessionConfig{
+				"feebdaed": &metadata.SessionConfig{
+					Common: metadata.Common{ID: "feebdaed"},
+					Cmd: metadata.Cmd{
+						Path: "/bin/ps",
+						Args: []string{"ps"},
+					},
+				},
+				"deadbeef": &metadata.SessionConfig{
+					Common: metadata.Common{ID: "deadbeef"},
+					Cmd: metadata.Cmd{
+						Path: "/bin/sleep",
+						Args: []string{"sleep", "60"},
+						Dir:  "/",
+					},
+					ExitStatus: 3,
+				},
+			},
+			Mounts: map[string]metadata.MountSpec{
+				"data": metadata.MountSpec{
+					Source: url.URL{Scheme: "label", Opaque: "data"},
+					Path:   "/data",
+					Mode:   "rw",
+				},
+			},
+			Networks: map[string]metadata.NetworkEndpoint{
+				"bridge": metadata.NetworkEndpoint{
+					IP: net.IPNet{
+						IP:   net.ParseIP("172.16.0.2"),
+						Mask: net.CIDRMask(16, 32),
+					},
+					MAC: "00:50:56:00:00:01",
+					Network: metadata.ContainerNetwork{
+						Name: "bridge",
+						Gateway: net.IPNet{
+							IP:   net.ParseIP("172.16.0.1"),
+							Mask: net.CIDRMask(16, 32),
+						},
+					},
+				},
+			},
+			ImageID:   "bc744c4ab376",
+			ImageName: "busybox",
+			Created:   1462000000,
+		},
+		VMConfig: &metadata.ContainerVM{
+			Version: "0.1",
+		},
+		State: epl.StateStopped,
+	}
+}
+
+func TestContainerInfo(t *testing.T) {
+	info := containerInfo(testContainer())
+
+	assert.Equal(t, "deadbeef", *info.ContainerID)
+	assert.Equal(t, "test_container", *info.Name)
+	assert.Equal(t, "busybox", *info.ImageName)
+	assert.Equal(t, string(epl.StateStopped), *info.State)
+	assert.Equal(t, int64(1462000000), *info.Created)
+	assert.Equal(t, []string{"sleep", "60"}, info.Cmd)
+}
+
+func TestContainerDetail(t *testing.T) {
+	detail := containerDetail(testContainer())
+
+	assert.Equal(t, "deadbeef", *detail.ContainerID)
+	assert.Equal(t, "0.1", *detail.Version)
+	assert.Equal(t, int64(3), *detail.ExitCode)
+
+	// the primary session comes first
+	if assert.Len(t, detail.Sessions, 2) {
+		assert.Equal(t, "deadbeef", *detail.Sessions[0].ID)
+		assert.Equal(t, "/bin/sleep", *detail.Sessions[0].Path)
+		assert.Equal(t, "feebdaed", *detail.Sessions[1].ID)
+	}
+
+	if assert.Len(t, detail.Mounts, 1) {
+		assert.Equal(t, "label:data", *detail.Mounts[0].Source)
+		assert.Equal(t, "/data", *detail.Mounts[0].Destination)
+	}
+
+	if assert.Len(t, detail.Endpoints, 1) {
+		ep := detail.Endpoints[0]
+		assert.Equal(t, "bridge", *ep.Network)
+		assert.Equal(t, "172.16.0.2", *ep.IPAddress)
+		assert.Equal(t, int64(16), *ep.IPPrefixLen)
+		assert.Equal(t, "172.16.0.1", *ep.Gateway)
+		assert.Equal(t, "00:50:56:00:00:01", *ep.MacAddress)
+	}
+}
+
+func TestWaitedExitAutoRemove(t *testing.T) {
+	c := testContainer()
+	c.ExecConfig.Sessions["deadbeef"].Finished = 1462000060
+
+	// the waiter looks the container up while it's being removed on exit
+	var mu sync.Mutex
+	removed := false
+	lookup := func() (*epl.Container, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if removed {
+			return nil, &find.NotFoundError{}
+		}
+		return c, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			exit, err := waitedExit("deadbeef", lookup)
+			if assert.NoError(t, err) {
+				assert.Equal(t, int64(3), *exit.ExitCode)
+				assert.Equal(t, int64(1462000060), *exit.Finished)
+			}
+		}()
+	}
+
+	err := removeExited(c, func() error {
+		mu.Lock()
+		removed = true
+		mu.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	wg.Wait()
+
+	// waiters arriving once the container has gone still get its exit status
+	exit, err := waitedExit("deadbeef", lookup)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(3), *exit.ExitCode)
+	}
+
+	// containers that weren't removed on exit aren't found
+	_, err = waitedExit("feebdaed", func() (*epl.Container, error) {
+		return nil, &find.NotFoundError{}
+	})
+	assert.Error(t, err)
+}
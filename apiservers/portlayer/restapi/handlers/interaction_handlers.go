@@ -0,0 +1,427 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/go-swagger/go-swagger/httpkit"
+	middleware "github.com/go-swagger/go-swagger/httpkit/middleware"
+	"golang.org/x/net/context"
+
+	"github.com/vmware/vic/apiservers/portlayer/models"
+	"github.com/vmware/vic/apiservers/portlayer/restapi/operations"
+	"github.com/vmware/vic/apiservers/portlayer/restapi/operations/interaction"
+	"github.com/vmware/vic/pkg/trace"
+
+	"github.com/vmware/vic/portlayer/attach"
+	epl "github.com/vmware/vic/portlayer/exec"
+)
+
+// InteractionHandlersImpl is the receiver for all of the interaction handler methods
+type InteractionHandlersImpl struct{}
+
+// how long a join waits for the tether in the container to connect, allowing for a container
+// that is attached to before it is started
+const joinTimeout = 2 * time.Minute
+
+// the containers with clients waiting to join, by ID. Containers started while a join is
+// pending have their primary session held until the client attaches so no output is missed.
+var (
+	pendingJoins      = make(map[string]int)
+	pendingJoinsMutex sync.Mutex
+)
+
+// Configure assigns functions to all the interaction api handlers
+func (handler *InteractionHandlersImpl) Configure(api *operations.PortLayerAPI) {
+	api.InteractionContainerJoinHandler = interaction.ContainerJoinHandlerFunc(handler.ContainerJoinHandler)
+	api.InteractionContainerStatPathHandler = interaction.ContainerStatPathHandlerFunc(handler.ContainerStatPathHandler)
+	api.InteractionContainerTopHandler = interaction.ContainerTopHandlerFunc(handler.ContainerTopHandler)
+	api.InteractionContainerReadArchiveHandler = interaction.ContainerReadArchiveHandlerFunc(handler.ContainerReadArchiveHandler)
+	api.InteractionContainerWriteArchiveHandler = interaction.ContainerWriteArchiveHandlerFunc(handler.ContainerWriteArchiveHandler)
+}
+
+// ContainerJoinHandler attaches the connection to the streams of a session of the container,
+// the primary session unless specified, waiting for the container to be started if necessary
+func (handler *InteractionHandlersImpl) ContainerJoinHandler(params interaction.ContainerJoinParams) middleware.Responder {
+	defer trace.End(trace.Begin("ContainerJoin"))
+
+	c, err := epl.ContainerByID(context.Background(), execSession, params.ID)
+	if err != nil {
+		if err == epl.ErrNotContainer {
+			return interaction.NewContainerJoinNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("%s is not a container", params.ID)})
+		}
+		if !epl.IsNotFound(err) {
+			return interaction.NewContainerJoinInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return interaction.NewContainerJoinNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	responder := &joinResponder{
+		id:      params.ID,
+		session: c.ExecConfig.ID,
+	}
+	if params.Session != nil && *params.Session != "" {
+		responder.session = *params.Session
+	}
+
+	s, ok := c.ExecConfig.Sessions[responder.session]
+	if !ok {
+		return interaction.NewContainerJoinNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("container %s has no session %s", params.ID, responder.session)})
+	}
+	responder.tty = s.Tty
+	if params.Stdin != nil {
+		responder.stdin = *params.Stdin
+	}
+	if params.Stdout != nil {
+		responder.stdout = *params.Stdout
+	}
+	if params.Stderr != nil {
+		responder.stderr = *params.Stderr
+	}
+
+	return responder
+}
+
+// joinPending returns true if a client is waiting to join the container
+func joinPending(id string) bool {
+	pendingJoinsMutex.Lock()
+	defer pendingJoinsMutex.Unlock()
+
+	return pendingJoins[id] > 0
+}
+
+func addPendingJoin(id string) {
+	pendingJoinsMutex.Lock()
+	defer pendingJoinsMutex.Unlock()
+
+	pendingJoins[id]++
+}
+
+func removePendingJoin(id string) {
+	pendingJoinsMutex.Lock()
+	defer pendingJoinsMutex.Unlock()
+
+	if pendingJoins[id]--; pendingJoins[id] <= 0 {
+		delete(pendingJoins, id)
+	}
+}
+
+// joinResponder hijacks the connection and splices it to an attach channel to the tether
+type joinResponder struct {
+	id      string
+	session string
+	tty     bool
+
+	stdin  bool
+	stdout bool
+	stderr bool
+}
+
+// WriteResponse implements middleware.Responder
+func (r *joinResponder) WriteResponse(rw http.ResponseWriter, producer httpkit.Producer) {
+	// the client may start the container as soon as it sees the response header, which only
+	// matters to the primary session
+	pending := r.session == r.id
+	if pending {
+		addPendingJoin(r.id)
+	}
+	defer func() {
+		if pending {
+			removePendingJoin(r.id)
+		}
+	}()
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		log.Errorf("Cannot join %s: connection cannot be hijacked", r.id)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		log.Errorf("Cannot join %s: %s", r.id, err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+
+	tether, err := execConnector.Get(context.Background(), r.id, joinTimeout)
+	if err != nil {
+		log.Errorf("Cannot join %s: %s", r.id, err)
+		return
+	}
+
+	// the container has been started, so whether it waits for us has been decided
+	if pending {
+		removePendingJoin(r.id)
+		pending = false
+	}
+
+	ch, err := tether.Attach(r.session)
+	if err != nil {
+		log.Errorf("Cannot join %s: %s", r.id, err)
+		return
+	}
+	defer ch.Close()
+
+	if r.stdin {
+		go func() {
+			// include anything the server buffered before the hijack
+			if _, err := io.Copy(ch, buf.Reader); err != nil {
+				log.Debugf("Input to %s ended: %s", r.id, err)
+			}
+			ch.CloseWrite()
+		}()
+	}
+
+	stdout := io.Writer(ioutil.Discard)
+	stderr := io.Writer(ioutil.Discard)
+	if r.tty {
+		if r.stdout {
+			stdout = conn
+		}
+	} else {
+		if r.stdout {
+			stdout = stdcopy.NewStdWriter(conn, stdcopy.Stdout)
+		}
+		if r.stderr {
+			stderr = stdcopy.NewStdWriter(conn, stdcopy.Stderr)
+		}
+	}
+
+	// stderr has to be drained even if it's not wanted as it shares flow control with stdout
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(stdout, ch)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(stderr, ch.Stderr())
+	}()
+	wg.Wait()
+
+	log.Infof("Session output for %s ended", r.id)
+}
+
+// runningTether returns the connection to the tether in the container if it's running, along
+// with the status code to respond with if it isn't
+func runningTether(ctx context.Context, id string) (*attach.Connection, int, error) {
+	c, err := epl.ContainerByID(ctx, execSession, id)
+	if err != nil {
+		if err == epl.ErrNotContainer {
+			return nil, http.StatusNotFound, fmt.Errorf("%s is not a container", id)
+		}
+		if !epl.IsNotFound(err) {
+			return nil, http.StatusInternalServerError, err
+		}
+		return nil, http.StatusNotFound, err
+	}
+
+	// the filesystem is only accessible through the tether
+	if c.State != epl.StateRunning {
+		return nil, http.StatusConflict, fmt.Errorf("container %s is not running", id)
+	}
+
+	conn, err := execConnector.Get(ctx, id, tetherConnectTimeout)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return conn, http.StatusOK, nil
+}
+
+// ContainerStatPathHandler describes a path in the container filesystem
+func (handler *InteractionHandlersImpl) ContainerStatPathHandler(params interaction.ContainerStatPathParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerStatPath(%s, %s)", params.ID, params.Path)))
+
+	conn, status, err := runningTether(context.Background(), params.ID)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return interaction.NewContainerStatPathNotFound().WithPayload(&models.Error{Message: err.Error()})
+	case http.StatusConflict:
+		return interaction.NewContainerStatPathConflict().WithPayload(&models.Error{Message: err.Error()})
+	default:
+		return interaction.NewContainerStatPathInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	stat, err := conn.Stat(params.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return interaction.NewContainerStatPathNotFound().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return interaction.NewContainerStatPathInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	size := int64(stat.Size)
+	mode := int64(stat.Mode)
+	mtime := int64(stat.Mtime)
+	return interaction.NewContainerStatPathOK().WithPayload(&models.PathStat{
+		Name:       &stat.Name,
+		Size:       &size,
+		Mode:       &mode,
+		Mtime:      &mtime,
+		LinkTarget: &stat.LinkTarget,
+	})
+}
+
+// ContainerTopHandler lists the processes running in the container
+func (handler *InteractionHandlersImpl) ContainerTopHandler(params interaction.ContainerTopParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	conn, status, err := runningTether(context.Background(), params.ID)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return interaction.NewContainerTopNotFound().WithPayload(&models.Error{Message: err.Error()})
+	case http.StatusConflict:
+		return interaction.NewContainerTopConflict().WithPayload(&models.Error{Message: err.Error()})
+	default:
+		return interaction.NewContainerTopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	var psArgs string
+	if params.PsArgs != nil {
+		psArgs = *params.PsArgs
+	}
+
+	titles, processes, err := conn.Top(psArgs)
+	if err != nil {
+		return interaction.NewContainerTopInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return interaction.NewContainerTopOK().WithPayload(&models.ProcessList{
+		Titles:    titles,
+		Processes: processes,
+	})
+}
+
+// ContainerReadArchiveHandler copies a regular file or directory out of the container as a tar
+// archive
+func (handler *InteractionHandlersImpl) ContainerReadArchiveHandler(params interaction.ContainerReadArchiveParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerReadArchive(%s, %s)", params.ID, params.Path)))
+
+	conn, status, err := runningTether(context.Background(), params.ID)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return interaction.NewContainerReadArchiveNotFound().WithPayload(&models.Error{Message: err.Error()})
+	case http.StatusConflict:
+		return interaction.NewContainerReadArchiveConflict().WithPayload(&models.Error{Message: err.Error()})
+	default:
+		return interaction.NewContainerReadArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	// the copy itself can't tell us why it was refused
+	if _, err = conn.Stat(params.Path); err != nil {
+		if os.IsNotExist(err) {
+			return interaction.NewContainerReadArchiveNotFound().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return interaction.NewContainerReadArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	content, err := conn.ArchiveFrom(params.Path)
+	if err != nil {
+		return interaction.NewContainerReadArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return &archiveResponder{
+		path:    params.Path,
+		content: content,
+	}
+}
+
+// archiveResponder streams an archive copied from a container
+type archiveResponder struct {
+	path    string
+	content io.ReadCloser
+}
+
+// WriteResponse implements middleware.Responder
+func (r *archiveResponder) WriteResponse(rw http.ResponseWriter, producer httpkit.Producer) {
+	defer r.content.Close()
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(rw, r.content); err != nil {
+		log.Errorf("Failed to copy %s: %s", r.path, err)
+	}
+}
+
+// ContainerWriteArchiveHandler extracts the tar archive in the request body into the directory at
+// the path in the container. The directory keeps its mode.
+func (handler *InteractionHandlersImpl) ContainerWriteArchiveHandler(params interaction.ContainerWriteArchiveParams) middleware.Responder {
+	defer trace.End(trace.Begin(fmt.Sprintf("ContainerWriteArchive(%s, %s)", params.ID, params.Path)))
+
+	if params.Archive != nil {
+		defer params.Archive.Close()
+	}
+
+	conn, status, err := runningTether(context.Background(), params.ID)
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return interaction.NewContainerWriteArchiveNotFound().WithPayload(&models.Error{Message: err.Error()})
+	case http.StatusConflict:
+		return interaction.NewContainerWriteArchiveConflict().WithPayload(&models.Error{Message: err.Error()})
+	default:
+		return interaction.NewContainerWriteArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	stat, err := conn.Stat(params.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return interaction.NewContainerWriteArchiveNotFound().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return interaction.NewContainerWriteArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	mode := os.FileMode(stat.Mode)
+	if !mode.IsDir() {
+		return interaction.NewContainerWriteArchiveBadRequest().WithPayload(&models.Error{Message: fmt.Sprintf("%s is not a directory", params.Path)})
+	}
+
+	body := io.Reader(params.Archive)
+	if body == nil {
+		body = &bytes.Buffer{}
+	}
+
+	// as with docker, directories and files replace each other unless the client says otherwise
+	replace := params.NoOverwriteDirNonDir == nil || !*params.NoOverwriteDirNonDir
+
+	if err = conn.ArchiveTo(params.Path, mode.Perm(), body, replace); err != nil {
+		if aerr, ok := err.(*attach.ArchiveError); ok && aerr.Invalid {
+			return interaction.NewContainerWriteArchiveBadRequest().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return interaction.NewContainerWriteArchiveInternalServerError().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return interaction.NewContainerWriteArchiveOK()
+}
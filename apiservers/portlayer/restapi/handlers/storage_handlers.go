@@ -15,10 +15,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/go-swagger/go-swagger/httpkit"
 	"github.com/go-swagger/go-swagger/httpkit/middleware"
 	"github.com/go-swagger/go-swagger/swag"
 	"golang.org/x/net/context"
@@ -27,8 +31,10 @@ import (
 	"github.com/vmware/vic/apiservers/portlayer/restapi/operations"
 	"github.com/vmware/vic/apiservers/portlayer/restapi/operations/storage"
 	"github.com/vmware/vic/apiservers/portlayer/restapi/options"
+	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/session"
 
+	epl "github.com/vmware/vic/portlayer/exec"
 	spl "github.com/vmware/vic/portlayer/storage"
 	"github.com/vmware/vic/portlayer/util"
 	vsphere "github.com/vmware/vic/portlayer/vsphere/storage"
@@ -38,8 +44,9 @@ import (
 type StorageHandlersImpl struct{}
 
 var (
-	storageSession = &session.Session{}
-	storageLayer   = &spl.NameLookupCache{}
+	storageSession    = &session.Session{}
+	storageLayer      = &spl.NameLookupCache{}
+	storageReferences = &spl.ReferenceCache{}
 )
 
 // Configure assigns functions to all the storage api handlers
@@ -73,11 +80,26 @@ func (handler *StorageHandlersImpl) Configure(api *operations.PortLayerAPI) {
 	// expensive metadata lookups.
 	storageLayer.DataStore = ds
 
+	// Images pulled before the port layer started are only on the datastore.
+	if err = storageLayer.Rehydrate(ctx); err != nil {
+		log.Panicf("Cannot load the image stores: %s", err)
+	}
+
+	// The references to images are kept alongside the images themselves.
+	storageReferences.DataStore = ds
+
 	api.StorageCreateImageStoreHandler = storage.CreateImageStoreHandlerFunc(handler.CreateImageStore)
 	api.StorageGetImageHandler = storage.GetImageHandlerFunc(handler.GetImage)
 	api.StorageGetImageTarHandler = storage.GetImageTarHandlerFunc(handler.GetImageTar)
 	api.StorageListImagesHandler = storage.ListImagesHandlerFunc(handler.ListImages)
 	api.StorageWriteImageHandler = storage.WriteImageHandlerFunc(handler.WriteImage)
+	api.StorageCommitImageHandler = storage.CommitImageHandlerFunc(handler.CommitImage)
+	api.StorageContainerChangesHandler = storage.ContainerChangesHandlerFunc(handler.ContainerChanges)
+	api.StorageExportContainerHandler = storage.ExportContainerHandlerFunc(handler.ExportContainer)
+	api.StorageListReferencesHandler = storage.ListReferencesHandlerFunc(handler.ListReferences)
+	api.StorageAddReferenceHandler = storage.AddReferenceHandlerFunc(handler.AddReference)
+	api.StorageRemoveReferenceHandler = storage.RemoveReferenceHandlerFunc(handler.RemoveReference)
+	api.StorageDeleteImageHandler = storage.DeleteImageHandlerFunc(handler.DeleteImage)
 }
 
 // CreateImageStore creates a new image store
@@ -140,7 +162,6 @@ func (handler *StorageHandlersImpl) ListImages(params storage.ListImagesParams)
 			})
 	}
 
-	// FIXME(jzt): not populating the cache at startup will result in 404's
 	images, err := storageLayer.ListImages(context.TODO(), u, params.Ids)
 	if err != nil {
 		return storage.NewListImagesNotFound().WithPayload(
@@ -174,7 +195,16 @@ func (handler *StorageHandlersImpl) WriteImage(params storage.WriteImageParams)
 		ID:    params.ParentID,
 	}
 
-	image, err := storageLayer.WriteImage(context.TODO(), parent, params.ImageID, params.Sum, params.ImageFile)
+	meta, err := imageMetadata(params.ImageMetadata)
+	if err != nil {
+		return storage.NewWriteImageDefault(http.StatusBadRequest).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusBadRequest),
+				Message: err.Error(),
+			})
+	}
+
+	image, err := storageLayer.WriteImage(context.TODO(), parent, params.ImageID, meta, params.Sum, params.ImageFile)
 	if err != nil {
 		return storage.NewWriteImageDefault(http.StatusInternalServerError).WithPayload(
 			&models.Error{
@@ -186,6 +216,443 @@ func (handler *StorageHandlersImpl) WriteImage(params storage.WriteImageParams)
 	return storage.NewWriteImageCreated().WithPayload(i)
 }
 
+// CommitImage writes the changes made in a stopped container to a new image in an image store
+func (handler *StorageHandlersImpl) CommitImage(params storage.CommitImageParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ContainerID))
+
+	ctx := context.Background()
+
+	u, err := util.StoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewCommitImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	c, err := epl.ContainerByID(ctx, storageSession, params.ContainerID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return storage.NewCommitImageDefault(http.StatusInternalServerError).WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusInternalServerError),
+					Message: err.Error(),
+				})
+		}
+
+		return storage.NewCommitImageNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: err.Error(),
+			})
+	}
+
+	// the root disk can't be read consistently while the container is using it
+	if c.State != epl.StateStopped {
+		return storage.NewCommitImageConflict().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusConflict),
+				Message: fmt.Sprintf("container %s must be stopped to be committed", params.ContainerID),
+			})
+	}
+
+	parent := &spl.Image{
+		Store: u,
+		ID:    c.ExecConfig.ImageID,
+	}
+
+	meta, err := imageMetadata(params.ImageMetadata)
+	if err != nil {
+		return storage.NewCommitImageDefault(http.StatusBadRequest).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusBadRequest),
+				Message: err.Error(),
+			})
+	}
+
+	image, err := storageLayer.CommitImage(ctx, parent, params.ImageID, params.ContainerID, meta)
+	if err != nil {
+		if os.IsExist(err) {
+			return storage.NewCommitImageConflict().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusConflict),
+					Message: "An image with that ID already exists",
+				})
+		}
+
+		return storage.NewCommitImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+	i := convertImage(image)
+	return storage.NewCommitImageCreated().WithPayload(i)
+}
+
+// ContainerChanges lists the changes made in a stopped container relative to its image
+func (handler *StorageHandlersImpl) ContainerChanges(params storage.ContainerChangesParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ContainerID))
+
+	ctx := context.Background()
+
+	u, err := util.StoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewContainerChangesDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	c, err := epl.ContainerByID(ctx, storageSession, params.ContainerID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return storage.NewContainerChangesDefault(http.StatusInternalServerError).WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusInternalServerError),
+					Message: err.Error(),
+				})
+		}
+
+		return storage.NewContainerChangesNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: err.Error(),
+			})
+	}
+
+	// the root disk is locked while the container is using it
+	if c.State != epl.StateStopped {
+		return storage.NewContainerChangesConflict().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusConflict),
+				Message: fmt.Sprintf("container %s must be stopped to list its changes", params.ContainerID),
+			})
+	}
+
+	parent := &spl.Image{
+		Store: u,
+		ID:    c.ExecConfig.ImageID,
+	}
+
+	changes, err := storageLayer.ContainerChanges(ctx, parent, params.ContainerID)
+	if err != nil {
+		return storage.NewContainerChangesDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	result := make([]*models.Change, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, &models.Change{
+			Path: change.Path,
+			Kind: int64(change.Kind),
+		})
+	}
+	return storage.NewContainerChangesOK().WithPayload(result)
+}
+
+// ExportContainer streams the filesystem of a stopped container as a tar
+func (handler *StorageHandlersImpl) ExportContainer(params storage.ExportContainerParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ContainerID))
+
+	ctx := context.Background()
+
+	c, err := epl.ContainerByID(ctx, storageSession, params.ContainerID)
+	if err != nil {
+		if !epl.IsNotFound(err) {
+			return storage.NewExportContainerDefault(http.StatusInternalServerError).WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusInternalServerError),
+					Message: err.Error(),
+				})
+		}
+
+		return storage.NewExportContainerNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: err.Error(),
+			})
+	}
+
+	// the root disk is locked while the container is using it
+	if c.State != epl.StateStopped {
+		return storage.NewExportContainerConflict().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusConflict),
+				Message: fmt.Sprintf("container %s must be stopped to be exported", params.ContainerID),
+			})
+	}
+
+	tar, err := storageLayer.ExportContainer(ctx, params.ContainerID)
+	if err != nil {
+		return storage.NewExportContainerDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return &exportResponder{
+		id:  params.ContainerID,
+		tar: tar,
+	}
+}
+
+// ListReferences returns the references to the images in a store
+func (handler *StorageHandlersImpl) ListReferences(params storage.ListReferencesParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.StoreName))
+
+	ctx := context.Background()
+
+	u, err := storageLayer.GetImageStore(ctx, params.StoreName)
+	if err != nil {
+		return storage.NewListReferencesNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: fmt.Sprintf("image store %s doesn't exist", params.StoreName),
+			})
+	}
+
+	refs, err := storageReferences.ListReferences(ctx, u)
+	if err != nil {
+		return storage.NewListReferencesDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	result := make([]*models.ImageReference, 0, len(refs))
+	for _, ref := range refs {
+		result = append(result, convertReference(ref))
+	}
+	return storage.NewListReferencesOK().WithPayload(result)
+}
+
+// AddReference makes a repository and tag or digest refer to an image in a store
+func (handler *StorageHandlersImpl) AddReference(params storage.AddReferenceParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.StoreName))
+
+	ctx := context.Background()
+
+	u, err := util.StoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewAddReferenceDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	ref := spl.Reference{
+		Repository: params.Reference.Repository,
+		Tag:        swag.StringValue(params.Reference.Tag),
+		Digest:     swag.StringValue(params.Reference.Digest),
+		ImageID:    params.Reference.ImageID,
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		return storage.NewAddReferenceBadRequest().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusBadRequest),
+				Message: fmt.Sprintf("reference to %s needs a tag or a digest", ref.Repository),
+			})
+	}
+
+	// only images that exist can be referred to
+	if _, err = storageLayer.GetImage(ctx, u, ref.ImageID); err != nil {
+		return storage.NewAddReferenceNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: err.Error(),
+			})
+	}
+
+	if err = storageReferences.AddReference(ctx, u, ref); err != nil {
+		return storage.NewAddReferenceDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+	return storage.NewAddReferenceOK()
+}
+
+// RemoveReference removes a reference to an image in a store, leaving the image in place
+func (handler *StorageHandlersImpl) RemoveReference(params storage.RemoveReferenceParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.StoreName))
+
+	ctx := context.Background()
+
+	u, err := util.StoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewRemoveReferenceDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	ref := spl.Reference{
+		Repository: params.Repository,
+		Tag:        swag.StringValue(params.Tag),
+		Digest:     swag.StringValue(params.Digest),
+	}
+
+	removed, err := storageReferences.RemoveReference(ctx, u, ref)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storage.NewRemoveReferenceNotFound().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusNotFound),
+					Message: fmt.Sprintf("no such reference: %s", ref),
+				})
+		}
+
+		return storage.NewRemoveReferenceDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+	return storage.NewRemoveReferenceOK().WithPayload(convertReference(*removed))
+}
+
+// DeleteImage removes the references to an image, then the image itself unless other images or
+// containers are derived from it
+func (handler *StorageHandlersImpl) DeleteImage(params storage.DeleteImageParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	ctx := context.Background()
+
+	u, err := util.StoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	image, err := storageLayer.GetImage(ctx, u, params.ID)
+	if err != nil {
+		return storage.NewDeleteImageNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: err.Error(),
+			})
+	}
+
+	inUse, err := imageInUse(ctx, image)
+	if err != nil {
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	force := params.Force != nil && *params.Force
+	if inUse != "" && !force {
+		return storage.NewDeleteImageConflict().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusConflict),
+				Message: inUse,
+			})
+	}
+
+	// the image is untagged first so that it's never referred to once it's gone
+	refs, err := storageReferences.RemoveImageReferences(ctx, u, image.ID)
+	if err != nil {
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	result := &models.ImageDeleted{}
+	for _, ref := range refs {
+		result.Untagged = append(result.Untagged, convertReference(ref))
+	}
+
+	// the disks derived from the layer can't do without it, so forcing only untags it
+	if inUse != "" {
+		log.Infof("Untagged image %s without deleting it: %s", image.ID, inUse)
+		return storage.NewDeleteImageOK().WithPayload(result)
+	}
+
+	if err = storageLayer.DeleteImage(ctx, image); err != nil {
+		if err == spl.ErrImageInUse {
+			return storage.NewDeleteImageConflict().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusConflict),
+					Message: err.Error(),
+				})
+		}
+
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	result.Deleted = true
+	return storage.NewDeleteImageOK().WithPayload(result)
+}
+
+// imageInUse returns why the image can't be deleted, or an empty string if nothing is derived
+// from it.  Every container has a disk derived from its image, whether or not it's running.
+func imageInUse(ctx context.Context, image *spl.Image) (string, error) {
+	if image.ID == spl.Scratch.ID {
+		return "the root of the image store can't be deleted", nil
+	}
+
+	children, err := storageLayer.Children(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if len(children) > 0 {
+		return spl.ErrImageInUse.Error(), nil
+	}
+
+	containers, err := epl.Containers(ctx, storageSession, true)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range containers {
+		if c.ExecConfig != nil && c.ExecConfig.ImageID == image.ID {
+			return fmt.Sprintf("image is being used by container %s", c.ExecConfig.ID), nil
+		}
+	}
+
+	return "", nil
+}
+
+// exportResponder streams the tar of a container filesystem
+type exportResponder struct {
+	id  string
+	tar io.ReadCloser
+}
+
+// WriteResponse implements middleware.Responder
+func (r *exportResponder) WriteResponse(rw http.ResponseWriter, producer httpkit.Producer) {
+	defer r.tar.Close()
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(rw, r.tar); err != nil {
+		log.Errorf("Failed to export %s: %s", r.id, err)
+	}
+}
+
 // convert an SPL Image to a swagger-defined Image
 func convertImage(image *spl.Image) *models.Image {
 	var parent, selfLink *string
@@ -201,10 +668,59 @@ func convertImage(image *spl.Image) *models.Image {
 		selfLink = &l
 	}
 
+	var meta map[string]string
+	if len(image.Metadata) > 0 {
+		meta = make(map[string]string, len(image.Metadata))
+		for k, v := range image.Metadata {
+			meta[k] = string(v)
+		}
+	}
+
 	return &models.Image{
 		ID:       image.ID,
 		SelfLink: selfLink,
 		Parent:   parent,
 		Store:    image.Store.String(),
+		Metadata: meta,
+	}
+}
+
+// imageMetadata returns the metadata to save with an image from the optional
+// JSON object of strings keyed by name
+func imageMetadata(header *string) (map[string][]byte, error) {
+	if header == nil || *header == "" {
+		return nil, nil
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal([]byte(*header), &values); err != nil {
+		return nil, fmt.Errorf("invalid image metadata: %s", err)
+	}
+
+	meta := make(map[string][]byte, len(values))
+	for k, v := range values {
+		meta[k] = []byte(v)
+	}
+
+	return meta, nil
+}
+
+// convert an SPL Reference to a swagger-defined ImageReference
+func convertReference(ref spl.Reference) *models.ImageReference {
+	var tag, digest *string
+
+	if ref.Tag != "" {
+		tag = swag.String(ref.Tag)
+	}
+
+	if ref.Digest != "" {
+		digest = swag.String(ref.Digest)
+	}
+
+	return &models.ImageReference{
+		Repository: ref.Repository,
+		Tag:        tag,
+		Digest:     digest,
+		ImageID:    ref.ImageID,
 	}
 }
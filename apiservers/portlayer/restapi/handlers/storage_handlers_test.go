@@ -25,6 +25,7 @@ import (
 	"golang.org/x/net/context"
 
 	//"github.com/go-swagger/go-swagger/httpkit/middleware"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/go-swagger/go-swagger/swag"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/vic/apiservers/portlayer/models"
@@ -46,12 +47,13 @@ var (
 )
 
 type MockDataStore struct {
+	refs map[url.URL][]spl.Reference
 }
 
 // GetImageStore checks to see if a named image store exists and returls the
 // URL to it if so or error.
 func (c *MockDataStore) GetImageStore(ctx context.Context, storeName string) (*url.URL, error) {
-	return nil, nil
+	return nil, os.ErrNotExist
 }
 
 func (c *MockDataStore) CreateImageStore(ctx context.Context, storeName string) (*url.URL, error) {
@@ -67,16 +69,29 @@ func (c *MockDataStore) ListImageStores(ctx context.Context) ([]*url.URL, error)
 	return nil, nil
 }
 
-func (c *MockDataStore) WriteImage(ctx context.Context, parent *spl.Image, ID string, r io.Reader) (*spl.Image, error) {
+func (c *MockDataStore) WriteImage(ctx context.Context, parent *spl.Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*spl.Image, error) {
 	i := spl.Image{
-		ID:     ID,
-		Store:  parent.Store,
-		Parent: parent.SelfLink,
+		ID:       ID,
+		Store:    parent.Store,
+		Parent:   parent.SelfLink,
+		Metadata: meta,
 	}
 
 	return &i, nil
 }
 
+func (c *MockDataStore) CommitImage(ctx context.Context, parent *spl.Image, ID, containerID string, meta map[string][]byte) (*spl.Image, error) {
+	return c.WriteImage(ctx, parent, ID, meta, "", nil)
+}
+
+func (c *MockDataStore) ContainerChanges(ctx context.Context, parent *spl.Image, containerID string) ([]archive.Change, error) {
+	return nil, nil
+}
+
+func (c *MockDataStore) ExportContainer(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	return nil, nil
+}
+
 // GetImage gets the specified image from the given store by retreiving it from the cache.
 func (c *MockDataStore) GetImage(ctx context.Context, store *url.URL, ID string) (*spl.Image, error) {
 	return nil, nil
@@ -87,6 +102,22 @@ func (c *MockDataStore) ListImages(ctx context.Context, store *url.URL, IDs []st
 	return nil, nil
 }
 
+func (c *MockDataStore) DeleteImage(ctx context.Context, image *spl.Image) error {
+	return nil
+}
+
+func (c *MockDataStore) ReadReferences(ctx context.Context, store *url.URL) ([]spl.Reference, error) {
+	return c.refs[*store], nil
+}
+
+func (c *MockDataStore) WriteReferences(ctx context.Context, store *url.URL, refs []spl.Reference) error {
+	if c.refs == nil {
+		c.refs = make(map[url.URL][]spl.Reference)
+	}
+	c.refs[*store] = refs
+	return nil
+}
+
 func TestCreateImageStore(t *testing.T) {
 	storageLayer = &spl.NameLookupCache{
 		DataStore: &MockDataStore{},
@@ -187,7 +218,7 @@ func TestGetImage(t *testing.T) {
 	}
 
 	// add the image to the store
-	image, err := storageLayer.WriteImage(context.TODO(), &parent, testImageID, testImageSum, nil)
+	image, err := storageLayer.WriteImage(context.TODO(), &parent, testImageID, nil, testImageSum, nil)
 	if !assert.NotNil(t, image) {
 		return
 	}
@@ -254,7 +285,7 @@ func TestListImages(t *testing.T) {
 	parent.Store = &testStoreURL
 	for i := 1; i < 50; i++ {
 		id := fmt.Sprintf("id-%d", i)
-		img, err := storageLayer.WriteImage(context.TODO(), &parent, id, testImageSum, nil)
+		img, err := storageLayer.WriteImage(context.TODO(), &parent, id, nil, testImageSum, nil)
 		if !assert.NoError(t, err) {
 			return
 		}
@@ -341,4 +372,172 @@ func TestWriteImage(t *testing.T) {
 	if !assert.Equal(t, expected, result) {
 		return
 	}
+
+	// the metadata is saved with the image
+	params.ImageID = "withMetadata"
+	params.ImageMetadata = swag.String(`{"config":"{\"Cmd\":[\"sh\"]}"}`)
+
+	expected.Payload.ID = params.ImageID
+	expected.Payload.Metadata = map[string]string{"config": `{"Cmd":["sh"]}`}
+
+	result = s.WriteImage(*params)
+	if !assert.NotNil(t, result) {
+		return
+	}
+	if !assert.Equal(t, expected, result) {
+		return
+	}
+
+	// metadata that isn't a JSON object of strings is rejected
+	params.ImageID = "badMetadata"
+	params.ImageMetadata = swag.String(`{"config":{"Cmd":["sh"]}}`)
+
+	result = s.WriteImage(*params)
+	if !assert.IsType(t, &storage.WriteImageDefault{}, result) {
+		return
+	}
+	assert.Equal(t, int64(http.StatusBadRequest), *result.(*storage.WriteImageDefault).Payload.Code)
+}
+
+func TestReferences(t *testing.T) {
+	ds := &MockDataStore{}
+	storageLayer = &spl.NameLookupCache{
+		DataStore: ds,
+	}
+	storageReferences = &spl.ReferenceCache{
+		DataStore: ds,
+	}
+
+	s := &StorageHandlersImpl{}
+
+	listParams := storage.ListReferencesParams{StoreName: testStoreName}
+	addParams := storage.AddReferenceParams{
+		StoreName: testStoreName,
+		Reference: &models.ImageReference{
+			Repository: "busybox",
+			Tag:        swag.String("latest"),
+			ImageID:    testImageID,
+		},
+	}
+
+	// expect 404 if image store doesn't exist
+	if !assert.IsType(t, &storage.ListReferencesNotFound{}, s.ListReferences(listParams)) {
+		return
+	}
+
+	_, err := storageLayer.CreateImageStore(context.TODO(), testStoreName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// expect 404 if the image doesn't exist
+	if !assert.IsType(t, &storage.AddReferenceNotFound{}, s.AddReference(addParams)) {
+		return
+	}
+
+	parent := spl.Scratch
+	parent.Store = &testStoreURL
+	_, err = storageLayer.WriteImage(context.TODO(), &parent, testImageID, nil, testImageSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.IsType(t, &storage.AddReferenceOK{}, s.AddReference(addParams)) {
+		return
+	}
+
+	// expect 400 without a tag or digest
+	untagged := addParams
+	untagged.Reference = &models.ImageReference{Repository: "busybox", ImageID: testImageID}
+	if !assert.IsType(t, &storage.AddReferenceBadRequest{}, s.AddReference(untagged)) {
+		return
+	}
+
+	result := s.ListReferences(listParams)
+	if !assert.IsType(t, &storage.ListReferencesOK{}, result) {
+		return
+	}
+	assert.Equal(t, []*models.ImageReference{addParams.Reference}, result.(*storage.ListReferencesOK).Payload)
+}
+
+func TestRemoveReference(t *testing.T) {
+	ds := &MockDataStore{}
+	storageReferences = &spl.ReferenceCache{
+		DataStore: ds,
+	}
+
+	s := &StorageHandlersImpl{}
+
+	params := storage.RemoveReferenceParams{
+		StoreName:  testStoreName,
+		Repository: "busybox",
+		Tag:        swag.String("latest"),
+	}
+
+	// expect 404 until there's a reference to remove
+	if !assert.IsType(t, &storage.RemoveReferenceNotFound{}, s.RemoveReference(params)) {
+		return
+	}
+
+	ref := spl.Reference{Repository: "busybox", Tag: "latest", ImageID: testImageID}
+	if !assert.NoError(t, storageReferences.AddReference(context.TODO(), &testStoreURL, ref)) {
+		return
+	}
+
+	result := s.RemoveReference(params)
+	if !assert.IsType(t, &storage.RemoveReferenceOK{}, result) {
+		return
+	}
+	assert.Equal(t, convertReference(ref), result.(*storage.RemoveReferenceOK).Payload)
+	assert.Empty(t, ds.refs[testStoreURL])
+}
+
+func TestDeleteImage(t *testing.T) {
+	ds := &MockDataStore{}
+	storageLayer = &spl.NameLookupCache{
+		DataStore: ds,
+	}
+	storageReferences = &spl.ReferenceCache{
+		DataStore: ds,
+	}
+
+	s := &StorageHandlersImpl{}
+
+	params := storage.DeleteImageParams{
+		StoreName: testStoreName,
+		ID:        testImageID,
+	}
+
+	// expect 404 if the image doesn't exist
+	if !assert.IsType(t, &storage.DeleteImageNotFound{}, s.DeleteImage(params)) {
+		return
+	}
+
+	_, err := storageLayer.CreateImageStore(context.TODO(), testStoreName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// expect 409 for the root of the store, which everything is derived from
+	params.ID = spl.Scratch.ID
+	if !assert.IsType(t, &storage.DeleteImageConflict{}, s.DeleteImage(params)) {
+		return
+	}
+
+	// forcing it only removes the references to it
+	ref := spl.Reference{Repository: "scratch", Tag: "latest", ImageID: spl.Scratch.ID}
+	if !assert.NoError(t, storageReferences.AddReference(context.TODO(), &testStoreURL, ref)) {
+		return
+	}
+
+	params.Force = swag.Bool(true)
+	result := s.DeleteImage(params)
+	if !assert.IsType(t, &storage.DeleteImageOK{}, result) {
+		return
+	}
+	assert.Equal(t, &models.ImageDeleted{Untagged: []*models.ImageReference{convertReference(ref)}},
+		result.(*storage.DeleteImageOK).Payload)
+
+	_, err = storageLayer.GetImage(context.TODO(), &testStoreURL, spl.Scratch.ID)
+	assert.NoError(t, err)
 }
@@ -30,6 +30,12 @@ type PortLayerOptionsType struct {
 
 	VCHName string `long:"vch" default:"" description:"VCH name" env:"VCH_NAME" required:"true"`
 
+	// the size of container VMs that don't ask for one, where the VCH configuration doesn't set it
+	ContainerCPUs     int32 `long:"container-cpus" default:"2" description:"vCPUs of container VMs that don't specify a number, unless set by the VCH configuration" env:"CONTAINER_CPUS"`
+	ContainerMemoryMB int64 `long:"container-memory" default:"2048" description:"Memory in MB of container VMs that don't specify an amount, unless set by the VCH configuration" env:"CONTAINER_MEMORY_MB"`
+
+	PauseMode string `long:"pause-mode" default:"suspend" choice:"suspend" choice:"freeze" description:"Pause containers by suspending the container VM or by freezing its processes" env:"PAUSE_MODE"`
+
 	Debug bool `long:"debug" default:"true" description:"Debug logging"`
 }
 
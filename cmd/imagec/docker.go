@@ -24,11 +24,15 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/progress"
+	"github.com/docker/libtrust"
 
 	"github.com/vmware/vic/pkg/trace"
 )
@@ -51,6 +55,9 @@ type Manifest struct {
 	FSLayers []FSLayer `json:"fsLayers"`
 	History  []History `json:"history"`
 	// ignoring signatures
+
+	// Digest is the digest of the manifest as the registry computes it
+	Digest string `json:"-"`
 }
 
 // V1Compatibility represents some parts of V1Compatibility
@@ -182,10 +189,31 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) error {
 	}
 	defer layerFile.Close()
 
-	_, err = io.Copy(layerFile, t)
+	// the size of the layer once uncompressed is counted as it's written
+	pr, pw := io.Pipe()
+	counted := make(chan error, 1)
+	go func() {
+		r, err := archive.DecompressStream(pr)
+		if err == nil {
+			image.size, err = io.Copy(ioutil.Discard, r)
+			r.Close()
+		}
+		if err == nil {
+			// anything trailing the compressed stream is still read so the write isn't held up
+			_, err = io.Copy(ioutil.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		counted <- err
+	}()
+
+	_, err = io.Copy(io.MultiWriter(layerFile, pw), t)
+	pw.CloseWithError(err)
 	if err != nil {
 		return err
 	}
+	if err = <-counted; err != nil {
+		return err
+	}
 
 	if err := layerFile.Sync(); err != nil {
 		return err
@@ -202,6 +230,26 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) error {
 	return nil
 }
 
+// LayerMetadata returns the V1Compatibility history of the layer with the
+// size of the layer added, as docker records it
+func LayerMetadata(history History, size int64) (string, error) {
+	// the history is kept as it is, including what isn't understood here
+	var v1 map[string]*json.RawMessage
+	if err := json.Unmarshal([]byte(history.V1Compatibility), &v1); err != nil {
+		return "", err
+	}
+
+	s := json.RawMessage(strconv.FormatInt(size, 10))
+	v1["Size"] = &s
+
+	meta, err := json.Marshal(v1)
+	if err != nil {
+		return "", err
+	}
+
+	return string(meta), nil
+}
+
 // FetchImageManifest fetches the image manifest file
 func FetchImageManifest(options ImageCOptions) (*Manifest, error) {
 	defer trace.End(trace.Begin(options.image + "/" + options.digest))
@@ -242,6 +290,8 @@ func FetchImageManifest(options ImageCOptions) (*Manifest, error) {
 		return nil, fmt.Errorf("tag doesn't match what was requested, expected: %s, downloaded: %s", options.digest, manifest.Tag)
 	}
 
+	manifest.Digest = ManifestDigest(blob)
+
 	destination := DestinationDirectory()
 	err = os.MkdirAll(destination, 0755)
 	if err != nil {
@@ -251,3 +301,15 @@ func FetchImageManifest(options ImageCOptions) (*Manifest, error) {
 
 	return manifest, nil
 }
+
+// ManifestDigest returns the digest of the manifest.  The digest of a signed
+// manifest covers its payload alone, as the signatures differ between pulls.
+func ManifestDigest(blob []byte) string {
+	if js, err := libtrust.ParsePrettySignature(blob, "signatures"); err == nil {
+		if payload, err := js.Payload(); err == nil {
+			blob = payload
+		}
+	}
+
+	return digest.FromBytes(blob).String()
+}
@@ -51,6 +51,7 @@ type ImageCOptions struct {
 	reference string
 
 	registry string
+	name     string
 	image    string
 	digest   string
 
@@ -79,6 +80,9 @@ type ImageWithMeta struct {
 
 	layer   FSLayer
 	history History
+
+	// size is the size of the layer once uncompressed, counted as it's fetched
+	size int64
 }
 
 func (i *ImageWithMeta) String() string {
@@ -103,6 +107,10 @@ const (
 
 	// DefaultTokenExpirationDuration specifies the default token expiration
 	DefaultTokenExpirationDuration = 60 * time.Second
+
+	// MetadataKey is the key the history of a layer is saved under with the
+	// layer in the image store
+	MetadataKey = "v1Compatibility"
 )
 
 func init() {
@@ -156,6 +164,7 @@ func ParseReference() error {
 		options.registry = ref.Hostname()
 	}
 
+	options.name = ref.Name()
 	options.image = ref.RemoteName()
 
 	return nil
@@ -299,6 +308,12 @@ func main() {
 		log.Debugf("Manifest image: %#v", images[i])
 	}
 
+	// the top layer is what the reference refers to, whether or not it has to be pulled
+	var topLayerID string
+	if len(images) > 0 {
+		topLayerID = images[0].ID
+	}
+
 	var existingImages map[string]*models.Image
 
 	if !options.standalone {
@@ -341,16 +356,16 @@ func main() {
 	// on top of previous one
 	results := make(chan error, len(images))
 	for i := len(images) - 1; i >= 0; i-- {
-		go func(image ImageWithMeta) {
+		go func(image *ImageWithMeta) {
 			defer wg.Done()
 
-			err := FetchImageBlob(options, &image)
+			err := FetchImageBlob(options, image)
 			if err != nil {
 				results <- fmt.Errorf("%s/%s returned %s", options.image, image.layer.BlobSum, err)
 			} else {
 				results <- nil
 			}
-		}(images[i])
+		}(&images[i])
 	}
 	wg.Wait()
 	close(results)
@@ -392,9 +407,14 @@ func main() {
 			)
 			defer in.Close()
 
+			// the history of the layer is saved with it, along with its size
+			meta, err := LayerMetadata(image.history, image.size)
+			if err != nil {
+				log.Fatalf("Failed to encode image history: %s", err)
+			}
+
 			// Write the image
-			// FIXME: send metadata when portlayer supports it
-			err = WriteImage(&image, in)
+			err = WriteImage(&image, meta, in)
 			if err != nil {
 				log.Fatalf("Failed to write to image store: %s", err)
 			}
@@ -403,9 +423,20 @@ func main() {
 		if err := os.RemoveAll(destination); err != nil {
 			log.Fatalf("Failed to remove download directory: %s", err)
 		}
+
+		// Make the reference refer to the image that was pulled
+		ref := &models.ImageReference{
+			Repository: options.name,
+			Tag:        &options.digest,
+			Digest:     &manifest.Digest,
+			ImageID:    topLayerID,
+		}
+		if err := AddReference(hostname, ref); err != nil {
+			log.Fatalf("Failed to tag the image: %s", err)
+		}
 	}
-	// FIXME: Dump the digest
-	//progress.Message(po, "", "Digest: 0xDEAD:BEEF")
+
+	progress.Message(po, "", "Digest: "+manifest.Digest)
 
 	if len(images) > 0 {
 		progress.Message(po, "", "Status: Downloaded newer image for "+options.image+":"+options.digest)
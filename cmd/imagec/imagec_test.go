@@ -24,6 +24,9 @@ import (
 	"path"
 	"testing"
 
+	"github.com/docker/distribution/digest"
+	"github.com/docker/libtrust"
+
 	"github.com/vmware/vic/apiservers/portlayer/models"
 )
 
@@ -192,6 +195,11 @@ func TestFetchImageBlob(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
+	// the layer isn't compressed, so it's the same size uncompressed
+	if image.size != int64(len(LayerContent)) {
+		t.Errorf("Returned size %d is different than expected %d", image.size, len(LayerContent))
+	}
+
 	hist, err := ioutil.ReadFile(path.Join(DestinationDirectory(), LayerID, LayerID+".json"))
 	if err != nil {
 		t.Errorf(err.Error())
@@ -201,3 +209,64 @@ func TestFetchImageBlob(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 }
+
+func TestManifestDigest(t *testing.T) {
+	manifest := &Manifest{
+		Name:     Image,
+		Tag:      Tag,
+		FSLayers: []FSLayer{FSLayer{BlobSum: DigestSHA256EmptyTar}},
+	}
+
+	payload, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := digest.FromBytes(payload).String()
+
+	// unsigned manifests are digested whole
+	if d := ManifestDigest(payload); d != expected {
+		t.Errorf("Returned digest %s is different than expected %s", d, expected)
+	}
+
+	// signed manifests are digested without their signatures
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := libtrust.NewJSONSignature(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = js.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := js.PrettySignature("signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := ManifestDigest(signed); d != expected {
+		t.Errorf("Returned digest %s is different than expected %s", d, expected)
+	}
+}
+
+func TestLayerMetadata(t *testing.T) {
+	meta, err := LayerMetadata(History{V1Compatibility: LayerHistory}, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v1 map[string]interface{}
+	if err = json.Unmarshal([]byte(meta), &v1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the history is kept with the size added to it
+	if v1["id"] != LayerID || v1["Size"] != float64(1024) {
+		t.Errorf("Returned metadata %s is missing the history or size", meta)
+	}
+
+	if _, err = LayerMetadata(History{V1Compatibility: "not json"}, 0); err == nil {
+		t.Errorf("Expected an error for malformed history")
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 
 	log "github.com/Sirupsen/logrus"
@@ -99,10 +100,17 @@ func ListImages(storename string, images []ImageWithMeta) (map[string]*models.Im
 	return existingImages, nil
 }
 
-// WriteImage writes the image to given image store
-func WriteImage(image *ImageWithMeta, data io.ReadCloser) error {
+// WriteImage writes the image to given image store, with the metadata saved
+// alongside it
+func WriteImage(image *ImageWithMeta, meta string, data io.ReadCloser) error {
 	defer trace.End(trace.Begin(image.ID))
 
+	blob, err := json.Marshal(map[string]string{MetadataKey: meta})
+	if err != nil {
+		return err
+	}
+	header := string(blob)
+
 	transport := httptransport.New(options.host, "/", []string{"http"})
 	client := apiclient.New(transport, nil)
 
@@ -117,7 +125,8 @@ func WriteImage(image *ImageWithMeta, data io.ReadCloser) error {
 			WithParentID(*image.Parent).
 			WithStoreName(image.Store).
 			WithImageFile(data).
-			WithSum(image.layer.BlobSum),
+			WithSum(image.layer.BlobSum).
+			WithImageMetadata(&header),
 	)
 	if err != nil {
 		log.Debugf("Creating an image failed: %s", err)
@@ -128,3 +137,22 @@ func WriteImage(image *ImageWithMeta, data io.ReadCloser) error {
 	return nil
 
 }
+
+// AddReference makes the reference refer to its image in the given image store
+func AddReference(storename string, ref *models.ImageReference) error {
+	defer trace.End(trace.Begin(storename))
+
+	transport := httptransport.New(options.host, "/", []string{"http"})
+	client := apiclient.New(transport, nil)
+
+	_, err := client.Storage.AddReference(
+		storage.NewAddReferenceParams().WithStoreName(storename).WithReference(ref),
+	)
+	if err != nil {
+		log.Debugf("Adding a reference failed: %s", err)
+		return err
+	}
+	log.Debugf("Added a reference %#v", ref)
+
+	return nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/cmd/tether/msgs"
+	"github.com/vmware/vic/metadata"
+	"golang.org/x/crypto/ssh"
+)
+
+// backchannelRetry is the delay between attempts to re-establish the backchannel
+var backchannelRetry = 5 * time.Second
+
+// exitReportTimeout bounds how long the tether waits for the port layer to record a session exit,
+// including waiting for the backchannel to be established
+var exitReportTimeout = 10 * time.Second
+
+// attachWaitTimeout bounds how long a session that expects a client to attach is held before
+// it's launched, and how long an attach waits for a session that has yet to be launched
+var attachWaitTimeout = 10 * time.Second
+
+// hostKey is used when the executor config doesn't supply a key
+var hostKey ssh.Signer
+
+// the current ssh connection to the port layer, if any
+var (
+	backchannelConn  ssh.Conn
+	backchannelMutex sync.Mutex
+)
+
+// serveBackchannel runs the ssh server on the backchannel for the life of the tether.
+// If the connection is dropped, e.g. because the port layer was restarted, the handshake
+// is repeated and a new server started.
+func serveBackchannel() {
+	for {
+		conn, err := backchannel()
+		if err != nil {
+			log.Errorf("failed to open backchannel: %s", err)
+			time.Sleep(backchannelRetry)
+			continue
+		}
+
+		if err = serve(conn); err != nil {
+			log.Errorf("ssh server on backchannel exited: %s", err)
+		}
+
+		conn.Close()
+	}
+}
+
+// signer returns the host key for the ssh server
+func signer() (ssh.Signer, error) {
+	if config := currentConfig(); config != nil && len(config.Key) > 0 {
+		return ssh.ParsePrivateKey(config.Key)
+	}
+
+	if hostKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		hostKey, err = ssh.NewSignerFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return hostKey, nil
+}
+
+// serve runs an ssh server over the connection until the client goes away
+func serve(conn net.Conn) error {
+	key, err := signer()
+	if err != nil {
+		detail := fmt.Sprintf("failed to obtain host key: %s", err)
+		log.Error(detail)
+		return errors.New(detail)
+	}
+
+	config := &ssh.ServerConfig{
+		NoClientAuth: true,
+	}
+	config.AddHostKey(key)
+
+	log.Info("Starting ssh server on backchannel")
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		detail := fmt.Sprintf("failed to establish ssh connection: %s", err)
+		log.Error(detail)
+		return errors.New(detail)
+	}
+	defer sconn.Close()
+
+	backchannelMutex.Lock()
+	backchannelConn = sconn
+	backchannelMutex.Unlock()
+
+	defer func() {
+		backchannelMutex.Lock()
+		backchannelConn = nil
+		backchannelMutex.Unlock()
+	}()
+
+	go func() {
+		for ch := range chans {
+			switch ch.ChannelType() {
+			case msgs.AttachChannel:
+				go handleAttach(ch)
+			case msgs.ScpChannel:
+				go handleScp(ch)
+			default:
+				log.Warnf("Rejecting unsupported channel type %s", ch.ChannelType())
+				ch.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			}
+		}
+	}()
+
+	for req := range reqs {
+		var ok bool
+		var payload []byte
+
+		switch req.Type {
+		case msgs.ContainersReq:
+			ok = true
+			payload = msgs.Marshal(&msgs.ContainersMsg{IDs: []string{currentConfig().ID}})
+		case msgs.SignalReq:
+			ok = handleSignal(req.Payload)
+		case msgs.ReloadReq:
+			ok = handleReload(req.Payload)
+		case msgs.StatReq:
+			ok, payload = handleStat(req.Payload)
+		case msgs.TopReq:
+			ok, payload = handleTop(req.Payload)
+		case msgs.FreezeReq:
+			ok, payload = handleFreeze(true)
+		case msgs.ThawReq:
+			ok, payload = handleFreeze(false)
+		default:
+			log.Warnf("Ignoring unsupported global request %s", req.Type)
+		}
+
+		if req.WantReply {
+			req.Reply(ok, payload)
+		}
+	}
+
+	return nil
+}
+
+// handleSignal decodes a signal request and delivers the signal to the named session
+func handleSignal(payload []byte) bool {
+	msg := &msgs.SignalMsg{}
+	if err := msgs.Unmarshal(payload, msg); err != nil {
+		log.Errorf("failed to unmarshal signal request: %s", err)
+		return false
+	}
+
+	if err := SignalSession(msg.ID, syscall.Signal(msg.Signal)); err != nil {
+		log.Errorf("failed to signal session %s: %s", msg.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// handleReload decodes a reload request and passes the config to the main loop
+func handleReload(payload []byte) bool {
+	msg := &msgs.ReloadMsg{}
+	if err := msgs.Unmarshal(payload, msg); err != nil {
+		log.Errorf("failed to unmarshal reload request: %s", err)
+		return false
+	}
+
+	if !requestReload(msg.Config) {
+		log.Warn("Ignoring reload request as the executor is exiting")
+		return false
+	}
+
+	return true
+}
+
+// handleAttach connects an attach channel to the streams of the session it names. If the
+// session has yet to be launched, or even loaded as with a new exec session, the attach
+// waits for it.
+func handleAttach(nch ssh.NewChannel) {
+	msg := &msgs.AttachMsg{}
+	if err := msgs.Unmarshal(nch.ExtraData(), msg); err != nil {
+		log.Errorf("failed to unmarshal attach request: %s", err)
+		nch.Reject(ssh.Prohibited, "malformed attach request")
+		return
+	}
+
+	s := lookupStreams(msg.ID)
+	for deadline := time.Now().Add(attachWaitTimeout); s == nil && time.Now().Before(deadline); {
+		if _, finished, ok := sessionExit(msg.ID); ok && finished != 0 {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		s = lookupStreams(msg.ID)
+	}
+
+	if s == nil {
+		log.Warnf("Rejecting attach to session %s as it is not running", msg.ID)
+		nch.Reject(ssh.ConnectionFailed, fmt.Sprintf("session %s is not running", msg.ID))
+		return
+	}
+
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		log.Errorf("failed to accept attach to session %s: %s", msg.ID, err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	log.Infof("Client attached to session %s", msg.ID)
+	s.attach(ch)
+}
+
+// reportExit sends the exit status of the session to the port layer so that it can be persisted
+// before the executor shuts down. This blocks until the port layer acknowledges it or the timeout
+// expires.
+func reportExit(session *metadata.SessionConfig) {
+	msg := &msgs.ExitMsg{
+		ID:         session.ID,
+		ExitStatus: uint32(session.ExitStatus),
+		Finished:   uint64(session.Finished),
+	}
+
+	deadline := time.Now().Add(exitReportTimeout)
+	for {
+		backchannelMutex.Lock()
+		conn := backchannelConn
+		backchannelMutex.Unlock()
+
+		if conn != nil {
+			result := make(chan error, 1)
+			go func() {
+				ok, _, err := conn.SendRequest(msgs.ExitReq, true, msgs.Marshal(msg))
+				if err == nil && !ok {
+					err = errors.New("request rejected")
+				}
+				result <- err
+			}()
+
+			select {
+			case err := <-result:
+				if err != nil {
+					log.Errorf("failed to report exit of session %s: %s", session.ID, err)
+				}
+			case <-time.After(deadline.Sub(time.Now())):
+				log.Errorf("timed out reporting exit of session %s", session.ID)
+			}
+			return
+		}
+
+		if time.Now().After(deadline) {
+			log.Warnf("no backchannel to report exit of session %s", session.ID)
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/cmd/tether/msgs"
+	"github.com/vmware/vic/cmd/tether/scp"
+	"golang.org/x/crypto/ssh"
+)
+
+// handleStat decodes a stat request and describes the path it names. Symlinks are described
+// rather than followed.
+func handleStat(payload []byte) (bool, []byte) {
+	msg := &msgs.StatMsg{}
+	if err := msgs.Unmarshal(payload, msg); err != nil {
+		log.Errorf("failed to unmarshal stat request: %s", err)
+		return false, nil
+	}
+
+	result := &msgs.StatResultMsg{}
+	info, err := os.Lstat(msg.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			result.NotFound = true
+			return true, msgs.Marshal(result)
+		}
+
+		log.Errorf("failed to stat %s: %s", msg.Path, err)
+		return false, []byte(err.Error())
+	}
+
+	result.Name = info.Name()
+	result.Size = uint64(info.Size())
+	result.Mode = uint32(info.Mode())
+	result.Mtime = uint64(info.ModTime().UnixNano())
+	if info.Mode()&os.ModeSymlink != 0 {
+		if result.LinkTarget, err = os.Readlink(msg.Path); err != nil {
+			log.Warnf("failed to read link %s: %s", msg.Path, err)
+		}
+	}
+
+	return true, msgs.Marshal(result)
+}
+
+// handleScp serves a copy to or from the container over the channel using the scp source and
+// destination implementations
+func handleScp(nch ssh.NewChannel) {
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		log.Errorf("failed to accept scp channel: %s", err)
+		return
+	}
+
+	scpReq := &scp.ScpRequest{}
+	scpReq.SetChannel(&ch)
+
+	for req := range reqs {
+		var ok bool
+		var payload []byte
+
+		path := string(req.Payload)
+		switch req.Type {
+		case msgs.ScpSourceReq:
+			log.Infof("Copying %s from the container", path)
+			ok, payload = scpReq.Source(path)
+		case msgs.ScpDestReq, msgs.ScpDestReplaceReq:
+			log.Infof("Copying %s into the container", path)
+			ok, payload = scpReq.Destination(path, req.Type == msgs.ScpDestReplaceReq)
+		default:
+			log.Warnf("Ignoring unsupported scp request %s", req.Type)
+		}
+
+		if req.WantReply {
+			req.Reply(ok, payload)
+		}
+
+		if !ok {
+			ch.Close()
+			continue
+		}
+
+		// the copy can only begin once the reply has been sent
+		if work := scpReq.GetPendingWork(); work != nil {
+			go work()
+			scpReq.ClearPendingWork()
+		}
+	}
+}
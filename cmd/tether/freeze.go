@@ -0,0 +1,110 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// freezeAttempts bounds the passes made over the processes when freezing, as they can fork
+// between being listed and being stopped. Passes are spaced by freezeInterval to give the
+// signalled processes time to stop.
+const (
+	freezeAttempts = 10
+	freezeInterval = 100 * time.Millisecond
+)
+
+// handleFreeze stops or continues all of the processes in the container other than the tether
+func handleFreeze(freeze bool) (bool, []byte) {
+	var err error
+	if freeze {
+		err = freezeProcesses(os.Getpid())
+	} else {
+		err = thawProcesses(os.Getpid())
+	}
+
+	if err != nil {
+		log.Errorf("failed to freeze(%t) processes: %s", freeze, err)
+		return false, []byte(err.Error())
+	}
+
+	return true, nil
+}
+
+// frozen reports whether the process is stopped or has exited, in which case it has no need of
+// stopping
+func frozen(p *process) bool {
+	switch p.state {
+	case "T", "t", "Z", "X":
+		return true
+	default:
+		return false
+	}
+}
+
+// freezeProcesses sends SIGSTOP to the processes in procRoot other than self until none are left
+// running. Stopped children are not reported to the reaper as it doesn't ask for them.
+func freezeProcesses(self int) error {
+	for i := 0; i < freezeAttempts; i++ {
+		procs, err := listProcesses(self)
+		if err != nil {
+			return err
+		}
+
+		running := 0
+		for _, p := range procs {
+			if frozen(p) {
+				continue
+			}
+
+			running++
+			if err = syscall.Kill(p.pid, syscall.SIGSTOP); err != nil && err != syscall.ESRCH {
+				return fmt.Errorf("failed to stop process %d: %s", p.pid, err)
+			}
+		}
+
+		// a process may not have been marked as stopped yet, so the pass that finds nothing
+		// running is the one that ends
+		if running == 0 {
+			return nil
+		}
+
+		time.Sleep(freezeInterval)
+	}
+
+	return fmt.Errorf("processes still running after %d attempts to stop them", freezeAttempts)
+}
+
+// thawProcesses sends SIGCONT to the processes in procRoot other than self. This includes any
+// that were stopped before the freeze, as there's no telling them apart.
+func thawProcesses(self int) error {
+	procs, err := listProcesses(self)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		if err = syscall.Kill(p.pid, syscall.SIGCONT); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to continue process %d: %s", p.pid, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+// processState polls the state of the process until it matches one of states or a second passes
+func processState(t *testing.T, pid int, states ...string) string {
+	var p *process
+	var err error
+	for i := 0; i < 100; i++ {
+		if p, err = readProcess(pid); err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range states {
+			if p.state == state {
+				return p.state
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p.state
+}
+
+func TestFreeze(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc to find processes in")
+	}
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// expose only the child so nothing else is touched
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	pid := cmd.Process.Pid
+	if err = os.Symlink(fmt.Sprintf("/proc/%d", pid), path.Join(root, fmt.Sprint(pid))); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(proc string) { procRoot = proc }(procRoot)
+	procRoot = root
+
+	if err = freezeProcesses(os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if state := processState(t, pid, "T"); state != "T" {
+		t.Errorf("Expected process to be stopped, state is %s", state)
+	}
+
+	if err = thawProcesses(os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if state := processState(t, pid, "S", "R"); state == "T" {
+		t.Errorf("Expected process to be continued, state is %s", state)
+	}
+}
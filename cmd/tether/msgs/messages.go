@@ -0,0 +1,130 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package msgs defines the requests and channels exchanged between the tether and the
+// port layer over the ssh connection carried by the container backchannel.
+package msgs
+
+import "golang.org/x/crypto/ssh"
+
+const (
+	// ContainersReq asks the tether for the ID of the executor it is running
+	ContainersReq = "container-ids"
+
+	// SignalReq asks the tether to deliver a signal to the process of a session
+	SignalReq = "signal"
+
+	// ExitReq is sent by the tether to report that the process of a session has exited
+	ExitReq = "session-exit"
+
+	// ReloadReq asks the tether to reload its config and launch any new sessions
+	ReloadReq = "reload"
+
+	// StatReq asks the tether to describe a path in the container filesystem
+	StatReq = "stat"
+
+	// TopReq asks the tether for a ps style table of the processes in the container
+	TopReq = "top"
+
+	// FreezeReq asks the tether to stop all of the processes in the container, and ThawReq to
+	// continue them. Neither has a payload.
+	FreezeReq = "freeze"
+	ThawReq   = "thaw"
+
+	// AttachChannel is the type of channel opened by the port layer to attach to the streams
+	// of a session. Session output is written to the channel, with stderr as extended data if
+	// the session has no tty, and data read from the channel is passed to the session stdin.
+	AttachChannel = "attach"
+
+	// ScpChannel is the type of channel opened by the port layer to copy a file to or from the
+	// container. A ScpSourceReq or ScpDestReq naming the path is sent on the channel, followed by
+	// the file in scp form. The tether sends an exit-status request once the copy is complete.
+	ScpChannel = "scp"
+
+	// ScpSourceReq asks the tether to copy the file at the path in the payload to the channel
+	ScpSourceReq = "source"
+
+	// ScpDestReq asks the tether to write the file copied to the channel to the path in the payload
+	ScpDestReq = "dest"
+
+	// ScpDestReplaceReq is a ScpDestReq that lets a directory copied to the path of a file replace
+	// it, and a file replace a directory
+	ScpDestReplaceReq = "dest-replace"
+)
+
+// ContainersMsg is the reply to a ContainersReq
+type ContainersMsg struct {
+	IDs []string
+}
+
+// SignalMsg names the session and the signal to deliver to its process
+type SignalMsg struct {
+	ID     string
+	Signal uint32
+}
+
+// ExitMsg reports the exit status of a session and when it exited, in seconds since the epoch
+type ExitMsg struct {
+	ID         string
+	ExitStatus uint32
+	Finished   uint64
+}
+
+// ReloadMsg carries the encoded executor config to reload with, as the tether cannot read
+// the updated config from guestinfo itself
+type ReloadMsg struct {
+	Config string
+}
+
+// AttachMsg is the extra data of an AttachChannel and names the session to attach to
+type AttachMsg struct {
+	ID string
+}
+
+// StatMsg names a path in the container filesystem to describe
+type StatMsg struct {
+	Path string
+}
+
+// StatResultMsg is the reply to a StatReq. Mode holds the bits of an os.FileMode and Mtime is in
+// nanoseconds since the epoch. Only NotFound is set if the path does not exist.
+type StatResultMsg struct {
+	NotFound   bool
+	Name       string
+	Size       uint64
+	Mode       uint32
+	Mtime      uint64
+	LinkTarget string
+}
+
+// TopMsg carries the ps arguments selecting the format of the process table
+type TopMsg struct {
+	Args string
+}
+
+// TopResultMsg is the reply to a TopReq. Output is the table as ps would print it, with a line of
+// column titles followed by a line per process. Only the last column may contain spaces.
+type TopResultMsg struct {
+	Output string
+}
+
+// Marshal encodes a message in ssh wire format so it can be used as a request payload
+func Marshal(msg interface{}) []byte {
+	return ssh.Marshal(msg)
+}
+
+// Unmarshal decodes a request payload into the supplied message
+func Unmarshal(payload []byte, msg interface{}) error {
+	return ssh.Unmarshal(payload, msg)
+}
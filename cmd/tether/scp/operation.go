@@ -22,7 +22,9 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // the following code is a modified version of https://github.com/gnicod/goscplib
@@ -138,7 +140,8 @@ func (s *Operation) stat() error {
 }
 
 // Write will unmarshal an scp header, open the dirent for writing, write the
-// file contents, and return the relevant Operation
+// file contents, and return the relevant Operation. Closing the reader is left
+// to the caller.
 func Write(r io.ReadCloser, path string) (*Operation, error) {
 	rdr := bufio.NewReader(r)
 	header, err := rdr.ReadString('\n')
@@ -158,14 +161,14 @@ func Write(r io.ReadCloser, path string) (*Operation, error) {
 
 	n, err := rdr.WriteTo(s.File)
 	if err != nil {
+		s.File.Close()
 		return nil, err
 	}
 
-	r.Close()
-
 	// it's unclear why we have to do this since we pass the mode bits in
 	// openfile, but setting a file to 777 yields 775 without it.
-	if err := os.Chmod(s.Name, s.Mode); err != nil {
+	if err := os.Chmod(s.File.Name(), s.Mode); err != nil {
+		s.File.Close()
 		return nil, err
 	}
 
@@ -223,3 +226,154 @@ func (s *Operation) Close() error {
 
 	return nil
 }
+
+// Send writes the scp records for the regular file or directory at the path to w, recursing into
+// directories. scp has no records for anything else, so other files found in a directory are left
+// out. It returns the number of bytes of file content written.
+func Send(w io.Writer, path string) (int64, error) {
+	op, err := OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer op.File.Close()
+
+	if op.Mode.IsRegular() {
+		return op.Read(w)
+	}
+	if !op.Mode.IsDir() {
+		return 0, fmt.Errorf("%s is not a regular file or directory", path)
+	}
+
+	if _, err = io.WriteString(w, op.String()); err != nil {
+		return 0, err
+	}
+
+	names, err := op.File.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	var total int64
+	for _, name := range names {
+		child := filepath.Join(path, name)
+
+		info, err := os.Lstat(child)
+		if err != nil {
+			return total, err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			log.Printf("Skipping %s, only regular files and directories can be copied", child)
+			continue
+		}
+
+		n, err := Send(w, child)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	_, err = io.WriteString(w, string(END_FOLDER)+"\n")
+	return total, err
+}
+
+// Receive writes the regular files and directories described by the scp records read from r. The
+// first record is written at the path, and the records within a directory are written into it by
+// name. A directory record for an existing directory copies into it. A directory copied to the
+// path of an existing file replaces it, as does a file copied to the path of an existing
+// directory, if replace is set, and fails the copy if not. The paths written are returned, which
+// shows how far the copy got if it failed.
+func Receive(r io.Reader, path string, replace bool) ([]string, error) {
+	rdr := bufio.NewReader(r)
+
+	var written []string
+	var dirs []string
+	for {
+		header, err := rdr.ReadString('\n')
+		if err == io.EOF && header == "" {
+			if len(dirs) > 0 {
+				return written, fmt.Errorf("copy ended within %s", dirs[len(dirs)-1])
+			}
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		op, err := Unmarshal(header)
+		if err != nil {
+			return written, err
+		}
+
+		switch opType(header[0:1]) {
+		case BEGIN_FILE, BEGIN_FOLDER:
+		case END_FOLDER:
+			if len(dirs) == 0 {
+				return written, fmt.Errorf("end of folder outside of a folder")
+			}
+			dirs = dirs[:len(dirs)-1]
+			continue
+		default:
+			// times and the like aren't kept
+			continue
+		}
+
+		target := path
+		if len(dirs) > 0 {
+			if op.Name == "" || op.Name == "." || op.Name == ".." || strings.ContainsRune(op.Name, '/') {
+				return written, fmt.Errorf("invalid name %q in %s", op.Name, dirs[len(dirs)-1])
+			}
+			target = filepath.Join(dirs[len(dirs)-1], op.Name)
+		} else if len(written) > 0 {
+			return written, fmt.Errorf("more than one file or folder copied to %s", path)
+		}
+
+		existing, err := os.Stat(target)
+		if err == nil && existing.IsDir() != op.Mode.IsDir() {
+			if !replace {
+				if existing.IsDir() {
+					return written, fmt.Errorf("cannot overwrite directory %s with non-directory", target)
+				}
+				return written, fmt.Errorf("cannot overwrite non-directory %s with directory", target)
+			}
+			if err = os.RemoveAll(target); err != nil {
+				return written, err
+			}
+			existing = nil
+		}
+
+		if op.Mode.IsDir() {
+			if existing == nil {
+				if err = os.Mkdir(target, op.Mode.Perm()); err != nil {
+					return written, err
+				}
+			}
+			if err = os.Chmod(target, op.Mode.Perm()); err != nil {
+				return written, err
+			}
+
+			written = append(written, target)
+			dirs = append(dirs, target)
+			continue
+		}
+
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, op.Mode.Perm())
+		if err != nil {
+			return written, err
+		}
+		_, err = io.CopyN(f, rdr, op.Size)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			// the mode given when creating the file is subject to the umask
+			err = os.Chmod(target, op.Mode.Perm())
+		}
+		if err != nil {
+			return written, err
+		}
+
+		written = append(written, target)
+	}
+}
@@ -15,6 +15,7 @@
 package scp
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -222,3 +223,107 @@ func TestDirCreationFromHeader(t *testing.T) {
 		return
 	}
 }
+
+func TestSendReceiveDir(t *testing.T) {
+	src, err := ioutil.TempDir("", "scpsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	if err = os.Mkdir(filepath.Join(src, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a":     "hello",
+		"sub/b": "",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// scp has no record for symlinks, so they're left out
+	if err = os.Symlink("a", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{src, filepath.Join(src, "sub")} {
+		if err = os.Chmod(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var records bytes.Buffer
+	if _, err = Send(&records, src); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf("D0750 0 %s\nC0640 5 a\nhelloD0750 0 sub\nC0640 0 b\nE\nE\n", filepath.Base(src))
+	if records.String() != expected {
+		t.Fatalf("Expected records %q, got %q", expected, records.String())
+	}
+
+	// the records copy into an existing directory
+	dst, err := ioutil.TempDir("", "scpdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	written, err := Receive(&records, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 4 {
+		t.Errorf("Expected 4 paths to be written, got %v", written)
+	}
+
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, data)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dst, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Errorf("Unexpected mode of copied directory: %s", info.Mode())
+	}
+
+	// names can't escape the directory they're copied into
+	if _, err = Receive(strings.NewReader("D0755 0 x\nC0644 0 ..\nE\n"), dst, false); err == nil {
+		t.Error("Expected an error for a name outside the directory")
+	}
+
+	// nor can a copy end part way through a directory
+	written, err = Receive(strings.NewReader("D0755 0 x\nC0644 2 c\nhi"), dst, false)
+	if err == nil {
+		t.Error("Expected an error for a copy that ended within a directory")
+	}
+	if len(written) != 2 {
+		t.Errorf("Expected the files written before the copy ended, got %v", written)
+	}
+
+	// directories and files only replace each other if asked to
+	for _, records := range []string{"D0755 0 x\nD0750 0 a\nE\nE\n", "D0755 0 x\nC0644 2 sub\nhiE\n"} {
+		if _, err = Receive(strings.NewReader(records), dst, false); err == nil {
+			t.Errorf("Expected an error replacing a file with a directory or the reverse: %q", records)
+		}
+		if _, err = Receive(strings.NewReader(records), dst, true); err != nil {
+			t.Errorf("Expected replacing a file with a directory or the reverse to succeed: %s", err)
+		}
+	}
+
+	if info, err = os.Stat(filepath.Join(dst, "a")); err != nil || !info.IsDir() {
+		t.Errorf("Expected a to have been replaced by a directory: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "sub")); err != nil || string(data) != "hi" {
+		t.Errorf("Expected sub to have been replaced by a file: %q, %v", data, err)
+	}
+}
@@ -15,13 +15,22 @@
 package scp
 
 import (
-	"io"
+	"fmt"
 	"log"
 	"os"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// ExitStatusReq is sent on the channel once a copy is complete, as with an ssh exec, so that the
+// client can tell a completed copy from one that failed part way
+const ExitStatusReq = "exit-status"
+
+// ExitStatusMsg is the payload of an ExitStatusReq, zero if the copy succeeded
+type ExitStatusMsg struct {
+	Status uint32
+}
+
 type ScpRequest struct {
 	ch        ssh.Channel
 	pendingFn func()
@@ -39,52 +48,64 @@ type ScpRequest struct {
 // debug1: Sending command: scp -v -t /tmp/f
 // Sink: C0664 968 f
 
-// For copying from a host.  This acts as the server (source).
+// For copying from a host.  This acts as the server (source).  Directories are
+// copied with their content.
 func (scp *ScpRequest) Source(path string) (ok bool, payload []byte) {
 
-	op, err := OpenFile(path, os.O_RDONLY, 0)
+	info, err := os.Stat(path)
 	if err != nil {
 		return false, []byte(err.Error())
 	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return false, []byte(fmt.Sprintf("%s is not a regular file or directory", path))
+	}
+
 	f := func() {
-		defer op.Close()
 		defer scp.ch.Close()
 
-		n, err := op.Read(scp.ch)
+		n, err := Send(scp.ch, path)
 		if err != nil {
 			log.Printf("Source: error reading %s", err)
+			scp.exitStatus(1)
 			return
 		}
 
-		log.Printf("Source: copied %s (%d bytes) to client", op.File.Name(), n)
+		scp.exitStatus(0)
+		log.Printf("Source: copied %s (%d bytes) to client", path, n)
 	}
 
 	scp.pendingFn = f
 	return true, nil
 }
 
-func (scp *ScpRequest) Destination(path string) (ok bool, payload []byte) {
+// For copying to a host.  This acts as the server (dest).  See Receive for replace.
+func (scp *ScpRequest) Destination(path string, replace bool) (ok bool, payload []byte) {
 	f := func() {
+		defer scp.ch.Close()
 
-		op, err := Write(scp.ch, path)
-		if err != nil && err != io.EOF {
-			log.Printf("Source: error writing %s", err)
-			return
-		}
-
-		if err = op.Close(); err != nil {
-			log.Printf("error closing %s", err)
+		written, err := Receive(scp.ch, path, replace)
+		if err != nil {
+			log.Printf("Destination: error writing %s after %d files: %s", path, len(written), err)
+			scp.exitStatus(1)
 			return
 		}
 
-		scp.ch.Close()
-		log.Printf("Destination: copied %s (%d bytes) from client", op.File.Name(), op.Size)
+		scp.exitStatus(0)
+		log.Printf("Destination: copied %s (%d files) from client", path, len(written))
 	}
 
 	scp.pendingFn = f
 	return true, nil
 }
 
+// exitStatus reports the outcome of the copy to the client. The client may already have gone
+// away, so errors are only logged.
+func (scp *ScpRequest) exitStatus(status uint32) {
+	if _, err := scp.ch.SendRequest(ExitStatusReq, false, ssh.Marshal(&ExitStatusMsg{Status: status})); err != nil {
+		log.Printf("error sending exit status %s", err)
+	}
+}
+
 func (scp *ScpRequest) SetChannel(channel *ssh.Channel) {
 	scp.ch = *channel
 }
@@ -94,4 +115,5 @@ func (scp *ScpRequest) GetPendingWork() func() {
 }
 
 func (scp *ScpRequest) ClearPendingWork() {
+	scp.pendingFn = nil
 }
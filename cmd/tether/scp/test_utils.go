@@ -95,7 +95,7 @@ type SessionHandler interface {
 
 	Source(dirent string) (ok bool, payload []byte)
 
-	Destination(dirent string) (ok bool, payload []byte)
+	Destination(dirent string, replace bool) (ok bool, payload []byte)
 
 	// Retrieve closure for any pending work - this is necessary as data cannot be returned
 	// via ssh before request replys are sent so exec, et al, must be async.
@@ -206,7 +206,7 @@ func handleChannel(newChannel ssh.NewChannel, sh SessionHandler) {
 		case SOURCE:
 			ok, payload = sh.Source(path)
 		case DEST:
-			ok, payload = sh.Destination(path)
+			ok, payload = sh.Destination(path, false)
 		default:
 			return
 		}
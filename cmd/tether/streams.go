@@ -0,0 +1,271 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/metadata"
+	"golang.org/x/crypto/ssh"
+)
+
+// outputFlushTimeout bounds how long session exit handling waits for the remaining output of a
+// session to be copied. Processes left running in the background may hold the output open.
+var outputFlushTimeout = 2 * time.Second
+
+// the streams of the running sessions, by session ID
+var (
+	sessionStreams      = make(map[string]*streams)
+	sessionStreamsMutex sync.Mutex
+)
+
+// fanout is a writer that copies to a changing set of writers. Writers that fail are dropped so
+// that a departed client doesn't stall the session.
+type fanout struct {
+	mutex   sync.Mutex
+	writers []io.Writer
+}
+
+func (f *fanout) add(w io.Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.writers = append(f.writers, w)
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	live := f.writers[:0]
+	for _, w := range f.writers {
+		if _, err := w.Write(p); err != nil {
+			log.Debugf("Dropping attached writer: %s", err)
+			continue
+		}
+		live = append(live, w)
+	}
+	f.writers = live
+
+	return len(p), nil
+}
+
+// streams carries the output of a session to the session log and attached clients, and input
+// from attached clients to the session
+type streams struct {
+	session *metadata.SessionConfig
+
+	stdout fanout
+	stderr fanout
+
+	// stdin is nil unless the session keeps stdin open
+	stdin io.WriteCloser
+
+	// done is closed once all of the output has been copied
+	done chan struct{}
+
+	// attachCh is closed when the first client attaches
+	attachCh chan struct{}
+
+	// started is closed once an attempt has been made to start the session
+	started chan struct{}
+
+	mutex    sync.Mutex
+	attached []ssh.Channel
+	closed   bool
+}
+
+// newStreams creates and registers the streams for a session so that clients can attach to them
+// before the session is launched
+func newStreams(session *metadata.SessionConfig) *streams {
+	s := &streams{
+		session:  session,
+		done:     make(chan struct{}),
+		attachCh: make(chan struct{}),
+		started:  make(chan struct{}),
+	}
+
+	sessionStreamsMutex.Lock()
+	sessionStreams[session.ID] = s
+	sessionStreamsMutex.Unlock()
+
+	return s
+}
+
+// waitForAttach blocks until a client has attached or the timeout expires
+func (s *streams) waitForAttach(timeout time.Duration) {
+	select {
+	case <-s.attachCh:
+	case <-time.After(timeout):
+		log.Warnf("No client attached to session %s, launching anyway", s.session.ID)
+	}
+}
+
+// start starts the command for the session with its output copied to the log writer and any
+// attached clients
+func (s *streams) start(cmd *exec.Cmd, logwriter io.Writer) error {
+	defer close(s.started)
+
+	stdout := io.MultiWriter(logwriter, &s.stdout)
+	stderr := io.MultiWriter(logwriter, &s.stderr)
+
+	var outputs []io.Reader
+	if s.session.Tty {
+		pty, err := startTty(cmd)
+		if err != nil {
+			return err
+		}
+
+		// the terminal is the session input whether or not the client supplies any
+		if s.session.OpenStdin {
+			s.stdin = pty
+		}
+		outputs = append(outputs, pty)
+	} else {
+		var err error
+		if s.session.OpenStdin {
+			if s.stdin, err = cmd.StdinPipe(); err != nil {
+				return err
+			}
+		}
+
+		// explicit pipes let us know when the output has been drained, which we can't get from
+		// exec as the reaper collects the process rather than cmd.Wait
+		outr, outw, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		errr, errw, err := os.Pipe()
+		if err != nil {
+			outr.Close()
+			outw.Close()
+			return err
+		}
+
+		cmd.Stdout = outw
+		cmd.Stderr = errw
+
+		err = cmd.Start()
+		outw.Close()
+		errw.Close()
+		if err != nil {
+			outr.Close()
+			errr.Close()
+			return err
+		}
+
+		outputs = append(outputs, outr, errr)
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range outputs {
+		w := stdout
+		if i > 0 {
+			w = stderr
+		}
+
+		wg.Add(1)
+		go func(w io.Writer, r io.Reader) {
+			defer wg.Done()
+			// a pty returns EIO once the session has exited
+			io.Copy(w, r)
+		}(w, r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(s.done)
+	}()
+
+	return nil
+}
+
+// lookupStreams returns the streams of a running session, or nil
+func lookupStreams(id string) *streams {
+	sessionStreamsMutex.Lock()
+	defer sessionStreamsMutex.Unlock()
+
+	return sessionStreams[id]
+}
+
+// attach connects the channel to the session streams until the session exits or the
+// client goes away
+func (s *streams) attach(ch ssh.Channel) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		ch.Close()
+		return
+	}
+	s.attached = append(s.attached, ch)
+	first := len(s.attached) == 1
+	s.stdout.add(ch)
+	if !s.session.Tty {
+		s.stderr.add(ch.Stderr())
+	}
+	s.mutex.Unlock()
+
+	if first {
+		close(s.attachCh)
+	}
+
+	go func() {
+		// clients may attach before the session is launched
+		<-s.started
+		if s.stdin == nil {
+			return
+		}
+
+		if _, err := io.Copy(s.stdin, ch); err != nil {
+			log.Debugf("Input from attached client to session %s ended: %s", s.session.ID, err)
+		}
+
+		// closing a tty would hang up the session, so EOF has to come from the terminal
+		if s.session.StdinOnce && !s.session.Tty {
+			s.stdin.Close()
+		}
+	}()
+}
+
+// flush waits for the remaining output to be copied to the log and any attached clients
+func (s *streams) flush() {
+	select {
+	case <-s.done:
+	case <-time.After(outputFlushTimeout):
+		log.Warnf("Timed out waiting for output of session %s", s.session.ID)
+	}
+}
+
+// unregister disconnects any attached clients and removes the streams from those available
+// for attach
+func (s *streams) unregister() {
+	sessionStreamsMutex.Lock()
+	delete(sessionStreams, s.session.ID)
+	sessionStreamsMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for _, ch := range s.attached {
+		ch.Close()
+	}
+	s.attached = nil
+}
@@ -18,10 +18,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/vmware/vic/metadata"
@@ -32,28 +36,108 @@ import (
 var pathPrefix string
 
 // the reload channel is used to block reloading of the config
-// there will only be something on this channel on two occasions:
+// there will only be something on this channel on three occasions:
 // 1. initial start
 // 2. post-vmfork
+// 3. a reload request from the port layer, e.g. to launch an exec session
 var reload chan bool
 
-// Config holds the main configuration for the executor
+// reloadMutex guards sending on and closing the reload channel, and the config for the next
+// reload if one was supplied with the request
+var (
+	reloadMutex  sync.Mutex
+	reloadClosed bool
+	reloadBlob   string
+)
+
+// defaultPath is searched for commands if the session environment doesn't specify a PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// launchFailureStatus is recorded as the exit status of a session that could not be launched
+const launchFailureStatus = 126
+
+// Config holds the main configuration for the executor. It's replaced on each reload while the
+// backchannel and the sessions are being served, so it's read through currentConfig.
 var Config *metadata.ExecutorConfig
 
-// Set of child PIDs created by us.
-var childPidTable = make(map[int]*metadata.Cmd)
+// configMutex guards Config and the exit status recorded in its sessions
+var configMutex sync.Mutex
+
+// currentConfig returns the config as last loaded, or nil if none has been
+func currentConfig() *metadata.ExecutorConfig {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	return Config
+}
+
+// setConfig replaces and logs the config, keeping the sessions of the current one that it also
+// holds along with their runtime state. It returns whether this is the first config loaded.
+func setConfig(config *metadata.ExecutorConfig) bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	initial := Config == nil
+	if !initial {
+		for id := range config.Sessions {
+			if existing, ok := Config.Sessions[id]; ok {
+				config.Sessions[id] = existing
+			}
+		}
+	}
+	Config = config
+
+	// logged while the exit status of the sessions can't change
+	logConfig(config)
+
+	return initial
+}
+
+// recordSessionExit records the exit status and finish time of the session
+func recordSessionExit(session *metadata.SessionConfig, status int) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	session.ExitStatus = status
+	session.Finished = time.Now().Unix()
+}
+
+// sessionExit returns the exit status and finish time of the session in the current config. The
+// finish time is zero if the session hasn't exited, and ok is false if there's no such session.
+func sessionExit(id string) (status int, finished int64, ok bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if Config == nil {
+		return 0, 0, false
+	}
+
+	session, ok := Config.Sessions[id]
+	if !ok {
+		return 0, 0, false
+	}
+
+	return session.ExitStatus, session.Finished, true
+}
+
+// Set of child PIDs created by us, mapped to the session they belong to.
+var childPidTable = make(map[int]*metadata.SessionConfig)
 
 // Exclusive access to childPidTable
 var childPidTableMutex = &sync.Mutex{}
 
+// The number of sessions held for a client to attach before they're launched. It's guarded by
+// childPidTableMutex so that a session leaves it as its pid is added to childPidTable.
+var heldLaunches int
+
 // RemoveChildPid is a synchronized accessor for the pid map the deletes the entry and returns the value
-func RemoveChildPid(pid int) (*metadata.Cmd, bool) {
+func RemoveChildPid(pid int) (*metadata.SessionConfig, bool) {
 	childPidTableMutex.Lock()
 	defer childPidTableMutex.Unlock()
 
-	cmd, ok := childPidTable[pid]
+	session, ok := childPidTable[pid]
 	delete(childPidTable, pid)
-	return cmd, ok
+	return session, ok
 }
 
 // LenChildPid returns the number of entries
@@ -64,8 +148,76 @@ func LenChildPid() int {
 	return len(childPidTable)
 }
 
+// liveSessions returns the number of sessions that are running or held waiting to be launched
+func liveSessions() int {
+	childPidTableMutex.Lock()
+	defer childPidTableMutex.Unlock()
+
+	return len(childPidTable) + heldLaunches
+}
+
+// SignalSession delivers the signal to the primary process of the specified session.
+// It returns an error if the session is not known or has no live process.
+func SignalSession(id string, sig syscall.Signal) error {
+	childPidTableMutex.Lock()
+	defer childPidTableMutex.Unlock()
+
+	for pid, session := range childPidTable {
+		if session.ID != id {
+			continue
+		}
+
+		log.Infof("Sending signal %d to session %s (pid %d)", sig, id, pid)
+		return session.Cmd.Cmd.Process.Signal(sig)
+	}
+
+	return fmt.Errorf("no running process for session %s", id)
+}
+
+// requestReload asks the main loop to reload the config, replacing the config blob if one is
+// supplied. It returns false if the executor is shutting down.
+func requestReload(configblob string) bool {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if reloadClosed {
+		return false
+	}
+
+	if configblob != "" {
+		reloadBlob = configblob
+	}
+
+	// there's nothing to do if a reload is already pending
+	select {
+	case reload <- true:
+	default:
+	}
+
+	return true
+}
+
+// stopReload lets the main loop exit
+func stopReload() {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if !reloadClosed {
+		reloadClosed = true
+		close(reload)
+	}
+}
+
 func run(loader metadata.ConfigLoader, configblob string) error {
+	reloadMutex.Lock()
 	reload = make(chan bool, 1)
+	reloadClosed = false
+	reloadBlob = ""
+	reloadMutex.Unlock()
+
+	configMutex.Lock()
+	Config = nil
+	configMutex.Unlock()
 
 	// HACK: workaround file descriptor conflict in pipe2 return from the exec.Command.Start
 	// it's not clear whether this is a cross platform issue, or still an issue as of this commit
@@ -82,67 +234,56 @@ func run(loader metadata.ConfigLoader, configblob string) error {
 
 	// initial setup, so seed this
 	reload <- true
+	serving := false
 	for _ = range reload {
-		// load the config - this modifies the structure values in place
-		Config, err := loader.LoadConfig(configblob)
+		reloadMutex.Lock()
+		if reloadBlob != "" {
+			configblob = reloadBlob
+			reloadBlob = ""
+		}
+		reloadMutex.Unlock()
+
+		config, err := loader.LoadConfig(configblob)
 		if err != nil {
 			detail := fmt.Sprintf("failed to load config: %s", err)
 			log.Error(detail)
 			return errors.New(detail)
 		}
 
-		logConfig(Config)
+		// keep the runtime state of sessions we already know about
+		initial := setConfig(config)
+
+		// launch the ssh server for interaction - this is how signals, attach and other control
+		// requests reach the sessions. It's started first so that clients waiting to attach can
+		// do so before the sessions are launched.
+		if !serving {
+			serving = true
+			go serveBackchannel()
+		}
 
 		// process the sessions and launch if needed
-		tty := false
-		for id, session := range Config.Sessions {
-			var proc *os.Process
+		for id, session := range config.Sessions {
+			// check if session has already been started, successfully or not
 			if session.Cmd.Cmd != nil {
-				proc = session.Cmd.Cmd.Process
-			}
-
-			if !tty && session.Tty {
-				tty = true
-			}
-
-			// check if session is alive and well
-			if proc != nil && proc.Signal(syscall.Signal(0)) != nil {
 				continue
 			}
 
-			// check if session has never been started
-			if proc == nil {
-				err := launch(&session)
-				if err != nil {
-					detail := fmt.Sprintf("failed to launch %s for %s: %s", session.Cmd.Path, id, err)
-					log.Error(detail)
-					return errors.New(detail)
-				}
-
-				// TODO: decide how to handle restart - probably needs to glue into the child reaping
+			err := launch(session)
+			if err == nil {
+				continue
 			}
 
-			// handle exited session
-			// TODO
-		}
+			detail := fmt.Sprintf("failed to launch %s for %s: %s", session.Cmd.Path, id, err)
+			log.Error(detail)
 
-		// launch the ssh server for interaction if any of the components were assigned a tty
-		if tty {
-			_, err := backchannel()
-			if err != nil {
-				detail := fmt.Sprintf("failed to open backchannel: %s", err)
-				log.Error(detail)
+			// the executor can't continue without the sessions it was configured with
+			if initial {
 				return errors.New(detail)
 			}
 
-			// handler := NewGlobalHandler(Config.ID)
-			// log.Info("Starting ssh handler for backchannel")
-			// err = StartTether(conn, &Config, handler)
-			// if err != nil {
-			// 	detail := fmt.Sprintf("failed to start server on backchannel: %s", err)
-			// 	log.Error(detail)
-			// 	exit(BACKCHANNEL, 2, detail)
-			// }
+			// for those added later, e.g. exec sessions, report the failure as the exit of the session
+			recordSessionExit(session, launchFailureStatus)
+			reportExit(session)
 		}
 	}
 
@@ -151,15 +292,28 @@ func run(loader metadata.ConfigLoader, configblob string) error {
 
 // handleSessionExit processes the result from the session command, records it in persistent
 // maner and determines if the Executor should exit
-func handleSessionExit(cmd *metadata.Cmd) error {
-	// flush session log output
+func handleSessionExit(session *metadata.SessionConfig, status int) error {
+	streams := lookupStreams(session.ID)
+	if streams != nil {
+		streams.flush()
+	}
 
 	// record exit status
+	recordSessionExit(session, status)
+	log.Infof("Session %s exited with status %d", session.ID, status)
+
+	// the host persists the status for us as guestinfo is read-only from within the guest
+	reportExit(session)
+
+	// attached clients are disconnected once the status is available to them
+	if streams != nil {
+		streams.unregister()
+	}
 
 	// check for executor behaviour
-	if LenChildPid() == 0 {
+	if liveSessions() == 0 {
 		// let the main loop exit if there's no more sessions to wait on
-		close(reload)
+		stopReload()
 	}
 
 	return nil
@@ -170,7 +324,7 @@ func handleSessionExit(cmd *metadata.Cmd) error {
 func launch(session *metadata.SessionConfig) error {
 	c := &session.Cmd
 	cmd := &exec.Cmd{
-		Path: c.Path,
+		Path: lookPath(c.Path, c.Env),
 		Args: c.Args,
 		Env:  processEnvOS(c.Env),
 		Dir:  c.Dir,
@@ -184,44 +338,91 @@ func launch(session *metadata.SessionConfig) error {
 		return errors.New(detail)
 	}
 
-	// Use the mutex to make creating a child and adding the child pid into the
-	// childPidTable appear atomic to the reaper function. Use a anonymous function
-	// so we can defer unlocking locally
-	err = func() error {
-		childPidTableMutex.Lock()
-		defer childPidTableMutex.Unlock()
-
-		log.Infof("Launching command %+q\n", cmd.Args)
-		if !session.Tty {
-			cmd.Stdin = nil
-			cmd.Stdout = writer
-			cmd.Stderr = writer
-
-			err = cmd.Start()
-		} else {
-			// pty, err = pty.Start(cmd)
-			err = errors.New("TTY enabled sessions not yet supported")
-		}
+	// make the streams available for attach before the process produces any output
+	streams := newStreams(session)
+	if !session.Attach {
+		return startSession(session, streams, writer, false)
+	}
 
-		if err != nil {
-			detail := fmt.Sprintf("failed to start container process: %s", err)
-			log.Error(detail)
-			return errors.New(detail)
+	// the session is held for a client to attach without holding up the launch of other sessions
+	// or config reloads, so a failure to start it is reported as its exit
+	childPidTableMutex.Lock()
+	heldLaunches++
+	childPidTableMutex.Unlock()
+
+	go func() {
+		streams.waitForAttach(attachWaitTimeout)
+		if err := startSession(session, streams, writer, true); err != nil {
+			log.Errorf("failed to launch %s for %s: %s", c.Path, session.ID, err)
+			handleSessionExit(session, launchFailureStatus)
 		}
+	}()
 
-		// ChildReaper will use this channel to inform us the wait status of the child.
-		childPidTable[cmd.Process.Pid] = c
+	return nil
+}
 
-		return nil
-	}()
+// startSession starts the process of the session, connected to its streams. held is set if the
+// launch of the session was held for a client to attach.
+func startSession(session *metadata.SessionConfig, streams *streams, writer io.Writer, held bool) error {
+	cmd := session.Cmd.Cmd
+
+	// Use the mutex to make creating a child and adding the child pid into the
+	// childPidTable appear atomic to the reaper function.
+	childPidTableMutex.Lock()
+	defer childPidTableMutex.Unlock()
+
+	if held {
+		heldLaunches--
+	}
+
+	log.Infof("Launching command %+q\n", cmd.Args)
+	if err := streams.start(cmd, writer); err != nil {
+		streams.unregister()
+
+		detail := fmt.Sprintf("failed to start container process: %s", err)
+		log.Error(detail)
+		return errors.New(detail)
+	}
 
-	return err
+	// ChildReaper will use this channel to inform us the wait status of the child.
+	childPidTable[cmd.Process.Pid] = session
+
+	return nil
+}
+
+// lookPath resolves a command name without a path separator using the PATH from the session
+// environment, as exec.LookPath would use that of the tether. Anything else is returned as is.
+func lookPath(file string, env []string) string {
+	if strings.Contains(file, "/") {
+		return file
+	}
+
+	path := defaultPath
+	for _, tuple := range env {
+		if strings.HasPrefix(tuple, "PATH=") {
+			path = strings.TrimPrefix(tuple, "PATH=")
+		}
+	}
+
+	for _, dir := range filepath.SplitList(path) {
+		candidate := filepath.Join(dir, file)
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return candidate
+		}
+	}
+
+	// let the launch report that it can't be found
+	return file
 }
 
 func logConfig(config *metadata.ExecutorConfig) {
 	// just pretty print the json for now
 	log.Info("Loaded executor config")
-	json, err := json.MarshalIndent(config, "", "   ")
+
+	// the host key is left out of the log
+	logged := *config
+	logged.Key = nil
+	json, err := json.MarshalIndent(&logged, "", "   ")
 	if err != nil {
 		log.Debugf("Failed to marshal config into json for logging: %s", err)
 		return
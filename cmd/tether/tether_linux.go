@@ -23,13 +23,16 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/exec"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/kr/pty"
 	"github.com/vmware/vic/cmd/tether/serial"
+	"github.com/vmware/vic/pkg/sessionlog"
 )
 
 // Mkdev will hopefully get rolled into go.sys at some point
@@ -57,9 +60,9 @@ func childReaper() {
 			if err == nil {
 				log.Debugf("Reaped process %d, return code: %d\n", pid, status.ExitStatus())
 
-				cmd, ok := RemoveChildPid(pid)
+				session, ok := RemoveChildPid(pid)
 				if ok {
-					handleSessionExit(cmd)
+					handleSessionExit(session, exitStatus(status))
 				} else {
 					// This is an adopted zombie. The Wait4 call
 					// already clean it up from the kernel
@@ -72,6 +75,16 @@ func childReaper() {
 	}
 }
 
+// exitStatus converts the wait status to an exit status, following the shell convention of
+// 128+n for a process terminated by signal n
+func exitStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return status.ExitStatus()
+}
+
 func setup() error {
 	// seems necessary given rand.Reader access
 	var err error
@@ -129,6 +142,11 @@ func backchannel() (net.Conn, error) {
 	return conn, nil
 }
 
+// startTty starts the command with a pseudo-terminal as its stdio, returning the master side
+func startTty(cmd *exec.Cmd) (*os.File, error) {
+	return pty.Start(cmd)
+}
+
 // processEnvOS does OS specific checking and munging on the process environment prior to launch
 func processEnvOS(env []string) []string {
 	// TODO: figure out how we're going to specify user and pass all the settings along
@@ -158,6 +176,7 @@ func sessionLogWriter() (io.Writer, error) {
 		return nil, errors.New(detail)
 	}
 
-	// use multi-writer so it goes to both screen and session log
-	return io.MultiWriter(f, os.Stdout), nil
+	// use multi-writer so it goes to both screen and session log, timestamping only the
+	// latter so that it can be filtered when served by the port layer
+	return io.MultiWriter(sessionlog.NewWriter(f), os.Stdout), nil
 }
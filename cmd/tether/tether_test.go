@@ -20,21 +20,36 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path"
 	"runtime"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/vmware/vic/cmd/tether/msgs"
+	"github.com/vmware/vic/cmd/tether/scp"
 	"github.com/vmware/vic/cmd/tether/utils"
 	"github.com/vmware/vic/metadata"
+	"github.com/vmware/vic/pkg/sessionlog"
+	"golang.org/x/crypto/ssh"
 )
 
 // createFakeDevices creates regular files or pipes in place of the char devices used
 // in a full VM
 func createFakeDevices() error {
-	// create serial devices
-	for i := 0; i < 3; i++ {
+	// create serial devices - the backchannel is a pipe so that the handshake blocks
+	// waiting for a client, as it would on a real serial port
+	path := fmt.Sprintf("%s/ttyS0", pathPrefix)
+	err := syscall.Mkfifo(path, 0600)
+	if err != nil {
+		detail := fmt.Sprintf("failed to create %s for com1: %s", path, err)
+		return errors.New(detail)
+	}
+
+	for i := 1; i < 3; i++ {
 		path := fmt.Sprintf("%s/ttyS%d", pathPrefix, i)
 		_, err := os.Create(path)
 		if err != nil {
@@ -44,8 +59,8 @@ func createFakeDevices() error {
 	}
 
 	// make an access to urandom
-	path := fmt.Sprintf("%s/urandom", pathPrefix)
-	err := os.Symlink("/dev/urandom", path)
+	path = fmt.Sprintf("%s/urandom", pathPrefix)
+	err = os.Symlink("/dev/urandom", path)
 	if err != nil {
 		detail := fmt.Sprintf("failed to create urandom access: %s", err)
 		return errors.New(detail)
@@ -64,8 +79,8 @@ func (c *TestMissingBinaryConfig) LoadConfig(blobl string) (*metadata.ExecutorCo
 
 	config.ID = "deadbeef"
 	config.Name = "tether_test_executor"
-	config.Sessions = map[string]metadata.SessionConfig{
-		"feebdaed": metadata.SessionConfig{
+	config.Sessions = map[string]*metadata.SessionConfig{
+		"feebdaed": &metadata.SessionConfig{
 			Common: metadata.Common{
 				ID:   "feebdaed",
 				Name: "tether_test_session",
@@ -94,8 +109,8 @@ func (c *TestRelativePathConfig) LoadConfig(blobl string) (*metadata.ExecutorCon
 
 	config.ID = "deadbeef"
 	config.Name = "tether_test_executor"
-	config.Sessions = map[string]metadata.SessionConfig{
-		"feebdaed": metadata.SessionConfig{
+	config.Sessions = map[string]*metadata.SessionConfig{
+		"feebdaed": &metadata.SessionConfig{
 			Common: metadata.Common{
 				ID:   "feebdaed",
 				Name: "tether_test_session",
@@ -124,8 +139,8 @@ func (c *TestAbsPathConfig) LoadConfig(blobl string) (*metadata.ExecutorConfig,
 
 	config.ID = "deadbeef"
 	config.Name = "tether_test_executor"
-	config.Sessions = map[string]metadata.SessionConfig{
-		"feebdaed": metadata.SessionConfig{
+	config.Sessions = map[string]*metadata.SessionConfig{
+		"feebdaed": &metadata.SessionConfig{
 			Common: metadata.Common{
 				ID:   "feebdaed",
 				Name: "tether_test_session",
@@ -144,6 +159,137 @@ func (c *TestAbsPathConfig) LoadConfig(blobl string) (*metadata.ExecutorConfig,
 	return &config, nil
 }
 
+type TestSignalConfig struct{}
+
+func (c *TestSignalConfig) StoreConfig(*metadata.ExecutorConfig) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (c *TestSignalConfig) LoadConfig(blobl string) (*metadata.ExecutorConfig, error) {
+	config := metadata.ExecutorConfig{}
+
+	config.ID = "deadbeef"
+	config.Name = "tether_test_executor"
+	config.Sessions = map[string]*metadata.SessionConfig{
+		"feebdaed": &metadata.SessionConfig{
+			Common: metadata.Common{
+				ID:   "feebdaed",
+				Name: "tether_test_session",
+			},
+			Tty: false,
+			Cmd: metadata.Cmd{
+				Path: "/bin/sleep",
+				Args: []string{"sleep", "60"},
+				Env:  []string{},
+				Dir:  "/",
+			},
+		},
+	}
+
+	return &config, nil
+}
+
+type TestAttachConfig struct{}
+
+func (c *TestAttachConfig) StoreConfig(*metadata.ExecutorConfig) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (c *TestAttachConfig) LoadConfig(blobl string) (*metadata.ExecutorConfig, error) {
+	config := metadata.ExecutorConfig{}
+
+	config.ID = "deadbeef"
+	config.Name = "tether_test_executor"
+	config.Sessions = map[string]*metadata.SessionConfig{
+		"feebdaed": &metadata.SessionConfig{
+			Common: metadata.Common{
+				ID:   "feebdaed",
+				Name: "tether_test_session",
+			},
+			Tty:       false,
+			OpenStdin: true,
+			StdinOnce: true,
+			Attach:    true,
+			Cmd: metadata.Cmd{
+				Path: "/bin/cat",
+				Args: []string{"cat"},
+				Env:  []string{},
+				Dir:  "/",
+			},
+		},
+	}
+
+	return &config, nil
+}
+
+type TestReloadConfig struct{}
+
+func (c *TestReloadConfig) StoreConfig(*metadata.ExecutorConfig) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (c *TestReloadConfig) LoadConfig(blob string) (*metadata.ExecutorConfig, error) {
+	config, err := (&TestSignalConfig{}).LoadConfig(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	// the reloaded config adds an exec session
+	if blob == "exec" {
+		config.Sessions["cafebabe"] = &metadata.SessionConfig{
+			Common: metadata.Common{
+				ID: "cafebabe",
+			},
+			Cmd: metadata.Cmd{
+				// test relative path
+				Path: "true",
+				Args: []string{"true"},
+				Env:  []string{},
+				Dir:  "/",
+			},
+		}
+	}
+
+	return config, nil
+}
+
+type TestHeldConfig struct{}
+
+func (c *TestHeldConfig) StoreConfig(*metadata.ExecutorConfig) (string, error) {
+	return "", errors.New("not implemented")
+}
+func (c *TestHeldConfig) LoadConfig(blob string) (*metadata.ExecutorConfig, error) {
+	config := metadata.ExecutorConfig{}
+
+	config.ID = "deadbeef"
+	config.Name = "tether_test_executor"
+	config.Sessions = map[string]*metadata.SessionConfig{
+		// held for a client that never attaches
+		"feebdaed": &metadata.SessionConfig{
+			Common: metadata.Common{
+				ID: "feebdaed",
+			},
+			Attach: true,
+			Cmd: metadata.Cmd{
+				Path: "/bin/true",
+				Args: []string{"true"},
+				Env:  []string{},
+				Dir:  "/",
+			},
+		},
+		"cafebabe": &metadata.SessionConfig{
+			Common: metadata.Common{
+				ID: "cafebabe",
+			},
+			Cmd: metadata.Cmd{
+				Path: "/bin/true",
+				Args: []string{"true"},
+				Env:  []string{},
+				Dir:  "/",
+			},
+		},
+	}
+
+	return &config, nil
+}
+
 func testSetup(t *testing.T) {
 	var err error
 
@@ -157,6 +303,9 @@ func testSetup(t *testing.T) {
 	}
 	utils.SetPathPrefix(pathPrefix)
 
+	// there's no port layer to report session exit to
+	exitReportTimeout = 0
+
 	err = os.MkdirAll(pathPrefix, 0777)
 	if err != nil {
 		fmt.Println(err)
@@ -176,18 +325,6 @@ func testTeardown(t *testing.T) {
 	log.SetOutput(os.Stdout)
 }
 
-func TestRelativePath(t *testing.T) {
-	t.Skip("Relative path resolution not yet implemented")
-
-	testSetup(t)
-
-	if err := run(&TestRelativePathConfig{}, ""); err != nil {
-		t.Error(err)
-	}
-
-	testTeardown(t)
-}
-
 func TestAbsPath(t *testing.T) {
 	testSetup(t)
 
@@ -209,8 +346,25 @@ func TestAbsPath(t *testing.T) {
 		t.Error(err)
 	}
 
-	if !bytes.Equal(out, log) {
-		err := fmt.Errorf("Actual and expected output did not match\nExpected: %s\nActual:   %s\n", out, log)
+	// strip the timestamps recorded with each line
+	var actual []byte
+	for _, line := range bytes.SplitAfter(log, []byte("\n")) {
+		_, content := sessionlog.ParseLine(line)
+		actual = append(actual, content...)
+	}
+
+	if !bytes.Equal(out, actual) {
+		err := fmt.Errorf("Actual and expected output did not match\nExpected: %s\nActual:   %s\n", out, actual)
+		t.Error(err)
+	}
+
+	testTeardown(t)
+}
+
+func TestRelativePath(t *testing.T) {
+	testSetup(t)
+
+	if err := run(&TestRelativePathConfig{}, ""); err != nil {
 		t.Error(err)
 	}
 
@@ -237,6 +391,430 @@ func TestMissingBinary(t *testing.T) {
 	testTeardown(t)
 }
 
+func TestSignalSession(t *testing.T) {
+	testSetup(t)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- run(&TestSignalConfig{}, "")
+	}()
+
+	// wait for the session to be launched
+	for i := 0; LenChildPid() == 0; i++ {
+		if i == 100 {
+			t.Fatal("Session was not launched")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := SignalSession("nosuchsession", syscall.SIGTERM); err == nil {
+		t.Error("Expected error signalling unknown session")
+	}
+
+	if err := SignalSession("feebdaed", syscall.SIGTERM); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Tether did not exit after session was signalled")
+	}
+
+	status, finished, _ := sessionExit("feebdaed")
+	if status != 128+int(syscall.SIGTERM) {
+		t.Errorf("Expected exit status %d, got %d", 128+int(syscall.SIGTERM), status)
+	}
+	if finished == 0 {
+		t.Error("Expected finish time to be recorded")
+	}
+
+	testTeardown(t)
+}
+
+func TestReportExit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serve(server)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, _, reqs, err := ssh.NewClientConn(client, "", &ssh.ClientConfig{User: "daemon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	exitReportTimeout = 5 * time.Second
+	session := &metadata.SessionConfig{
+		Common:     metadata.Common{ID: "feebdaed"},
+		ExitStatus: 3,
+		Finished:   1462000000,
+	}
+	go reportExit(session)
+
+	select {
+	case req := <-reqs:
+		if req.Type != msgs.ExitReq {
+			t.Fatalf("Expected %s request, got %s", msgs.ExitReq, req.Type)
+		}
+		req.Reply(true, nil)
+
+		msg := &msgs.ExitMsg{}
+		if err = msgs.Unmarshal(req.Payload, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.ID != "feebdaed" || msg.ExitStatus != 3 || msg.Finished != 1462000000 {
+			t.Errorf("Unexpected exit report: %+v", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Exit was not reported")
+	}
+}
+
+func TestReload(t *testing.T) {
+	testSetup(t)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- run(&TestReloadConfig{}, "initial")
+	}()
+
+	for i := 0; LenChildPid() == 0; i++ {
+		if i == 100 {
+			t.Fatal("Session was not launched")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if !handleReload(msgs.Marshal(&msgs.ReloadMsg{Config: "exec"})) {
+		t.Fatal("Reload request was refused")
+	}
+
+	// the exec session should run to completion alongside the primary
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatal("Exec session did not complete")
+		}
+		if status, finished, ok := sessionExit("cafebabe"); ok && finished != 0 {
+			if status != 0 {
+				t.Errorf("Expected exec session to exit with 0, got %d", status)
+			}
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if LenChildPid() != 1 {
+		t.Errorf("Expected only the primary session to be running, found %d sessions", LenChildPid())
+	}
+
+	if err := SignalSession("feebdaed", syscall.SIGTERM); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Tether did not exit after primary session was signalled")
+	}
+
+	if handleReload(msgs.Marshal(&msgs.ReloadMsg{})) {
+		t.Error("Expected reload to be refused once the executor has exited")
+	}
+
+	testTeardown(t)
+}
+
+func TestAttach(t *testing.T) {
+	testSetup(t)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- run(&TestAttachConfig{}, "")
+	}()
+
+	// wait for the session streams to be available
+	for i := 0; lookupStreams("feebdaed") == nil; i++ {
+		if i == 100 {
+			t.Fatal("Session streams were not created")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serve(server)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, _, reqs, err := ssh.NewClientConn(client, "", &ssh.ClientConfig{User: "daemon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	ch, chreqs, err := conn.OpenChannel(msgs.AttachChannel, msgs.Marshal(&msgs.AttachMsg{ID: "feebdaed"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(chreqs)
+
+	// the session is launched once attached and echoes stdin until it's closed
+	if _, err = ch.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	ch.CloseWrite()
+
+	out, err := ioutil.ReadAll(ch)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(out) != "hello\n" {
+		t.Errorf("Expected output %q, got %q", "hello\n", out)
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Tether did not exit after session stdin was closed")
+	}
+
+	// attach waits for sessions that may yet be loaded, so don't wait long for this one
+	timeout := attachWaitTimeout
+	attachWaitTimeout = 500 * time.Millisecond
+	defer func() { attachWaitTimeout = timeout }()
+
+	if _, _, err = conn.OpenChannel(msgs.AttachChannel, msgs.Marshal(&msgs.AttachMsg{ID: "nosuchsession"})); err == nil {
+		t.Error("Expected attach to unknown session to be rejected")
+	}
+
+	testTeardown(t)
+}
+
+func TestHeldLaunch(t *testing.T) {
+	testSetup(t)
+
+	timeout := attachWaitTimeout
+	attachWaitTimeout = 2 * time.Second
+	defer func() { attachWaitTimeout = timeout }()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- run(&TestHeldConfig{}, "")
+	}()
+
+	// the other session isn't held up by the one waiting for a client
+	for i := 0; ; i++ {
+		if i == 20 {
+			t.Fatal("Session was not launched while another was held")
+		}
+		if _, finished, ok := sessionExit("cafebabe"); ok && finished != 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// nor does the executor exit while a session is held
+	select {
+	case err := <-result:
+		t.Fatalf("Tether exited while a session was held: %v", err)
+	default:
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Tether did not exit after the held session was launched")
+	}
+
+	if _, finished, _ := sessionExit("feebdaed"); finished == 0 {
+		t.Error("Expected the held session to have been launched once the wait expired")
+	}
+
+	testTeardown(t)
+}
+
+func TestCopy(t *testing.T) {
+	testSetup(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serve(server)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, _, reqs, err := ssh.NewClientConn(client, "", &ssh.ClientConfig{User: "daemon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	target := pathPrefix + "/copied"
+	stat := func() *msgs.StatResultMsg {
+		ok, payload, err := conn.SendRequest(msgs.StatReq, true, msgs.Marshal(&msgs.StatMsg{Path: target}))
+		if err != nil || !ok {
+			t.Fatalf("Stat request failed: ok=%t, err=%s", ok, err)
+		}
+		result := &msgs.StatResultMsg{}
+		if err = msgs.Unmarshal(payload, result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if !stat().NotFound {
+		t.Errorf("Expected %s not to be found", target)
+	}
+
+	// copy into the container
+	ch, chreqs, err := conn.OpenChannel(msgs.ScpChannel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ch.SendRequest(msgs.ScpDestReq, true, []byte(target)); err != nil || !ok {
+		t.Fatalf("Destination request failed: ok=%t, err=%s", ok, err)
+	}
+	if _, err = ch.Write([]byte("C0640 6 copied\nhello\n")); err != nil {
+		t.Fatal(err)
+	}
+	ch.CloseWrite()
+
+	status := &scp.ExitStatusMsg{Status: 255}
+	for req := range chreqs {
+		if req.Type == scp.ExitStatusReq {
+			if err = ssh.Unmarshal(req.Payload, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if status.Status != 0 {
+		t.Errorf("Expected copy into the container to succeed, got status %d", status.Status)
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("Expected %q to be copied, got %q", "hello\n", data)
+	}
+
+	result := stat()
+	if result.NotFound || result.Name != "copied" || result.Size != 6 || os.FileMode(result.Mode) != 0640 {
+		t.Errorf("Unexpected stat result: %+v", result)
+	}
+
+	// and back out again
+	ch, chreqs, err = conn.OpenChannel(msgs.ScpChannel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(chreqs)
+	if ok, err := ch.SendRequest(msgs.ScpSourceReq, true, []byte(target)); err != nil || !ok {
+		t.Fatalf("Source request failed: ok=%t, err=%s", ok, err)
+	}
+
+	out, err := ioutil.ReadAll(ch)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(out) != "C0640 6 copied\nhello\n" {
+		t.Errorf("Unexpected copy from the container: %q", out)
+	}
+
+	// directories are copied with their content
+	dir := pathPrefix + "/dir"
+	if err = os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(target, dir+"/copied"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, chreqs, err = conn.OpenChannel(msgs.ScpChannel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(chreqs)
+	if ok, err := ch.SendRequest(msgs.ScpSourceReq, true, []byte(dir)); err != nil || !ok {
+		t.Fatalf("Source request failed: ok=%t, err=%s", ok, err)
+	}
+
+	out, err = ioutil.ReadAll(ch)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(out) != "D0755 0 dir\nC0640 6 copied\nhello\nE\n" {
+		t.Errorf("Unexpected copy of a directory from the container: %q", out)
+	}
+
+	testTeardown(t)
+}
+
 func TestSetIpAddress(t *testing.T) {
 	testSetup(t)
 
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/exec"
 	"strings"
 	"time"
 
@@ -164,6 +165,11 @@ func sessionLogWriter() (io.Writer, error) {
 	return f, nil
 }
 
+// startTty starts the command with a pseudo-terminal as its stdio, returning the master side
+func startTty(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errors.New("TTY enabled sessions not supported on windows")
+}
+
 // processEnvOS does OS specific checking and munging on the process environment prior to launch
 func processEnvOS(env []string) []string {
 	// TODO: figure out how we're going to specify user and pass all the settings along
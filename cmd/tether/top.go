@@ -0,0 +1,430 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/cmd/tether/msgs"
+)
+
+// procRoot and passwdFile are variables so that tests can supply their own
+var (
+	procRoot   = "/proc"
+	passwdFile = "/etc/passwd"
+)
+
+// clockTicks is the unit of the process times in /proc, USER_HZ, which is fixed on linux
+const clockTicks = 100
+
+// the process table formats that ps arguments can select
+const (
+	// topDefault is used when the arguments ask for neither of the other formats
+	topDefault = iota
+	// topFull is the -f format of ps, as used by docker with its default of -ef
+	topFull
+	// topUser is the BSD u format of ps, as in ps aux
+	topUser
+)
+
+// process is what is known of a process from /proc
+type process struct {
+	pid   int
+	ppid  int
+	uid   int
+	state string
+	tty   uint64
+	comm  string
+	args  []string
+
+	// utime, stime and start are in clock ticks, start being since boot
+	utime uint64
+	stime uint64
+	start uint64
+
+	// vsize is in bytes and rss in pages
+	vsize uint64
+	rss   uint64
+}
+
+// handleTop decodes a top request and replies with a ps style table of the processes in the
+// container, excluding the tether and kernel threads
+func handleTop(payload []byte) (bool, []byte) {
+	msg := &msgs.TopMsg{}
+	if err := msgs.Unmarshal(payload, msg); err != nil {
+		log.Errorf("failed to unmarshal top request: %s", err)
+		return false, nil
+	}
+
+	table, err := processTable(msg.Args, os.Getpid(), time.Now())
+	if err != nil {
+		log.Errorf("failed to list processes: %s", err)
+		return false, []byte(err.Error())
+	}
+
+	return true, msgs.Marshal(&msgs.TopResultMsg{Output: table})
+}
+
+// topFormat picks the table format from ps arguments. Only the format selecting options are
+// honored, as all of the processes in the container are always listed.
+func topFormat(args string) int {
+	for _, arg := range strings.Fields(args) {
+		// BSD style options have no leading dash
+		if !strings.HasPrefix(arg, "-") && strings.Contains(arg, "u") {
+			return topUser
+		}
+		if strings.HasPrefix(arg, "-") && strings.Contains(arg, "f") {
+			return topFull
+		}
+	}
+
+	return topDefault
+}
+
+// processTable renders the processes found in procRoot as a table in the format selected by
+// args. Only the last column may contain spaces.
+func processTable(args string, self int, now time.Time) (string, error) {
+	procs, err := listProcesses(self)
+	if err != nil {
+		return "", err
+	}
+
+	uptime, err := readUptime()
+	if err != nil {
+		return "", err
+	}
+	boot := now.Add(-uptime)
+
+	memTotal, err := readMemTotal()
+	if err != nil {
+		return "", err
+	}
+
+	users := readUsers()
+	user := func(uid int) string {
+		if name, ok := users[uid]; ok {
+			return name
+		}
+		return strconv.Itoa(uid)
+	}
+
+	format := topFormat(args)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 1, ' ', 0)
+	switch format {
+	case topFull:
+		fmt.Fprintln(w, "UID\tPID\tPPID\tC\tSTIME\tTTY\tTIME\tCMD")
+	case topUser:
+		fmt.Fprintln(w, "USER\tPID\t%CPU\t%MEM\tVSZ\tRSS\tTTY\tSTAT\tSTART\tTIME\tCOMMAND")
+	default:
+		fmt.Fprintln(w, "UID\tPID\tPPID\t%CPU\t%MEM\tCMD")
+	}
+
+	for _, p := range procs {
+		cputime := time.Duration(p.utime+p.stime) * time.Second / clockTicks
+		started := boot.Add(time.Duration(p.start) * time.Second / clockTicks)
+
+		cpu := 0.0
+		if elapsed := now.Sub(started); elapsed > 0 {
+			cpu = 100 * float64(cputime) / float64(elapsed)
+		}
+		mem := 0.0
+		rss := p.rss * uint64(os.Getpagesize())
+		if memTotal > 0 {
+			mem = 100 * float64(rss) / float64(memTotal)
+		}
+
+		switch format {
+		case topFull:
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n", user(p.uid), p.pid, p.ppid, int(cpu),
+				startTime(started, now), ttyName(p.tty), formatCPUTime(cputime, true), p.command())
+		case topUser:
+			fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", user(p.uid), p.pid, cpu, mem,
+				p.vsize/1024, rss/1024, ttyName(p.tty), p.state, startTime(started, now), formatCPUTime(cputime, false), p.command())
+		default:
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\t%s\n", user(p.uid), p.pid, p.ppid, cpu, mem, p.command())
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// command returns the command line of the process, or its name in brackets if it has none
+func (p *process) command() string {
+	if len(p.args) == 0 {
+		return "[" + p.comm + "]"
+	}
+	return strings.Join(p.args, " ")
+}
+
+// listProcesses reads the processes in procRoot, ordered by pid. The process with the pid self
+// and kernel threads are skipped, as are processes that exit while being read.
+func listProcesses(self int) ([]*process, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []*process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		p, err := readProcess(pid)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Warnf("failed to read process %d: %s", pid, err)
+			}
+			continue
+		}
+
+		// kthreadd is pid 2 and the parent of all other kernel threads
+		if p.pid == 2 || p.ppid == 2 {
+			continue
+		}
+
+		procs = append(procs, p)
+	}
+
+	sort.Sort(byPid(procs))
+	return procs, nil
+}
+
+type byPid []*process
+
+func (s byPid) Len() int           { return len(s) }
+func (s byPid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPid) Less(i, j int) bool { return s[i].pid < s[j].pid }
+
+// readProcess reads the stat, status and cmdline files of the process
+func readProcess(pid int) (*process, error) {
+	dir := path.Join(procRoot, strconv.Itoa(pid))
+
+	stat, err := ioutil.ReadFile(path.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := parseStat(string(stat))
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := ioutil.ReadFile(path.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the fields of the Uid line are the real, effective, saved and filesystem IDs
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[0] == "Uid:" {
+			if p.uid, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("invalid uid in status of %d: %s", pid, line)
+			}
+			break
+		}
+	}
+
+	cmdline, err := ioutil.ReadFile(path.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+
+	// arguments are NUL terminated
+	if cmdline = bytes.TrimRight(cmdline, "\x00"); len(cmdline) > 0 {
+		p.args = strings.Split(string(cmdline), "\x00")
+	}
+
+	return p, nil
+}
+
+// parseStat parses the content of /proc/<pid>/stat. The command name is in parentheses and may
+// itself contain spaces and parentheses, so the fields are split after the last one.
+func parseStat(stat string) (*process, error) {
+	open := strings.Index(stat, "(")
+	end := strings.LastIndex(stat, ")")
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("invalid process stat: %q", stat)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(stat[:open]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid process stat: %q", stat)
+	}
+
+	// the fields following the name, starting with the state, which is field 3 in proc(5)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("truncated process stat: %q", stat)
+	}
+
+	p := &process{
+		pid:   pid,
+		comm:  stat[open+1 : end],
+		state: fields[0],
+	}
+
+	numbers := []struct {
+		field int
+		value *uint64
+	}{
+		{4, &p.tty},
+		{11, &p.utime},
+		{12, &p.stime},
+		{19, &p.start},
+		{20, &p.vsize},
+		{21, &p.rss},
+	}
+	for _, n := range numbers {
+		if *n.value, err = strconv.ParseUint(fields[n.field], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid process stat: %q", stat)
+		}
+	}
+
+	if p.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid process stat: %q", stat)
+	}
+
+	return p, nil
+}
+
+// readUptime returns the time since boot
+func readUptime() (time.Duration, error) {
+	data, err := ioutil.ReadFile(path.Join(procRoot, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid uptime: %q", data)
+	}
+
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uptime: %q", data)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// readMemTotal returns the memory of the guest in bytes
+func readMemTotal() (uint64, error) {
+	data, err := ioutil.ReadFile(path.Join(procRoot, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid meminfo: %q", line)
+			}
+			return kb * 1024, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no MemTotal in meminfo")
+}
+
+// readUsers returns the user names from the passwd file by uid. The container image may not
+// have one, in which case uids are shown.
+func readUsers() map[int]string {
+	users := make(map[int]string)
+
+	f, err := os.Open(passwdFile)
+	if err != nil {
+		return users
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := users[uid]; !ok {
+			users[uid] = fields[0]
+		}
+	}
+
+	return users
+}
+
+// ttyName returns the name of the controlling terminal from its device number, or ? if there is
+// none or it isn't a pseudo or virtual terminal
+func ttyName(dev uint64) string {
+	major := (dev >> 8) & 0xfff
+	minor := (dev & 0xff) | ((dev >> 12) & 0xfff00)
+
+	switch {
+	case major >= 136 && major <= 143:
+		return fmt.Sprintf("pts/%d", (major-136)*256+minor)
+	case major == 4 && minor < 64:
+		return fmt.Sprintf("tty%d", minor)
+	default:
+		return "?"
+	}
+}
+
+// startTime formats the start of a process as ps does, with the time of day for processes started
+// in the last day and the date otherwise
+func startTime(started, now time.Time) string {
+	if now.Sub(started) < 24*time.Hour {
+		return started.Format("15:04")
+	}
+	return started.Format("Jan02")
+}
+
+// formatCPUTime formats cumulative CPU time as [DD-]HH:MM:SS if long, or MM:SS otherwise
+func formatCPUTime(d time.Duration, long bool) string {
+	seconds := int64(d / time.Second)
+	if !long {
+		return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+	}
+
+	days := seconds / 86400
+	hms := fmt.Sprintf("%02d:%02d:%02d", seconds%86400/3600, seconds%3600/60, seconds%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, hms)
+	}
+	return hms
+}
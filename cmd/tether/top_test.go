@@ -0,0 +1,159 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProcess writes the /proc files of a process with the given stat fields following the name
+func fakeProcess(t *testing.T, root string, pid int, comm, fields string, uid int, cmdline string) {
+	dir := path.Join(root, fmt.Sprint(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (%s) %s\n", pid, comm, fields),
+		"status":  fmt.Sprintf("Name:\t%s\nUid:\t%d\t%[2]d\t%[2]d\t%[2]d\n", comm, uid),
+		"cmdline": cmdline,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// statFields returns the stat fields following the name of a process with the given state,
+// parent, tty, times in ticks and memory
+func statFields(state string, ppid int, tty, utime, stime, start, vsize, rss uint64) string {
+	return fmt.Sprintf("%s %d 1 1 %d -1 4194560 100 0 0 0 %d %d 0 0 20 0 1 0 %d %d %d 18446744073709551615",
+		state, ppid, tty, utime, stime, start, vsize, rss)
+}
+
+func TestProcessTable(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	defer func(proc, passwd string) { procRoot, passwdFile = proc, passwd }(procRoot, passwdFile)
+	procRoot = root
+	passwdFile = path.Join(root, "passwd")
+
+	// the guest was booted 1000s ago with 1GiB of memory
+	files := map[string]string{
+		"uptime":  "1000.00 900.00\n",
+		"meminfo": "MemTotal:        1048576 kB\nMemFree:          524288 kB\n",
+		"passwd":  "root:x:0:0:root:/root:/bin/sh\nnobody:x:65534:65534:nobody:/:/bin/false\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pages := uint64(1024 * 1024 / os.Getpagesize())
+
+	// the tether, which is excluded
+	fakeProcess(t, root, 1, "tether", statFields("S", 0, 0, 10, 10, 0, 1000, 10), 0, "/.tether/tether\x00")
+	// kernel threads, which are excluded
+	fakeProcess(t, root, 2, "kthreadd", statFields("S", 0, 0, 0, 0, 0, 0, 0), 0, "")
+	fakeProcess(t, root, 3, "ksoftirqd/0", statFields("S", 2, 0, 0, 0, 0, 0, 0), 0, "")
+	// a shell on pts/0 started 500s after boot that has used 50s of CPU and 1MiB of memory
+	fakeProcess(t, root, 10, "sh", statFields("S", 1, 136<<8, 4000, 1000, 50000, 4096*1024, pages), 0, "/bin/sh\x00-c\x00sleep 1\x00")
+	// a process with an awkward name and no command line, running as a user without a name
+	fakeProcess(t, root, 12, "a) (b", statFields("R", 10, 0, 0, 0, 90000, 0, 0), 1000, "")
+
+	now := time.Now()
+
+	tests := []struct {
+		args     string
+		expected [][]string
+	}{
+		{
+			"",
+			[][]string{
+				{"UID", "PID", "PPID", "%CPU", "%MEM", "CMD"},
+				{"root", "10", "1", "10.0", "0.1", "/bin/sh -c sleep 1"},
+				{"1000", "12", "10", "0.0", "0.0", "[a) (b]"},
+			},
+		},
+		{
+			"-ef",
+			[][]string{
+				{"UID", "PID", "PPID", "C", "STIME", "TTY", "TIME", "CMD"},
+				{"root", "10", "1", "10", now.Add(-500 * time.Second).Format("15:04"), "pts/0", "00:00:50", "/bin/sh -c sleep 1"},
+				{"1000", "12", "10", "0", now.Add(-100 * time.Second).Format("15:04"), "?", "00:00:00", "[a) (b]"},
+			},
+		},
+		{
+			"aux",
+			[][]string{
+				{"USER", "PID", "%CPU", "%MEM", "VSZ", "RSS", "TTY", "STAT", "START", "TIME", "COMMAND"},
+				{"root", "10", "10.0", "0.1", "4096", "1024", "pts/0", "S", now.Add(-500 * time.Second).Format("15:04"), "0:50", "/bin/sh -c sleep 1"},
+				{"1000", "12", "0.0", "0.0", "0", "0", "?", "R", now.Add(-100 * time.Second).Format("15:04"), "0:00", "[a) (b]"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		table, err := processTable(test.args, 1, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(table, "\n"), "\n")
+		if len(lines) != len(test.expected) {
+			t.Fatalf("%q: expected %d lines, got:\n%s", test.args, len(test.expected), table)
+		}
+
+		for i, line := range lines {
+			// only the last column may contain spaces
+			width := len(test.expected[i])
+			fields := strings.Fields(line)
+			if len(fields) >= width {
+				fields = append(fields[:width-1], strings.Join(fields[width-1:], " "))
+			}
+
+			if strings.Join(fields, "|") != strings.Join(test.expected[i], "|") {
+				t.Errorf("%q: expected %q, got %q", test.args, test.expected[i], fields)
+			}
+		}
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	if _, err := parseStat("12 (sh) S 1"); err == nil {
+		t.Error("Expected error for truncated stat")
+	}
+
+	p, err := parseStat("12 (my (prog)) " + statFields("Z", 7, 0, 1, 2, 3, 4, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.pid != 12 || p.comm != "my (prog)" || p.state != "Z" || p.ppid != 7 ||
+		p.utime != 1 || p.stime != 2 || p.start != 3 || p.vsize != 4 || p.rss != 5 {
+		t.Errorf("Unexpected process: %+v", p)
+	}
+}
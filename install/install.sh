@@ -28,9 +28,11 @@ DIR=$(dirname "$THIS")
 set -e
 
 function usage() {
-     echo "# Usage: $0 -t=target-url -p=compute-resource -i=image-datastore [-d=container-datastore] [-e=external-network] [-m=management-network] [-b=bridge-network] [-a=appliance-iso] [-c=bootstrap] [-g=stub] [-x=certificate-file] [-y=key-file] [-v:verbose] [-f] name" 2>&1
+     echo "# Usage: $0 -t=target-url -p=compute-resource -i=image-datastore [-d=container-datastore] [-e=external-network] [-m=management-network] [-b=bridge-network] [-a=appliance-iso] [-c=bootstrap] [-g=stub] [-x=certificate-file] [-y=key-file] [-s=pause-mode] [-n=container-cpus] [-r=container-memory-mb] [-v:verbose] [-f] name" 2>&1
      echo "#   -g: generate the certificate and key files, using the value as a stub name"
      echo "#   -f: delete existing VM and image store if found"
+     echo "#   -s: how containers are paused, suspend (the container VM) or freeze (its processes)"
+     echo "#   -n, -r: vCPUs and memory in MB of containers that don't ask for them, 2 and 2048 by default"
 
      exit 1
 }
@@ -49,7 +51,7 @@ applianceIso="${DIR}/appliance.iso"
 bootstrapIso="${DIR}/bootstrap.iso"
 
 
-while getopts "fvt:gp:i:d:e:m:b:a:c:x:y:" flag
+while getopts "fvt:gp:i:d:e:m:b:a:c:x:y:s:n:r:" flag
 do
   case $flag in
     v)
@@ -137,6 +139,21 @@ do
       key=$(cat "$OPTARG")
       ;;
 
+    s)
+      # Optional. Pause mode - suspend or freeze
+      pauseMode="${OPTARG}"
+      ;;
+
+    n)
+      # Optional. vCPUs of container VMs
+      containerCPUs="${OPTARG}"
+      ;;
+
+    r)
+      # Optional. Memory in MB of container VMs
+      containerMemory="${OPTARG}"
+      ;;
+
     *)
     usage
     ;;
@@ -155,6 +172,13 @@ if [ -z "$1" -o -z "$GOVC_URL" -o -z "$vchName" -o -z "$compute" -o -z "$idatast
      usage
 fi
 
+for size in "${containerCPUs}" "${containerMemory}"; do
+    if [ -n "${size}" ] && ! [[ "${size}" =~ ^[1-9][0-9]*$ ]]; then
+        echo "Container vCPUs and memory must be positive integers (${size})"
+        usage
+    fi
+done
+
 if [ -n "$tlsGenerate" ]; then
     # Optional. Generate the cert and key and store them in $OPTARG-{cert,key}.pem
     keyf="${vchName}-key.pem"
@@ -246,9 +270,19 @@ fi
 echo "# Setting component configuration"
 govc vm.change -vm.uuid="${uuid}" -e guestinfo.vch/components="/sbin/docker-engine-server /sbin/port-layer-server /sbin/vicadmin"
 govc vm.change -vm.uuid="${uuid}" -e guestinfo.vch/sbin/imagec="-debug -logfile=/var/log/vic/imagec.log -insecure"
-govc vm.change -vm.uuid="${uuid}" -e guestinfo.vch/sbin/port-layer-server="--host=localhost --port=8080 --insecure --sdk=${targetURL} --datacenter=${datacenter} --cluster=${compute} --datastore=/${datacenter}/datastore/${idatastore} --network=/ha-datacenter/network/${externalNet} --vch=${vchName}"
+govc vm.change -vm.uuid="${uuid}" -e guestinfo.vch/sbin/port-layer-server="--host=localhost --port=8080 --insecure --sdk=${targetURL} --datacenter=${datacenter} --cluster=${compute} --datastore=/${datacenter}/datastore/${idatastore} --network=/ha-datacenter/network/${externalNet} --vch=${vchName} ${pauseMode:+--pause-mode=${pauseMode}}"
 files="/var/tmp/images/ /var/log/vic/"
 
+# the port layer sizes container VMs that don't ask for a size from the VCH configuration
+if [ -n "${containerCPUs}" -o -n "${containerMemory}" ]; then
+   containerVMSize="${containerCPUs:+\"NumCPUs\":${containerCPUs}}"
+   if [ -n "${containerMemory}" ]; then
+      containerVMSize="${containerVMSize:+${containerVMSize},}\"Memory\":{\"Limit\":${containerMemory}}"
+   fi
+   vchConfig=$(printf '{"ContainerVMSize":{%s}}' "${containerVMSize}" | base64 | tr -d '\n')
+   govc vm.change -vm.uuid="${uuid}" -e vic.vchconfig="${vchConfig}"
+fi
+
 # now we see if we configure TLS
 if [ -n "${certificate}" -a -n "${key}" ] ; then
    echo "# Configuring TLS server"
@@ -72,6 +72,27 @@ type ContainerVM struct {
 	// Key is the host key used during communicate back with the Interaction endpoint if any
 	// Used if the vSocket agent is responsible for authenticating the connection
 	AgentKey []byte
+
+	// RestartPolicy determines whether the container is started again when its primary process exits
+	RestartPolicy RestartPolicy
+
+	// RestartCount is the number of times the restart policy has started the container since a
+	// client last did
+	RestartCount int
+
+	// StoppedByUser is set when a client stops the container, which overrides the restart policy
+	// until a client next starts it
+	StoppedByUser bool
+
+	// AutoRemove destroys the container once its primary process has exited
+	AutoRemove bool
+}
+
+// RestartPolicy mirrors the docker restart policy - the name is one of no, always, on-failure or
+// unless-stopped, and the retry count limits on-failure restarts if non-zero
+type RestartPolicy struct {
+	Name              string
+	MaximumRetryCount int
 }
 
 // ExecutorConfig holds the data tightly associated with an Executor. This is distinct from Sessions
@@ -82,7 +103,7 @@ type ExecutorConfig struct {
 
 	// Sessions is the set of sessions currently hosted by this executor
 	// These are keyed by session ID
-	Sessions map[string]SessionConfig
+	Sessions map[string]*SessionConfig
 
 	// Maps the mount name to the detail mount specification
 	Mounts map[string]MountSpec
@@ -94,6 +115,18 @@ type ExecutorConfig struct {
 	// Key is the host key used during communicate back with the Interaction endpoint if any
 	// Used if the in-guest tether is responsible for authenticating the connection
 	Key []byte
+
+	// ImageID is the ID of the image layer the executor filesystem is derived from
+	ImageID string
+
+	// ImageName is the image reference as supplied when the executor was created
+	ImageName string
+
+	// Labels are the freeform key/value pairs supplied when the executor was created
+	Labels map[string]string
+
+	// Created is the time the executor was created, in seconds since the epoch
+	Created int64
 }
 
 // Cmd is here because the encoding packages seem to have issues with the full exec.Cmd struct
@@ -127,6 +160,21 @@ type SessionConfig struct {
 	// Allocate a tty or not
 	Tty bool
 
+	// Keep stdin open so that it can be supplied by attached clients
+	OpenStdin bool
+
+	// Close stdin once the first attached client has finished supplying it
+	StdinOnce bool
+
+	// Wait for a client to attach before launching the process so that no output is missed
+	Attach bool
+
+	// ExitStatus is the exit status of the primary process once it has exited
+	ExitStatus int
+
+	// Finished is the time the primary process exited, in seconds since the epoch
+	Finished int64
+
 	// Maps the intent to the signal for this specific app
 	// Signals map[int]int
 
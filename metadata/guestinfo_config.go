@@ -14,9 +14,73 @@
 
 package metadata
 
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
 const key = "vic.configblob"
 
+// ContainerVMKey is the extraConfig key under which the ContainerVM is persisted. It has no
+// guestinfo prefix so that it is not visible to the guest.
+const ContainerVMKey = "vic.containervm"
+
+// VCHConfigKey is the extraConfig key under which the VirtualContainerHostConfigSpec is persisted
+// on the appliance VM. It has no guestinfo prefix so that it is not visible to the guest.
+const VCHConfigKey = "vic.vchconfig"
+
 type ConfigLoader interface {
 	LoadConfig(string) (*ExecutorConfig, error)
 	StoreConfig(*ExecutorConfig) (string, error)
 }
+
+// EncodeContainerVM serializes the ContainerVM for storage in the VM extraConfig
+func EncodeContainerVM(vm *ContainerVM) (string, error) {
+	data, err := json.Marshal(vm)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeContainerVM is the inverse of EncodeContainerVM
+func DecodeContainerVM(blob string) (*ContainerVM, error) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := &ContainerVM{}
+	if err = json.Unmarshal(data, vm); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// EncodeVCHConfig serializes the VirtualContainerHostConfigSpec for storage in the appliance VM
+// extraConfig
+func EncodeVCHConfig(config *VirtualContainerHostConfigSpec) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeVCHConfig is the inverse of EncodeVCHConfig
+func DecodeVCHConfig(blob string) (*VirtualContainerHostConfigSpec, error) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &VirtualContainerHostConfigSpec{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -98,7 +98,11 @@ type CustomerExperienceImprovementProgram struct {
 
 // Resources is used instead of the ResourceAllocation structs in govmomi as
 // those don't currently hold IO or storage related data.
+// For the size of a VM, the memory limit is its memory in MB.
 type Resources struct {
+	// NumCPUs is the number of vCPUs, where the resources are those of a VM
+	NumCPUs int32
+
 	CPU     types.ResourceAllocationInfo
 	Memory  types.ResourceAllocationInfo
 	IO      types.ResourceAllocationInfo
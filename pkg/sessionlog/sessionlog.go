@@ -0,0 +1,101 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sessionlog defines the format in which the tether records session output and
+// how it is read back. Each line of output is prefixed with the time it was written so
+// that the log can be filtered and annotated when it is served.
+package sessionlog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// TimeFormat is the format of the timestamp prefixed to each line. It is fixed width so that
+// the prefix can be located without scanning.
+const TimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// prefixLen is the length of the timestamp prefix including the separating space
+var prefixLen = len(time.Unix(0, 0).UTC().Format(TimeFormat)) + 1
+
+// Writer prefixes each line written through it with the current time. It is safe for use by
+// multiple goroutines, such as those copying the stdout and stderr of a process.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	// midline is true if the last write did not end with a newline
+	midline bool
+
+	// now is replaceable for testing
+	now func() time.Time
+}
+
+// NewWriter returns a Writer that records to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		now: time.Now,
+	}
+}
+
+// Write prefixes each new line in p with a timestamp before passing it on
+func (t *Writer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var buf bytes.Buffer
+	stamp := t.now().UTC().Format(TimeFormat)
+
+	for rest := p; len(rest) > 0; {
+		if !t.midline {
+			buf.WriteString(stamp)
+			buf.WriteByte(' ')
+		}
+
+		i := bytes.IndexByte(rest, '\n')
+		if i == -1 {
+			buf.Write(rest)
+			t.midline = true
+			break
+		}
+
+		buf.Write(rest[:i+1])
+		rest = rest[i+1:]
+		t.midline = false
+	}
+
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ParseLine splits a line from the session log into its timestamp and content. Lines that
+// don't carry a timestamp are returned whole with a zero time.
+func ParseLine(line []byte) (time.Time, []byte) {
+	if len(line) < prefixLen || line[prefixLen-1] != ' ' {
+		return time.Time{}, line
+	}
+
+	stamp, err := time.Parse(TimeFormat, string(line[:prefixLen-1]))
+	if err != nil {
+		return time.Time{}, line
+	}
+
+	return stamp, line[prefixLen:]
+}
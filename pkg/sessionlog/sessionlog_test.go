@@ -0,0 +1,70 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessionlog
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	stamp := time.Date(2016, 5, 1, 12, 0, 0, 5, time.UTC)
+	w.now = func() time.Time { return stamp }
+
+	// a line split across writes should only be stamped once
+	writes := []string{"hello ", "world\nsecond", " line\n", "\n"}
+	for _, s := range writes {
+		n, err := w.Write([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(s) {
+			t.Errorf("Expected %d bytes written, got %d", len(s), n)
+		}
+	}
+
+	expected := []string{"hello world", "second line", ""}
+	scanner := bufio.NewScanner(&buf)
+	for i := 0; scanner.Scan(); i++ {
+		if i >= len(expected) {
+			t.Fatalf("Unexpected line: %q", scanner.Text())
+		}
+
+		when, content := ParseLine(scanner.Bytes())
+		if !when.Equal(stamp) {
+			t.Errorf("Expected time %s, got %s", stamp, when)
+		}
+		if string(content) != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], content)
+		}
+	}
+}
+
+func TestParseLineWithoutTimestamp(t *testing.T) {
+	for _, line := range []string{"", "short", "not a timestamp at all but long enough to be one"} {
+		when, content := ParseLine([]byte(line))
+		if !when.IsZero() {
+			t.Errorf("Expected zero time for %q, got %s", line, when)
+		}
+		if string(content) != line {
+			t.Errorf("Expected %q back, got %q", line, content)
+		}
+	}
+}
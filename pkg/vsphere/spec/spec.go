@@ -67,6 +67,9 @@ type VirtualMachineConfigSpecConfig struct {
 
 	// Temporary
 	Metadata metadata.ExecutorConfig
+
+	// The port layer's view of the container, not visible to the guest
+	ContainerVM *metadata.ContainerVM
 }
 
 // VirtualMachineConfigSpec type
@@ -102,10 +105,13 @@ func NewVirtualMachineConfigSpec(ctx context.Context, session *session.Session,
 		Bytes:   x509.MarshalPKCS1PrivateKey(privateKey),
 	}
 
-	log.Debugf("Adding metadata to the configspec: %+v", config.Metadata)
+	// the host key is left out of the log
+	logged := config.Metadata
+	logged.Key = nil
+	log.Debugf("Adding metadata to the configspec: %+v", logged)
 	// TEMPORARY
 
-	metadata, err := metadata.New().StoreConfig(&config.Metadata)
+	configblob, err := metadata.New().StoreConfig(&config.Metadata)
 	if err != nil {
 		log.Errorf("failed to marshal container metadata: %s", err)
 		return nil, err
@@ -155,10 +161,20 @@ func NewVirtualMachineConfigSpec(ctx context.Context, session *session.Session,
 			&types.OptionValue{Key: "tools.upgrade.policy", Value: "manual"},
 
 			// TEMPORARY
-			&types.OptionValue{Key: "guestInfo.vic.configblob", Value: metadata},
+			&types.OptionValue{Key: "guestInfo.vic.configblob", Value: configblob},
 		},
 	}
 
+	if config.ContainerVM != nil {
+		vmblob, err := metadata.EncodeContainerVM(config.ContainerVM)
+		if err != nil {
+			log.Errorf("failed to marshal container VM metadata: %s", err)
+			return nil, err
+		}
+
+		spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: metadata.ContainerVMKey, Value: vmblob})
+	}
+
 	return &VirtualMachineConfigSpec{
 		Session:                  session,
 		VirtualMachineConfigSpec: spec,
@@ -0,0 +1,283 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attach
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vmware/vic/cmd/tether/scp"
+)
+
+// maxListedEntries bounds how many copied entries an ArchiveError names
+const maxListedEntries = 10
+
+// implicitDirMode is the mode of directories created for tar entries whose parents aren't in the
+// archive
+const implicitDirMode = os.FileMode(0755)
+
+// ArchiveError is returned when an archive was only partly copied into a container. Copied names
+// the archive entries that were sent to the container before the copy stopped, and Invalid is set
+// if it stopped because of the content of the archive rather than a failure to write it.
+type ArchiveError struct {
+	Err     error
+	Copied  []string
+	Invalid bool
+}
+
+func (e *ArchiveError) Error() string {
+	if len(e.Copied) == 0 {
+		return fmt.Sprintf("%s, nothing was copied", e.Err)
+	}
+
+	listed := e.Copied
+	if len(listed) > maxListedEntries {
+		listed = listed[:maxListedEntries]
+	}
+
+	msg := fmt.Sprintf("%s, %d entries were copied before stopping: %s", e.Err, len(e.Copied), strings.Join(listed, ", "))
+	if len(listed) < len(e.Copied) {
+		msg += fmt.Sprintf(" and %d more", len(e.Copied)-len(listed))
+	}
+	return msg
+}
+
+// scpToTar converts the scp records read from r into tar entries named by their place in the
+// copied tree. scp doesn't carry modification times, so entries are stamped with the time of the
+// copy.
+func scpToTar(r io.Reader, tw *tar.Writer) error {
+	rdr := bufio.NewReader(r)
+	now := time.Now()
+
+	var dirs []string
+	for {
+		header, err := rdr.ReadString('\n')
+		if err == io.EOF && header == "" {
+			if len(dirs) > 0 {
+				return fmt.Errorf("copy ended within %s", dirs[len(dirs)-1])
+			}
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+
+		op, err := scp.Unmarshal(header)
+		if err != nil {
+			return err
+		}
+
+		switch header[0:1] {
+		case string(scp.BEGIN_FILE), string(scp.BEGIN_FOLDER):
+		case string(scp.END_FOLDER):
+			if len(dirs) == 0 {
+				return fmt.Errorf("end of folder outside of a folder")
+			}
+			dirs = dirs[:len(dirs)-1]
+			continue
+		default:
+			continue
+		}
+
+		if op.Name == "" || op.Name == "." || op.Name == ".." || strings.ContainsRune(op.Name, '/') {
+			return fmt.Errorf("invalid name %q", op.Name)
+		}
+
+		name := op.Name
+		if len(dirs) > 0 {
+			name = path.Join(dirs[len(dirs)-1], op.Name)
+		}
+
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(op.Mode.Perm()),
+			ModTime: now,
+		}
+
+		if op.Mode.IsDir() {
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+
+			dirs = append(dirs, name)
+			continue
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = op.Size
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err = io.CopyN(tw, rdr, op.Size); err != nil {
+			return err
+		}
+	}
+}
+
+// tarToScp converts the regular files and directories in the archive read from tr into scp
+// records within a folder record for the extraction point, named by base and created with mode.
+// Parent directories missing from the archive are created. If the archive can't be read or holds
+// anything else, the records written so far are closed off so that they form a complete copy,
+// and an ArchiveError naming the entries sent is returned. The names of the entries sent are
+// also returned.
+func tarToScp(tr *tar.Reader, w io.Writer, base string, mode os.FileMode) ([]string, error) {
+	var copied []string
+
+	// dirs holds the directories currently open below the extraction point
+	var dirs []string
+
+	writeRecord := func(op *scp.Operation) error {
+		_, err := io.WriteString(w, op.String())
+		return err
+	}
+	endFolder := func() error {
+		_, err := io.WriteString(w, string(scp.END_FOLDER)+"\n")
+		return err
+	}
+
+	// closeDirs ends the open directories until only the first n remain open
+	closeDirs := func(n int) error {
+		for len(dirs) > n {
+			if err := endFolder(); err != nil {
+				return err
+			}
+			dirs = dirs[:len(dirs)-1]
+		}
+		return nil
+	}
+
+	// openDir makes the directory named by parts the innermost open one, creating it with mode
+	// if it isn't open already
+	openDir := func(parts []string, mode os.FileMode) error {
+		n := 0
+		for n < len(dirs) && n < len(parts) && dirs[n] == parts[n] {
+			n++
+		}
+		if err := closeDirs(n); err != nil {
+			return err
+		}
+
+		for i := n; i < len(parts); i++ {
+			dirMode := implicitDirMode
+			if i == len(parts)-1 {
+				dirMode = mode
+			}
+			if err := writeRecord(&scp.Operation{Mode: dirMode | os.ModeDir, Name: parts[i]}); err != nil {
+				return err
+			}
+			dirs = append(dirs, parts[i])
+		}
+		return nil
+	}
+
+	// stop closes off the copy and reports the reason if the records could be completed
+	stop := func(err error) ([]string, error) {
+		if cerr := closeDirs(0); cerr != nil {
+			return copied, &ArchiveError{Err: cerr, Copied: copied}
+		}
+		if cerr := endFolder(); cerr != nil {
+			return copied, &ArchiveError{Err: cerr, Copied: copied}
+		}
+		return copied, &ArchiveError{Err: err, Copied: copied, Invalid: true}
+	}
+
+	if err := writeRecord(&scp.Operation{Mode: mode | os.ModeDir, Name: base}); err != nil {
+		return nil, &ArchiveError{Err: err}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stop(fmt.Errorf("invalid archive: %s", err))
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." {
+			continue
+		}
+
+		parts := strings.Split(name, "/")
+		for _, part := range parts {
+			if part == ".." {
+				return stop(fmt.Errorf("invalid archive entry %s: it is outside of the extraction point", hdr.Name))
+			}
+		}
+
+		entryMode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			// a directory opened already as the parent of an earlier entry is left as it is
+			err = openDir(parts, entryMode)
+		case tar.TypeReg, tar.TypeRegA:
+			if err = openDir(parts[:len(parts)-1], implicitDirMode); err != nil {
+				break
+			}
+			if err = writeRecord(&scp.Operation{Mode: entryMode, Size: hdr.Size, Name: parts[len(parts)-1]}); err != nil {
+				break
+			}
+
+			content := &readErrorRecorder{Reader: tr}
+			if _, err = io.CopyN(w, content, hdr.Size); content.err != nil {
+				// the file's record can't be completed, so the copy of it is left short
+				return copied, &ArchiveError{Err: fmt.Errorf("invalid archive: %s is truncated", hdr.Name), Copied: copied, Invalid: true}
+			}
+		default:
+			return stop(fmt.Errorf("unsupported archive entry %s: only regular files and directories can be copied", hdr.Name))
+		}
+
+		if err != nil {
+			return copied, &ArchiveError{Err: err, Copied: copied}
+		}
+		copied = append(copied, hdr.Name)
+	}
+
+	if err := closeDirs(0); err != nil {
+		return copied, &ArchiveError{Err: err, Copied: copied}
+	}
+	if err := endFolder(); err != nil {
+		return copied, &ArchiveError{Err: err, Copied: copied}
+	}
+	return copied, nil
+}
+
+// readErrorRecorder keeps the error from its reader, to tell it apart from errors writing what
+// was read. It's read through a limit, so it's only read while content is expected and reaching
+// the end without reading anything means the content is short.
+type readErrorRecorder struct {
+	io.Reader
+	err error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && (err != io.EOF || n == 0) {
+		r.err = err
+	}
+	return n, err
+}
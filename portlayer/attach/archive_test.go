@@ -0,0 +1,170 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attach
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+}
+
+func writeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Size:     int64(len(e.content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestScpToTar(t *testing.T) {
+	records := "D0750 0 dir\nC0640 5 a\nhelloD0700 0 sub\nC0600 0 b\nE\nE\n"
+
+	buf := &bytes.Buffer{}
+	if err := scpToTar(strings.NewReader(records), tar.NewWriter(buf)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []tarEntry{
+		{"dir/", tar.TypeDir, 0750, ""},
+		{"dir/a", tar.TypeReg, 0640, "hello"},
+		{"dir/sub/", tar.TypeDir, 0700, ""},
+		{"dir/sub/b", tar.TypeReg, 0600, ""},
+	}
+
+	tr := tar.NewReader(buf)
+	for _, e := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Expected %s: %s", e.name, err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := tarEntry{hdr.Name, hdr.Typeflag, hdr.Mode, string(content)}
+		if got != e {
+			t.Errorf("Expected %+v, got %+v", e, got)
+		}
+	}
+	if hdr, err := tr.Next(); err == nil {
+		t.Errorf("Unexpected entry %s", hdr.Name)
+	}
+
+	if err := scpToTar(strings.NewReader("D0750 0 dir\nC0640 0 a\n"), tar.NewWriter(ioutil.Discard)); err == nil {
+		t.Errorf("Expected an error for records ending within a directory")
+	}
+}
+
+func TestTarToScp(t *testing.T) {
+	archive := writeTar(t, []tarEntry{
+		{"./", tar.TypeDir, 0755, ""},
+		{"./a", tar.TypeReg, 0640, "hello"},
+		{"sub/deep/b", tar.TypeReg, 0600, "hi"},
+		{"sub/deep/", tar.TypeDir, 0700, ""},
+		{"other/", tar.TypeDir, 0750, ""},
+	})
+
+	out := &bytes.Buffer{}
+	copied, err := tarToScp(tar.NewReader(archive), out, "dest", 0711)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "D0711 0 dest\nC0640 5 a\nhelloD0755 0 sub\nD0755 0 deep\nC0600 2 b\nhiE\nE\nD0750 0 other\nE\nE\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+	if strings.Join(copied, "|") != "./a|sub/deep/b|sub/deep/|other/" {
+		t.Errorf("Unexpected copied entries %q", copied)
+	}
+
+	// the copy stops at an unsupported entry, with the records completed
+	archive = writeTar(t, []tarEntry{
+		{"sub/a", tar.TypeReg, 0640, "hello"},
+		{"sub/link", tar.TypeSymlink, 0777, ""},
+		{"b", tar.TypeReg, 0640, "not copied"},
+	})
+
+	out.Reset()
+	copied, err = tarToScp(tar.NewReader(archive), out, "dest", 0755)
+	aerr, ok := err.(*ArchiveError)
+	if !ok || !aerr.Invalid {
+		t.Fatalf("Expected an invalid archive error, got %#v", err)
+	}
+	if len(copied) != 1 || len(aerr.Copied) != 1 || aerr.Copied[0] != "sub/a" {
+		t.Errorf("Unexpected copied entries %q", aerr.Copied)
+	}
+	if !strings.Contains(aerr.Error(), "sub/link") || !strings.Contains(aerr.Error(), "sub/a") {
+		t.Errorf("Expected the error to name the entries, got %q", aerr.Error())
+	}
+
+	expected = "D0755 0 dest\nD0755 0 sub\nC0640 5 a\nhelloE\nE\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	// an archive ending within a file leaves the record short
+	archive = writeTar(t, []tarEntry{{"big", tar.TypeReg, 0640, strings.Repeat("x", 1000)}})
+	archive.Truncate(600)
+	_, err = tarToScp(tar.NewReader(archive), ioutil.Discard, "dest", 0755)
+	if aerr, ok = err.(*ArchiveError); !ok || !aerr.Invalid || !strings.Contains(aerr.Error(), "truncated") {
+		t.Errorf("Expected a truncated archive error, got %#v", err)
+	}
+
+	archive = writeTar(t, []tarEntry{{"../escape", tar.TypeReg, 0640, "x"}})
+	if _, err = tarToScp(tar.NewReader(archive), ioutil.Discard, "dest", 0755); err == nil {
+		t.Errorf("Expected an error for an entry outside of the extraction point")
+	}
+}
+
+func TestArchiveError(t *testing.T) {
+	err := &ArchiveError{Err: errors.New("failed")}
+	if err.Error() != "failed, nothing was copied" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+
+	for i := 0; i < maxListedEntries+2; i++ {
+		err.Copied = append(err.Copied, "f")
+	}
+	if !strings.HasSuffix(err.Error(), " and 2 more") {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
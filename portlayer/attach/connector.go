@@ -0,0 +1,567 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attach
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/cmd/tether/msgs"
+	"github.com/vmware/vic/cmd/tether/scp"
+	"github.com/vmware/vic/cmd/tether/serial"
+	"github.com/vmware/vic/pkg/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+)
+
+// errNoCopyResult is returned when an scp channel closes before the tether reports the outcome of
+// the copy
+var errNoCopyResult = errors.New("copy ended without a result")
+
+// handshakeInterval is the interval between handshake attempts with a tether
+const handshakeInterval = 10 * time.Second
+
+// HostKeyLookup returns the host key the tether in the container must present on the backchannel
+type HostKeyLookup func(containerID string) (ssh.PublicKey, error)
+
+// ExitHandler is called when a tether reports that a session has exited. The report is only
+// acknowledged to the tether if the handler returns without error.
+type ExitHandler func(containerID string, exit *msgs.ExitMsg) error
+
+// Connector accepts the serial-over-LAN connections from container VMs and tracks the
+// resulting ssh connections to the tethers by container ID
+type Connector struct {
+	mutex sync.Mutex
+
+	listener    net.Listener
+	connections map[string]*Connection
+	hostKeys    HostKeyLookup
+	onExit      ExitHandler
+
+	// changed is closed and replaced whenever a connection is added so that waiters can
+	// re-check for the connection they're interested in
+	changed chan struct{}
+}
+
+// Connection is an ssh connection to the tether in a specific container
+type Connection struct {
+	ID string
+
+	conn ssh.Conn
+}
+
+// NewConnector returns a Connector that will accept tether connections on the listener. A tether
+// is only accepted for the containers whose host key, as returned by hostKeys, it presented.
+// onExit may be nil if session exits are of no interest.
+func NewConnector(listener net.Listener, hostKeys HostKeyLookup, onExit ExitHandler) *Connector {
+	return &Connector{
+		listener:    listener,
+		connections: make(map[string]*Connection),
+		hostKeys:    hostKeys,
+		onExit:      onExit,
+		changed:     make(chan struct{}),
+	}
+}
+
+// Start begins accepting connections in the background
+func (c *Connector) Start() {
+	go c.serve()
+}
+
+// Stop closes the listener and all current connections
+func (c *Connector) Stop() {
+	c.listener.Close()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, conn := range c.connections {
+		conn.conn.Close()
+		delete(c.connections, id)
+	}
+}
+
+// Get returns the connection for the container, waiting up to timeout for the tether
+// to connect if it hasn't already
+func (c *Connector) Get(ctx context.Context, id string, timeout time.Duration) (*Connection, error) {
+	defer trace.End(trace.Begin(id))
+
+	deadline := time.After(timeout)
+	for {
+		c.mutex.Lock()
+		conn := c.connections[id]
+		changed := c.changed
+		c.mutex.Unlock()
+
+		if conn != nil {
+			return conn, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, fmt.Errorf("no connection to container %s", id)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Connector) serve() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			log.Errorf("Connector stopped accepting connections: %s", err)
+			return
+		}
+
+		go c.processIncoming(conn)
+	}
+}
+
+// processIncoming performs the serial handshake and establishes the ssh connection with
+// the tether on the other end of conn
+func (c *Connector) processIncoming(conn net.Conn) {
+	log.Debugf("Processing incoming connection from %s", conn.RemoteAddr())
+
+	// the VM may connect the serial port well before the tether is ready, so keep trying
+	// until the handshake succeeds or the connection fails outright
+	for {
+		err := serial.HandshakeClient(conn, handshakeInterval)
+		if err == nil {
+			break
+		}
+
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			continue
+		}
+
+		log.Errorf("Handshake with %s failed: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	// the containers the tether speaks for are only known once connected, so the key it presents
+	// is checked against theirs then
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "daemon",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return nil
+		},
+	}
+
+	sconn, chans, reqs, err := ssh.NewClientConn(conn, "", config)
+	if err != nil {
+		log.Errorf("Failed to establish ssh connection with %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "channels cannot be opened by the tether")
+		}
+	}()
+
+	ok, payload, err := sconn.SendRequest(msgs.ContainersReq, true, nil)
+	if !ok || err != nil {
+		log.Errorf("Failed to get container IDs from %s: ok=%t, err=%s", conn.RemoteAddr(), ok, err)
+		sconn.Close()
+		return
+	}
+
+	ids := &msgs.ContainersMsg{}
+	if err = msgs.Unmarshal(payload, ids); err != nil {
+		log.Errorf("Failed to unmarshal container IDs from %s: %s", conn.RemoteAddr(), err)
+		sconn.Close()
+		return
+	}
+
+	if len(ids.IDs) == 0 {
+		log.Errorf("No container IDs reported by %s", conn.RemoteAddr())
+		sconn.Close()
+		return
+	}
+
+	for _, id := range ids.IDs {
+		if err = c.verifyHostKey(id, hostKey); err != nil {
+			log.Errorf("Rejecting connection from %s: %s", conn.RemoteAddr(), err)
+			sconn.Close()
+			return
+		}
+	}
+
+	// the executor ID comes first
+	go c.processRequests(ids.IDs[0], reqs)
+
+	c.mutex.Lock()
+	for _, id := range ids.IDs {
+		log.Infof("Established connection with container %s", id)
+		c.connections[id] = &Connection{ID: id, conn: sconn}
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mutex.Unlock()
+
+	// drop the connection from the table when the tether goes away
+	go func() {
+		sconn.Wait()
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for _, id := range ids.IDs {
+			if conn, ok := c.connections[id]; ok && conn.conn == sconn {
+				log.Infof("Lost connection with container %s", id)
+				delete(c.connections, id)
+			}
+		}
+	}()
+}
+
+// verifyHostKey checks that the host key presented by a tether is the one stored for the
+// container it claims to be
+func (c *Connector) verifyHostKey(id string, key ssh.PublicKey) error {
+	expected, err := c.hostKeys(id)
+	if err != nil {
+		return fmt.Errorf("no host key for container %s: %s", id, err)
+	}
+
+	if key == nil || !bytes.Equal(key.Marshal(), expected.Marshal()) {
+		return fmt.Errorf("host key mismatch for container %s", id)
+	}
+
+	return nil
+}
+
+// processRequests handles the requests initiated by the tether in the container
+func (c *Connector) processRequests(id string, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok := false
+
+		switch req.Type {
+		case msgs.ExitReq:
+			msg := &msgs.ExitMsg{}
+			if err := msgs.Unmarshal(req.Payload, msg); err != nil {
+				log.Errorf("Failed to unmarshal exit report from %s: %s", id, err)
+				break
+			}
+
+			log.Infof("Session %s in container %s exited with status %d", msg.ID, id, msg.ExitStatus)
+			if c.onExit == nil {
+				ok = true
+				break
+			}
+
+			if err := c.onExit(id, msg); err != nil {
+				log.Errorf("Failed to handle exit of session %s in container %s: %s", msg.ID, id, err)
+				break
+			}
+			ok = true
+		default:
+			log.Warnf("Ignoring unsupported request %s from %s", req.Type, id)
+		}
+
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+// Signal asks the tether to deliver the signal to the process of the specified session
+func (c *Connection) Signal(sessionID string, signal int64) error {
+	defer trace.End(trace.Begin(sessionID))
+
+	msg := &msgs.SignalMsg{
+		ID:     sessionID,
+		Signal: uint32(signal),
+	}
+
+	ok, _, err := c.conn.SendRequest(msgs.SignalReq, true, msgs.Marshal(msg))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("failed to deliver signal %d to %s", signal, sessionID)
+	}
+
+	return nil
+}
+
+// Reload pushes the encoded executor config to the tether and asks it to reload, launching any
+// sessions it hasn't already
+func (c *Connection) Reload(config string) error {
+	defer trace.End(trace.Begin(c.ID))
+
+	ok, _, err := c.conn.SendRequest(msgs.ReloadReq, true, msgs.Marshal(&msgs.ReloadMsg{Config: config}))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("container %s refused to reload", c.ID)
+	}
+
+	return nil
+}
+
+// Freeze stops all of the processes in the container, leaving the container VM running
+func (c *Connection) Freeze() error {
+	return c.sendFreeze(msgs.FreezeReq)
+}
+
+// Thaw continues the processes stopped by Freeze
+func (c *Connection) Thaw() error {
+	return c.sendFreeze(msgs.ThawReq)
+}
+
+func (c *Connection) sendFreeze(reqType string) error {
+	defer trace.End(trace.Begin(reqType))
+
+	ok, payload, err := c.conn.SendRequest(reqType, true, nil)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("failed to %s processes in %s: %s", reqType, c.ID, payload)
+	}
+
+	return nil
+}
+
+// Attach opens a channel to the streams of the specified session. Session output is read from
+// the channel, with stderr as extended data unless the session has a tty, and writes to the
+// channel are passed to the session stdin.
+func (c *Connection) Attach(sessionID string) (ssh.Channel, error) {
+	defer trace.End(trace.Begin(sessionID))
+
+	ch, reqs, err := c.conn.OpenChannel(msgs.AttachChannel, msgs.Marshal(&msgs.AttachMsg{ID: sessionID}))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+
+	return ch, nil
+}
+
+// Stat describes the path in the container filesystem, without following symlinks. An error
+// satisfying os.IsNotExist is returned if there's nothing at the path.
+func (c *Connection) Stat(path string) (*msgs.StatResultMsg, error) {
+	defer trace.End(trace.Begin(path))
+
+	ok, payload, err := c.conn.SendRequest(msgs.StatReq, true, msgs.Marshal(&msgs.StatMsg{Path: path}))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("failed to stat %s in %s: %s", path, c.ID, payload)
+	}
+
+	result := &msgs.StatResultMsg{}
+	if err = msgs.Unmarshal(payload, result); err != nil {
+		return nil, err
+	}
+
+	if result.NotFound {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+
+	return result, nil
+}
+
+// Top lists the processes in the container in the format selected by the ps arguments, returning
+// the column titles and a row of values per process
+func (c *Connection) Top(psArgs string) ([]string, [][]string, error) {
+	defer trace.End(trace.Begin(psArgs))
+
+	ok, payload, err := c.conn.SendRequest(msgs.TopReq, true, msgs.Marshal(&msgs.TopMsg{Args: psArgs}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !ok {
+		return nil, nil, fmt.Errorf("failed to list processes in %s: %s", c.ID, payload)
+	}
+
+	result := &msgs.TopResultMsg{}
+	if err = msgs.Unmarshal(payload, result); err != nil {
+		return nil, nil, err
+	}
+
+	titles, processes := parseProcessTable(result.Output)
+	if titles == nil {
+		return nil, nil, fmt.Errorf("empty process list from %s", c.ID)
+	}
+
+	return titles, processes, nil
+}
+
+// parseProcessTable splits ps output into the column titles and the values of each process. Only
+// the last column may contain spaces, so it takes whatever remains of the line.
+func parseProcessTable(output string) ([]string, [][]string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	titles := strings.Fields(lines[0])
+	if len(titles) == 0 {
+		return nil, nil
+	}
+
+	processes := [][]string{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(titles) {
+			// only the command can be empty
+			if len(fields) != len(titles)-1 {
+				continue
+			}
+			fields = append(fields, "")
+		}
+
+		last := len(titles) - 1
+		processes = append(processes, append(fields[:last], strings.Join(fields[last:], " ")))
+	}
+
+	return titles, processes
+}
+
+// ArchiveFrom copies the regular file or directory at the path out of the container as a tar
+// archive, read from the returned reader, which must be closed once done with. Directories are
+// copied with the regular files and directories in them.
+func (c *Connection) ArchiveFrom(path string) (io.ReadCloser, error) {
+	defer trace.End(trace.Begin(path))
+
+	ch, reqs, err := c.openScp(msgs.ScpSourceReq, path)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer ch.Close()
+
+		tw := tar.NewWriter(pw)
+		if err := scpToTar(ch, tw); err != nil {
+			// the tether may be blocked writing, so it's cut off before looking for its outcome. A
+			// failure it reported is the cause of the records ending early.
+			ch.Close()
+			if werr := c.waitCopy(reqs); werr != nil && werr != errNoCopyResult {
+				err = werr
+			}
+			pw.CloseWithError(fmt.Errorf("failed to read %s in %s: %s", path, c.ID, err))
+			return
+		}
+
+		if err := c.waitCopy(reqs); err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to read %s in %s: %s", path, c.ID, err))
+			return
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// ArchiveTo extracts the tar archive read from r into the existing directory at the path in the
+// container, which is given the mode. Only regular files and directories can be extracted. A
+// directory in the archive replaces a file at its path, and a file a directory, if replace is
+// set. If not, the extraction stops there. If the archive is only partly extracted the error is
+// an ArchiveError naming what was copied.
+func (c *Connection) ArchiveTo(path string, mode os.FileMode, r io.Reader, replace bool) error {
+	defer trace.End(trace.Begin(path))
+
+	reqType := msgs.ScpDestReq
+	if replace {
+		reqType = msgs.ScpDestReplaceReq
+	}
+
+	ch, reqs, err := c.openScp(reqType, path)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	copied, err := tarToScp(tar.NewReader(r), ch, filepath.Base(path), mode)
+	ch.CloseWrite()
+
+	werr := c.waitCopy(reqs)
+	if aerr, ok := err.(*ArchiveError); ok && aerr.Invalid {
+		// the tether fails if the archive ended within a file, but the archive is the cause
+		return err
+	}
+	if werr != nil {
+		return &ArchiveError{Err: fmt.Errorf("failed to write %s in %s: %s", path, c.ID, werr), Copied: copied}
+	}
+	return err
+}
+
+// waitCopy waits for the tether to report the outcome of a copy on the scp channel
+func (c *Connection) waitCopy(reqs <-chan *ssh.Request) error {
+	for req := range reqs {
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+
+		if req.Type != scp.ExitStatusReq {
+			continue
+		}
+
+		status := &scp.ExitStatusMsg{}
+		if err := ssh.Unmarshal(req.Payload, status); err != nil {
+			return err
+		}
+		if status.Status != 0 {
+			return fmt.Errorf("copy failed with status %d", status.Status)
+		}
+		return nil
+	}
+
+	return errNoCopyResult
+}
+
+// openScp opens an scp channel to the tether and sends the request naming the path
+func (c *Connection) openScp(reqType, path string) (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := c.conn.OpenChannel(msgs.ScpChannel, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok, err := ch.SendRequest(reqType, true, []byte(path))
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	if !ok {
+		// the tether puts the reason in the reply, but ssh doesn't pass channel request payloads on
+		ch.Close()
+		return nil, nil, fmt.Errorf("container %s refused to copy %s", c.ID, path)
+	}
+
+	return ch, reqs, nil
+}
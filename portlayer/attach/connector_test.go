@@ -0,0 +1,237 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attach
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/vic/cmd/tether/msgs"
+	"github.com/vmware/vic/cmd/tether/serial"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+)
+
+// mockTether plays the tether side of the backchannel and records the requests it receives
+type mockTether struct {
+	id   string
+	key  ssh.Signer
+	reqs chan *ssh.Request
+
+	// conn is set once the ssh connection is established
+	conn chan ssh.Conn
+}
+
+func (m *mockTether) run(t *testing.T, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	serial.HandshakeServer(conn, time.Second)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(m.key)
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if m.conn != nil {
+		m.conn <- sconn
+	}
+
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}()
+
+	for req := range reqs {
+		switch req.Type {
+		case msgs.ContainersReq:
+			req.Reply(true, msgs.Marshal(&msgs.ContainersMsg{IDs: []string{m.id}}))
+		default:
+			req.Reply(true, nil)
+			m.reqs <- req
+		}
+	}
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// testConnector returns a started connector that expects the tethers to present the host keys
+// of the given signers, keyed by container ID
+func testConnector(t *testing.T, keys map[string]ssh.Signer, onExit ExitHandler) *Connector {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKeys := func(id string) (ssh.PublicKey, error) {
+		key, ok := keys[id]
+		if !ok {
+			return nil, fmt.Errorf("no such container %s", id)
+		}
+		return key.PublicKey(), nil
+	}
+
+	connector := NewConnector(listener, hostKeys, onExit)
+	connector.Start()
+
+	return connector
+}
+
+func TestGetTimeout(t *testing.T) {
+	connector := testConnector(t, nil, nil)
+	defer connector.Stop()
+
+	_, err := connector.Get(context.Background(), "deadbeef", 100*time.Millisecond)
+	if err == nil {
+		t.Error("Expected error getting connection for absent container")
+	}
+}
+
+func TestSignal(t *testing.T) {
+	key := newSigner(t)
+	connector := testConnector(t, map[string]ssh.Signer{"deadbeef": key}, nil)
+	defer connector.Stop()
+
+	tether := &mockTether{
+		id:   "deadbeef",
+		key:  key,
+		reqs: make(chan *ssh.Request, 1),
+	}
+	go tether.run(t, connector.listener.Addr().String())
+
+	conn, err := connector.Get(context.Background(), "deadbeef", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.Signal("feebdaed", 15); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-tether.reqs
+	if req.Type != msgs.SignalReq {
+		t.Fatalf("Expected %s request, got %s", msgs.SignalReq, req.Type)
+	}
+
+	msg := &msgs.SignalMsg{}
+	if err = msgs.Unmarshal(req.Payload, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.ID != "feebdaed" || msg.Signal != 15 {
+		t.Errorf("Unexpected signal request: %+v", msg)
+	}
+}
+
+func TestExit(t *testing.T) {
+	exits := make(chan *msgs.ExitMsg, 1)
+	key := newSigner(t)
+	connector := testConnector(t, map[string]ssh.Signer{"deadbeef": key}, func(id string, exit *msgs.ExitMsg) error {
+		if id != "deadbeef" {
+			t.Errorf("Expected exit from deadbeef, got %s", id)
+		}
+		exits <- exit
+		return nil
+	})
+	defer connector.Stop()
+
+	tether := &mockTether{
+		id:   "deadbeef",
+		key:  key,
+		reqs: make(chan *ssh.Request, 1),
+		conn: make(chan ssh.Conn, 1),
+	}
+	go tether.run(t, connector.listener.Addr().String())
+
+	if _, err := connector.Get(context.Background(), "deadbeef", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := <-tether.conn
+	msg := &msgs.ExitMsg{ID: "feebdaed", ExitStatus: 3, Finished: 1462000000}
+	ok, _, err := conn.SendRequest(msgs.ExitReq, true, msgs.Marshal(msg))
+	if err != nil || !ok {
+		t.Fatalf("Exit report was not acknowledged: ok=%t, err=%s", ok, err)
+	}
+
+	exit := <-exits
+	if *exit != *msg {
+		t.Errorf("Expected %+v, got %+v", msg, exit)
+	}
+}
+
+func TestHostKeyMismatch(t *testing.T) {
+	connector := testConnector(t, map[string]ssh.Signer{"deadbeef": newSigner(t)}, nil)
+	defer connector.Stop()
+
+	// a tether presenting any other key can't claim the container
+	tether := &mockTether{
+		id:   "deadbeef",
+		key:  newSigner(t),
+		reqs: make(chan *ssh.Request, 1),
+	}
+	go tether.run(t, connector.listener.Addr().String())
+
+	if _, err := connector.Get(context.Background(), "deadbeef", 2*time.Second); err == nil {
+		t.Error("Expected no connection for a tether presenting the wrong host key")
+	}
+}
+
+func TestParseProcessTable(t *testing.T) {
+	output := "UID PID PPID CMD\nroot 10   1    /bin/sh -c sleep 1\nroot 12   10   \n\nbad\n"
+
+	titles, processes := parseProcessTable(output)
+	if strings.Join(titles, "|") != "UID|PID|PPID|CMD" {
+		t.Errorf("Unexpected titles: %q", titles)
+	}
+
+	expected := []string{"root|10|1|/bin/sh -c sleep 1", "root|12|10|"}
+	if len(processes) != len(expected) {
+		t.Fatalf("Expected %d processes, got %q", len(expected), processes)
+	}
+	for i, p := range processes {
+		if strings.Join(p, "|") != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], p)
+		}
+	}
+
+	if titles, _ = parseProcessTable(""); titles != nil {
+		t.Errorf("Expected no titles for empty output, got %q", titles)
+	}
+}
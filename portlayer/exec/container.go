@@ -0,0 +1,484 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/metadata"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"golang.org/x/net/context"
+)
+
+// ConfigKey is the extraConfig key under which the executor config is stored
+const ConfigKey = "guestinfo.vic.configblob"
+
+// FrozenKey is the extraConfig key marking a container VM whose processes have been frozen by
+// the tether to pause the container. It isn't visible to the guest.
+const FrozenKey = "vic.frozen"
+
+// State is the coarse state of a container as derived from the container VM
+type State string
+
+const (
+	StateRunning   = State("Running")
+	StateStopped   = State("Stopped")
+	StateSuspended = State("Suspended")
+)
+
+// configMutex serializes read-modify-write updates of executor configs
+var configMutex sync.Mutex
+
+// ErrNotContainer is returned when a VM does not carry executor metadata
+var ErrNotContainer = errors.New("not a container VM")
+
+// ErrNameInUse is returned when a container is renamed to the name of another
+var ErrNameInUse = errors.New("name already in use")
+
+// nameKey is the extraConfig key presenting the container name to the guest
+const nameKey = "guestinfo.docker_name"
+
+// Container is the port layer view of a container VM
+type Container struct {
+	ExecConfig *metadata.ExecutorConfig
+	// VMConfig is nil for containers created before it was persisted
+	VMConfig *metadata.ContainerVM
+	State    State
+
+	// Frozen is set if the container was paused by freezing its processes rather than suspending
+	// the VM. It may be left over from before the container VM was last powered off.
+	Frozen bool
+
+	// PendingResources are resource changes to make when the container is next started, or nil
+	PendingResources *Resources
+
+	Ref types.ManagedObjectReference
+}
+
+// StateFromPowerState maps the VM power state to a container state
+func StateFromPowerState(state types.VirtualMachinePowerState) State {
+	switch state {
+	case types.VirtualMachinePowerStatePoweredOn:
+		return StateRunning
+	case types.VirtualMachinePowerStateSuspended:
+		return StateSuspended
+	default:
+		return StateStopped
+	}
+}
+
+// configOption returns the extraConfig entry holding the executor config, or nil
+func configOption(extraConfig []types.BaseOptionValue) *types.OptionValue {
+	return extraConfigOption(extraConfig, ConfigKey)
+}
+
+// extraConfigOption returns the extraConfig entry with the key, or nil. The key is matched
+// without regard to case as vSphere does not preserve the case it was set with.
+func extraConfigOption(extraConfig []types.BaseOptionValue, key string) *types.OptionValue {
+	for _, opt := range extraConfig {
+		value := opt.GetOptionValue()
+		if strings.EqualFold(value.Key, key) {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// ExecutorConfig extracts the executor config from the VM extraConfig
+func ExecutorConfig(extraConfig []types.BaseOptionValue) (*metadata.ExecutorConfig, error) {
+	value := configOption(extraConfig)
+	if value == nil {
+		return nil, ErrNotContainer
+	}
+
+	blob, ok := value.Value.(string)
+	if !ok {
+		return nil, ErrNotContainer
+	}
+
+	return metadata.New().LoadConfig(blob)
+}
+
+// ContainerVM extracts the port layer container metadata from the VM extraConfig. It returns
+// nil if the VM doesn't carry it.
+func ContainerVM(extraConfig []types.BaseOptionValue) (*metadata.ContainerVM, error) {
+	for _, opt := range extraConfig {
+		value := opt.GetOptionValue()
+		if value.Key != metadata.ContainerVMKey {
+			continue
+		}
+
+		blob, ok := value.Value.(string)
+		if !ok {
+			return nil, nil
+		}
+
+		return metadata.DecodeContainerVM(blob)
+	}
+
+	return nil, nil
+}
+
+// newContainer builds the container from the retrieved VM properties
+func newContainer(vm *mo.VirtualMachine) (*Container, error) {
+	if vm.Config == nil {
+		return nil, ErrNotContainer
+	}
+
+	config, err := ExecutorConfig(vm.Config.ExtraConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	vmconfig, err := ContainerVM(vm.Config.ExtraConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Container{
+		ExecConfig: config,
+		VMConfig:   vmconfig,
+		State:      StateFromPowerState(vm.Runtime.PowerState),
+		Ref:        vm.Reference(),
+	}
+
+	if value := extraConfigOption(vm.Config.ExtraConfig, FrozenKey); value != nil {
+		c.Frozen = value.Value == "true"
+	}
+	c.PendingResources = pendingResources(vm.Config.ExtraConfig)
+
+	// a frozen container is paused as far as anyone else is concerned
+	if c.Frozen && c.State == StateRunning {
+		c.State = StateSuspended
+	}
+
+	return c, nil
+}
+
+// containerProperties are the VM properties needed to construct a Container
+var containerProperties = []string{"config.extraConfig", "runtime.powerState"}
+
+// IsNotFound reports whether the error returned looking up a container means there's no such
+// container, rather than that the lookup failed
+func IsNotFound(err error) bool {
+	if err == ErrNotContainer {
+		return true
+	}
+
+	_, ok := err.(*find.NotFoundError)
+	return ok
+}
+
+// ContainerByID returns the container with the specified ID
+func ContainerByID(ctx context.Context, sess *session.Session, id string) (*Container, error) {
+	defer trace.End(trace.Begin(id))
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var mvm mo.VirtualMachine
+	if err = vm.Properties(ctx, vm.Reference(), containerProperties, &mvm); err != nil {
+		return nil, err
+	}
+
+	return newContainer(&mvm)
+}
+
+// RecordExit persists the exit status and finish time of the session in the executor config of
+// the container VM. The guest cannot update its own configuration, so this is done on its behalf.
+func RecordExit(ctx context.Context, sess *session.Session, id, sessionID string, status int, finished int64) error {
+	defer trace.End(trace.Begin(id))
+
+	return updateExecutorConfig(ctx, sess, id, func(config *metadata.ExecutorConfig) error {
+		s, ok := config.Sessions[sessionID]
+		if !ok {
+			return fmt.Errorf("container %s has no session %s", id, sessionID)
+		}
+		s.ExitStatus = status
+		s.Finished = finished
+
+		return nil
+	})
+}
+
+// PrepareStart readies the executor config of the container for it to be started. Sessions other
+// than the primary, such as those from exec, don't survive a restart so are discarded, and the
+// primary is told whether to wait for a client to attach before it is launched.
+func PrepareStart(ctx context.Context, sess *session.Session, id string, attach bool) error {
+	defer trace.End(trace.Begin(id))
+
+	return updateExecutorConfig(ctx, sess, id, func(config *metadata.ExecutorConfig) error {
+		primary, ok := config.Sessions[config.ID]
+		if !ok {
+			return fmt.Errorf("container %s has no primary session", id)
+		}
+		primary.Attach = attach
+
+		config.Sessions = map[string]*metadata.SessionConfig{
+			config.ID: primary,
+		}
+
+		return nil
+	})
+}
+
+// AddSession adds the session to the executor config of the container. It is launched when the
+// tether next reloads its config.
+func AddSession(ctx context.Context, sess *session.Session, id string, s *metadata.SessionConfig) error {
+	defer trace.End(trace.Begin(id))
+
+	return updateExecutorConfig(ctx, sess, id, func(config *metadata.ExecutorConfig) error {
+		if _, ok := config.Sessions[s.ID]; ok {
+			return fmt.Errorf("container %s already has a session %s", id, s.ID)
+		}
+		config.Sessions[s.ID] = s
+
+		return nil
+	})
+}
+
+// SetFrozen records whether the processes of the container have been frozen
+func SetFrozen(ctx context.Context, sess *session.Session, id string, frozen bool) error {
+	defer trace.End(trace.Begin(id))
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// an empty value removes the key
+	value := ""
+	if frozen {
+		value = "true"
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: FrozenKey, Value: value},
+		},
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+	return err
+}
+
+// Rename changes the name of the container. The name is held in the executor config, in the port
+// layer metadata, where aliases of the container refer to it, and in the name presented to the
+// guest. All of them are updated by a single reconfigure so that they can't disagree if the
+// rename is interrupted. The VM itself is named by the container ID, so is left alone.
+func Rename(ctx context.Context, sess *session.Session, id, name string) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("%s -> %s", id, name)))
+
+	// serialize with other updates, which includes other renames, so that two containers
+	// can't both take the same name
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	containers, err := Containers(ctx, sess, true)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.ExecConfig.Name == name && c.ExecConfig.ID != id {
+			return ErrNameInUse
+		}
+	}
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var mvm mo.VirtualMachine
+	if err = vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &mvm); err != nil {
+		return err
+	}
+
+	if mvm.Config == nil {
+		return ErrNotContainer
+	}
+
+	spec, err := renameSpec(mvm.Config.ExtraConfig, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, *spec)
+	})
+	return err
+}
+
+// renameSpec returns the reconfigure spec that renames the container with the given extraConfig.
+// Entries are written back under the keys they were read from.
+func renameSpec(extraConfig []types.BaseOptionValue, name string) (*types.VirtualMachineConfigSpec, error) {
+	option := configOption(extraConfig)
+	config, err := ExecutorConfig(extraConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	old := config.Name
+	config.Name = name
+
+	blob, err := metadata.New().StoreConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	nameOption := extraConfigOption(extraConfig, nameKey)
+	if nameOption == nil {
+		nameOption = &types.OptionValue{Key: nameKey}
+	}
+
+	spec := &types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: option.Key, Value: blob},
+			&types.OptionValue{Key: nameOption.Key, Value: name},
+		},
+	}
+
+	vmconfig, err := ContainerVM(extraConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// containers created before the port layer metadata was persisted have none to update
+	if vmconfig != nil {
+		vmconfig.Name = name
+		for alias, target := range vmconfig.Aliases {
+			if target == old {
+				vmconfig.Aliases[alias] = name
+			}
+		}
+
+		vmblob, err := metadata.EncodeContainerVM(vmconfig)
+		if err != nil {
+			return nil, err
+		}
+		spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: metadata.ContainerVMKey, Value: vmblob})
+	}
+
+	return spec, nil
+}
+
+// updateExecutorConfig applies update to the executor config of the container VM and stores the
+// result under the key it was read from
+func updateExecutorConfig(ctx context.Context, sess *session.Session, id string, update func(*metadata.ExecutorConfig) error) error {
+	// serialize updates so that concurrent ones, such as an exec and an exit, aren't lost
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var mvm mo.VirtualMachine
+	if err = vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &mvm); err != nil {
+		return err
+	}
+
+	if mvm.Config == nil {
+		return ErrNotContainer
+	}
+
+	option := configOption(mvm.Config.ExtraConfig)
+	config, err := ExecutorConfig(mvm.Config.ExtraConfig)
+	if err != nil {
+		return err
+	}
+
+	if err = update(config); err != nil {
+		return err
+	}
+
+	blob, err := metadata.New().StoreConfig(config)
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: option.Key, Value: blob},
+		},
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+	return err
+}
+
+// Containers returns the containers in the resource pool of the session. Unless all is set
+// only running containers are returned.
+func Containers(ctx context.Context, sess *session.Session, all bool) ([]*Container, error) {
+	defer trace.End(trace.Begin(""))
+
+	var pool mo.ResourcePool
+	if err := sess.Pool.Properties(ctx, sess.Pool.Reference(), []string{"vm"}, &pool); err != nil {
+		return nil, err
+	}
+
+	if len(pool.Vm) == 0 {
+		return nil, nil
+	}
+
+	var vms []mo.VirtualMachine
+	pc := property.DefaultCollector(sess.Vim25())
+	if err := pc.Retrieve(ctx, pool.Vm, containerProperties, &vms); err != nil {
+		return nil, err
+	}
+
+	return containersFromVMs(vms, all), nil
+}
+
+// containersFromVMs returns the containers among the VMs. Unless all is set only running
+// containers are returned, which includes paused ones as docker ps lists them.
+func containersFromVMs(vms []mo.VirtualMachine, all bool) []*Container {
+	var containers []*Container
+	for i := range vms {
+		c, err := newContainer(&vms[i])
+		if err != nil {
+			if err != ErrNotContainer {
+				log.Warnf("Skipping VM %s: %s", vms[i].Reference(), err)
+			}
+			continue
+		}
+
+		if !all && c.State != StateRunning && c.State != StateSuspended {
+			continue
+		}
+
+		containers = append(containers, c)
+	}
+
+	return containers
+}
@@ -0,0 +1,284 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/metadata"
+)
+
+func extraConfig(t *testing.T, config *metadata.ExecutorConfig) []types.BaseOptionValue {
+	blob, err := metadata.New().StoreConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []types.BaseOptionValue{
+		&types.OptionValue{Key: "disk.EnableUUID", Value: "true"},
+		// vSphere may hand the key back with different case to how it was set
+		&types.OptionValue{Key: "guestInfo.vic.configblob", Value: blob},
+	}
+}
+
+func TestExecutorConfig(t *testing.T) {
+	config := &metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "deadbeef",
+			Name: "test_container",
+		},
+		ImageID:   "bc744c4ab376",
+		ImageName: "busybox",
+		Labels:    map[string]string{"tier": "web"},
+		Created:   1462000000,
+	}
+
+	loaded, err := ExecutorConfig(extraConfig(t, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.ID != config.ID || loaded.Name != config.Name || loaded.ImageName != config.ImageName ||
+		loaded.Created != config.Created || loaded.Labels["tier"] != "web" {
+		t.Errorf("Loaded config does not match stored config: %+v", loaded)
+	}
+
+	_, err = ExecutorConfig([]types.BaseOptionValue{&types.OptionValue{Key: "disk.EnableUUID", Value: "true"}})
+	if err != ErrNotContainer {
+		t.Errorf("Expected ErrNotContainer, got %s", err)
+	}
+}
+
+func TestNewContainer(t *testing.T) {
+	config := &metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID: "deadbeef",
+		},
+	}
+
+	vmblob, err := metadata.EncodeContainerVM(&metadata.ContainerVM{
+		Common:  metadata.Common{ID: "deadbeef"},
+		Aliases: map[string]string{"db": "feebdaed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &mo.VirtualMachine{
+		Config: &types.VirtualMachineConfigInfo{
+			ExtraConfig: append(extraConfig(t, config), &types.OptionValue{Key: metadata.ContainerVMKey, Value: vmblob}),
+		},
+		Runtime: types.VirtualMachineRuntimeInfo{
+			PowerState: types.VirtualMachinePowerStateSuspended,
+		},
+	}
+
+	c, err := newContainer(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.State != StateSuspended {
+		t.Errorf("Expected state %s, got %s", StateSuspended, c.State)
+	}
+
+	if c.ExecConfig.ID != "deadbeef" {
+		t.Errorf("Expected ID deadbeef, got %s", c.ExecConfig.ID)
+	}
+
+	if c.VMConfig == nil || c.VMConfig.Aliases["db"] != "feebdaed" {
+		t.Errorf("Container VM metadata was not loaded: %+v", c.VMConfig)
+	}
+
+	// a VM whose config is not accessible is not a container we can report on
+	if _, err = newContainer(&mo.VirtualMachine{}); err != ErrNotContainer {
+		t.Errorf("Expected ErrNotContainer, got %s", err)
+	}
+}
+
+func TestFrozenContainer(t *testing.T) {
+	config := &metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID: "deadbeef",
+		},
+	}
+
+	tests := []struct {
+		power  types.VirtualMachinePowerState
+		frozen string
+		state  State
+	}{
+		{types.VirtualMachinePowerStatePoweredOn, "true", StateSuspended},
+		{types.VirtualMachinePowerStatePoweredOn, "", StateRunning},
+		// a marker left from before the container was powered off doesn't make it paused
+		{types.VirtualMachinePowerStatePoweredOff, "true", StateStopped},
+	}
+
+	for _, test := range tests {
+		vm := &mo.VirtualMachine{
+			Config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: append(extraConfig(t, config), &types.OptionValue{Key: FrozenKey, Value: test.frozen}),
+			},
+			Runtime: types.VirtualMachineRuntimeInfo{
+				PowerState: test.power,
+			},
+		}
+
+		c, err := newContainer(vm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.State != test.state || c.Frozen != (test.frozen == "true") {
+			t.Errorf("%s with frozen %q: unexpected state %s, frozen %t", test.power, test.frozen, c.State, c.Frozen)
+		}
+	}
+}
+
+func TestContainersFromVMs(t *testing.T) {
+	powerStates := map[string]types.VirtualMachinePowerState{
+		"running":   types.VirtualMachinePowerStatePoweredOn,
+		"paused":    types.VirtualMachinePowerStateSuspended,
+		"stopped":   types.VirtualMachinePowerStatePoweredOff,
+		"suspended": types.VirtualMachinePowerStatePoweredOn,
+	}
+
+	var vms []mo.VirtualMachine
+	for _, id := range []string{"running", "paused", "stopped", "suspended"} {
+		config := &metadata.ExecutorConfig{
+			Common: metadata.Common{
+				ID: id,
+			},
+		}
+
+		extra := extraConfig(t, config)
+		if id == "suspended" {
+			// paused by freezing the processes in the container rather than suspending the VM
+			extra = append(extra, &types.OptionValue{Key: FrozenKey, Value: "true"})
+		}
+
+		vms = append(vms, mo.VirtualMachine{
+			Config: &types.VirtualMachineConfigInfo{
+				ExtraConfig: extra,
+			},
+			Runtime: types.VirtualMachineRuntimeInfo{
+				PowerState: powerStates[id],
+			},
+		})
+	}
+	// VMs that aren't containers are never listed
+	vms = append(vms, mo.VirtualMachine{})
+
+	tests := []struct {
+		all bool
+		ids []string
+	}{
+		{false, []string{"running", "paused", "suspended"}},
+		{true, []string{"running", "paused", "stopped", "suspended"}},
+	}
+
+	for _, test := range tests {
+		var ids []string
+		for _, c := range containersFromVMs(vms, test.all) {
+			ids = append(ids, c.ExecConfig.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("Listing with all %t: expected %v, got %v", test.all, test.ids, ids)
+		}
+	}
+}
+
+func TestRenameSpec(t *testing.T) {
+	config := &metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "deadbeef",
+			Name: "old_name",
+		},
+	}
+
+	vmblob, err := metadata.EncodeContainerVM(&metadata.ContainerVM{
+		Common:  metadata.Common{ID: "deadbeef", Name: "old_name"},
+		Aliases: map[string]string{"self": "old_name", "db": "feebdaed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra := append(extraConfig(t, config),
+		&types.OptionValue{Key: "guestInfo.docker_name", Value: "old_name"},
+		&types.OptionValue{Key: metadata.ContainerVMKey, Value: vmblob})
+
+	spec, err := renameSpec(extra, "new_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything has to change in the one reconfigure
+	if len(spec.ExtraConfig) != 3 {
+		t.Fatalf("Expected 3 extraConfig entries, got %d", len(spec.ExtraConfig))
+	}
+
+	renamed, err := ExecutorConfig(spec.ExtraConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "new_name" || renamed.ID != "deadbeef" {
+		t.Errorf("Unexpected executor config: %+v", renamed.Common)
+	}
+
+	// keys are written back with the case they were read with
+	name := spec.ExtraConfig[1].GetOptionValue()
+	if name.Key != "guestInfo.docker_name" || name.Value != "new_name" {
+		t.Errorf("Unexpected guest name option: %+v", name)
+	}
+
+	vmconfig, err := ContainerVM(spec.ExtraConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmconfig.Name != "new_name" || vmconfig.Aliases["self"] != "new_name" || vmconfig.Aliases["db"] != "feebdaed" {
+		t.Errorf("Unexpected container VM metadata: %+v", vmconfig)
+	}
+}
+
+func TestStateFromPowerState(t *testing.T) {
+	states := map[types.VirtualMachinePowerState]State{
+		types.VirtualMachinePowerStatePoweredOn:  StateRunning,
+		types.VirtualMachinePowerStatePoweredOff: StateStopped,
+		types.VirtualMachinePowerStateSuspended:  StateSuspended,
+	}
+
+	for power, state := range states {
+		if s := StateFromPowerState(power); s != state {
+			t.Errorf("Expected %s for %s, got %s", state, power, s)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !IsNotFound(&find.NotFoundError{}) || !IsNotFound(ErrNotContainer) {
+		t.Error("Expected missing VMs and VMs that aren't containers to be not found")
+	}
+
+	if IsNotFound(errors.New("ServerFaultCode: Permission to perform this operation was denied.")) {
+		t.Error("Expected other lookup failures to be reported as they are")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/vmware/vic/metadata"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewHostKey generates the key the tether in a new container presents to the port layer on the
+// backchannel. It's PEM encoded as the tether expects to find it in the executor config.
+func NewHostKey() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// HostPublicKey returns the public half of the host key in the executor config, which the tether
+// in the container must present on the backchannel
+func HostPublicKey(config *metadata.ExecutorConfig) (ssh.PublicKey, error) {
+	if len(config.Key) == 0 {
+		return nil, fmt.Errorf("container %s has no host key", config.ID)
+	}
+
+	signer, err := ssh.ParsePrivateKey(config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key for container %s: %s", config.ID, err)
+	}
+
+	return signer.PublicKey(), nil
+}
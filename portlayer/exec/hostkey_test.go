@@ -0,0 +1,58 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vmware/vic/metadata"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKey(t *testing.T) {
+	key, err := NewHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tether parses the key as it's stored
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &metadata.ExecutorConfig{Common: metadata.Common{ID: "deadbeef"}, Key: key}
+	pub, err := HostPublicKey(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("Public key doesn't match the stored key")
+	}
+
+	other, err := NewHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other, key) {
+		t.Errorf("Expected a new key each time")
+	}
+
+	config.Key = nil
+	if _, err = HostPublicKey(config); err == nil {
+		t.Errorf("Expected an error for a container without a host key")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/vic/pkg/sessionlog"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"golang.org/x/net/context"
+)
+
+// logPollInterval is how often the session log is checked for growth when following
+var logPollInterval = time.Second
+
+// LogOptions controls which parts of the session log are returned and how
+type LogOptions struct {
+	// Tail is the number of lines to return from the end of the existing log, or -1 for all
+	Tail int64
+	// Since excludes lines recorded before it if non-zero
+	Since time.Time
+	// Timestamps prefixes each line with the time it was recorded
+	Timestamps bool
+	// Follow keeps streaming output until the container stops
+	Follow bool
+}
+
+// logSource opens the session log from the given byte offset. A log that does not exist yet,
+// or has not grown beyond the offset, is returned as an empty reader.
+type logSource func(ctx context.Context, offset int64) (io.ReadCloser, error)
+
+// Logs writes the session log of the container to out. The log is the file backing the session
+// serial port, in the container folder on the datastore, and is polled for growth when following.
+func Logs(ctx context.Context, sess *session.Session, id string, opts LogOptions, out io.Writer) error {
+	defer trace.End(trace.Begin(id))
+
+	source := datastoreLogSource(sess.Datastore, fmt.Sprintf("%s/%[1]s.log", id))
+
+	running := func() bool {
+		c, err := ContainerByID(ctx, sess, id)
+		if err != nil {
+			log.Errorf("Unable to determine state of %s while following logs: %s", id, err)
+			return false
+		}
+		return c.State == StateRunning
+	}
+
+	return streamLogs(ctx, source, running, opts, out)
+}
+
+// datastoreLogSource returns a logSource reading path from the datastore over http
+func datastoreLogSource(ds *object.Datastore, path string) logSource {
+	return func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		u, ticket, err := ds.ServiceTicket(ctx, path, "GET")
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.AddCookie(ticket)
+		req.Cancel = ctx.Done()
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		res, err := ds.Client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch res.StatusCode {
+		case http.StatusPartialContent:
+			return res.Body, nil
+		case http.StatusOK:
+			// the range was ignored so skip what has already been read
+			if _, err = io.CopyN(ioutil.Discard, res.Body, offset); err != nil && err != io.EOF {
+				res.Body.Close()
+				return nil, err
+			}
+			return res.Body, nil
+		case http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+			res.Body.Close()
+			return ioutil.NopCloser(&bytes.Buffer{}), nil
+		default:
+			res.Body.Close()
+			return nil, fmt.Errorf("unable to read %s: %s", path, res.Status)
+		}
+	}
+}
+
+// logWriter formats session log lines according to the options
+type logWriter struct {
+	opts LogOptions
+	out  io.Writer
+
+	// partial holds the start of a line that has not yet been terminated
+	partial []byte
+}
+
+func (w *logWriter) writeLine(line []byte) error {
+	when, content := sessionlog.ParseLine(line)
+	if !w.opts.Since.IsZero() && !when.IsZero() && when.Before(w.opts.Since) {
+		return nil
+	}
+
+	if w.opts.Timestamps && !when.IsZero() {
+		if _, err := io.WriteString(w.out, when.Format(sessionlog.TimeFormat)+" "); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.out.Write(content)
+	return err
+}
+
+// lines splits r into lines, calling fn for each complete line. An unterminated final line is
+// held back in partial. The number of bytes read is returned.
+func (w *logWriter) lines(r io.Reader, fn func([]byte) error) (int64, error) {
+	var n int64
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		n += int64(len(line))
+
+		if err != nil {
+			w.partial = append(w.partial, line...)
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+			w.partial = nil
+		}
+
+		if err = fn(line); err != nil {
+			return n, err
+		}
+	}
+}
+
+// flush writes out any unterminated line
+func (w *logWriter) flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	line := w.partial
+	w.partial = nil
+	return w.writeLine(line)
+}
+
+// streamLogs copies the log from source to out, then polls for growth if following until
+// running reports that the container has stopped or the context is done.
+func streamLogs(ctx context.Context, source logSource, running func() bool, opts LogOptions, out io.Writer) error {
+	w := &logWriter{
+		opts: opts,
+		out:  out,
+	}
+
+	rc, err := source(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if opts.Tail < 0 {
+		offset, err = w.lines(rc, w.writeLine)
+	} else {
+		// hold on to the last lines until we know they're the last
+		var tail [][]byte
+		keep := func(line []byte) error {
+			if opts.Tail == 0 {
+				return nil
+			}
+			if int64(len(tail)) == opts.Tail {
+				tail = tail[1:]
+			}
+			tail = append(tail, line)
+			return nil
+		}
+
+		offset, err = w.lines(rc, keep)
+		if err == nil && !opts.Follow && len(w.partial) > 0 {
+			// the log is complete so the unterminated line counts as the last
+			keep(w.partial)
+			w.partial = nil
+		}
+
+		for _, line := range tail {
+			if err == nil {
+				err = w.writeLine(line)
+			}
+		}
+	}
+	rc.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if !opts.Follow {
+		return w.flush()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logPollInterval):
+		}
+
+		// check before reading so nothing written before the container stopped is missed
+		alive := running()
+
+		rc, err = source(ctx, offset)
+		if err != nil {
+			return err
+		}
+
+		n, err := w.lines(rc, w.writeLine)
+		rc.Close()
+		offset += n
+
+		if err != nil {
+			return err
+		}
+
+		if n == 0 && !alive {
+			return w.flush()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/vic/pkg/sessionlog"
+	"golang.org/x/net/context"
+)
+
+// memoryLog is a session log that can be appended to while it is being read
+type memoryLog struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (m *memoryLog) source(ctx context.Context, offset int64) (io.ReadCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	data := m.Bytes()
+	if offset >= int64(len(data)) {
+		return ioutil.NopCloser(&bytes.Buffer{}), nil
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(append([]byte(nil), data[offset:]...))), nil
+}
+
+func (m *memoryLog) record(when time.Time, line string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.WriteString(when.UTC().Format(sessionlog.TimeFormat) + " " + line)
+}
+
+var logStart = time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testLog() *memoryLog {
+	m := &memoryLog{}
+	for i, line := range []string{"one\n", "two\n", "three\n", "four"} {
+		m.record(logStart.Add(time.Duration(i)*time.Second), line)
+	}
+	return m
+}
+
+func TestStreamLogs(t *testing.T) {
+	stopped := func() bool { return false }
+
+	tests := []struct {
+		opts     LogOptions
+		expected string
+	}{
+		{LogOptions{Tail: -1}, "one\ntwo\nthree\nfour"},
+		{LogOptions{Tail: 0}, ""},
+		{LogOptions{Tail: 2}, "three\nfour"},
+		{LogOptions{Tail: 10}, "one\ntwo\nthree\nfour"},
+		{LogOptions{Tail: -1, Since: logStart.Add(2 * time.Second)}, "three\nfour"},
+		{LogOptions{Tail: 1, Timestamps: true}, logStart.Add(3*time.Second).Format(sessionlog.TimeFormat) + " four"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := streamLogs(context.Background(), testLog().source, stopped, test.opts, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.opts, test.expected, out.String())
+		}
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	interval := logPollInterval
+	logPollInterval = 10 * time.Millisecond
+	defer func() { logPollInterval = interval }()
+
+	m := testLog()
+
+	var mu sync.Mutex
+	alive := true
+	running := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return alive
+	}
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- streamLogs(context.Background(), m.source, running, LogOptions{Tail: 1, Follow: true}, &out)
+	}()
+
+	time.Sleep(5 * logPollInterval)
+	// continue the unterminated line as the tether would, without a timestamp
+	m.Lock()
+	m.WriteString(" more\n")
+	m.Unlock()
+	m.record(logStart, "last\n")
+
+	mu.Lock()
+	alive = false
+	mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for stopped container to end the follow")
+	}
+
+	// the line that was incomplete at the start is completed by the later write
+	expected := "three\nfour more\nlast\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
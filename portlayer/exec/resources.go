@@ -0,0 +1,407 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/metadata"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"golang.org/x/net/context"
+)
+
+// pendingResourcesKey is the extraConfig key holding the resource changes that couldn't be made
+// to the running container VM, which are made when it is next started
+const pendingResourcesKey = "vic.pendingresources"
+
+const (
+	// DefaultCPUShares are the docker CPU shares of a container that doesn't ask for any
+	DefaultCPUShares = 1024
+
+	// normalSharesPerCPU are the CPU shares vSphere gives each vCPU at the normal share level
+	normalSharesPerCPU = 1000
+
+	// DefaultCPUCount and DefaultMemoryMB size container VMs if neither the client, the VCH
+	// configuration nor the port layer options do
+	DefaultCPUCount = 2
+	DefaultMemoryMB = 2048
+)
+
+// resourcesMutex serializes read-modify-write updates of container VM resources
+var resourcesMutex sync.Mutex
+
+// Resources are CPU and memory settings of a container VM. Zero values leave the setting as it is.
+type Resources struct {
+	CPUCount int32
+	// CPUShares are relative to DefaultCPUShares, as with docker
+	CPUShares int64
+
+	MemoryMB            int64
+	MemoryReservationMB int64
+}
+
+// ResourcesError is returned when a container VM can't be given the resources asked for
+type ResourcesError struct {
+	Err error
+}
+
+func (e *ResourcesError) Error() string {
+	return e.Err.Error()
+}
+
+// CPUAllocation returns the vSphere CPU allocation for the docker CPU shares of a VM with the
+// given number of vCPUs. The shares are scaled so that the docker default is the vSphere normal
+// share level, keeping containers on a par with other VMs.
+func CPUAllocation(shares int64, cpus int32) *types.ResourceAllocationInfo {
+	return &types.ResourceAllocationInfo{
+		Shares: &types.SharesInfo{
+			Level:  types.SharesLevelCustom,
+			Shares: int32(shares * int64(cpus) * normalSharesPerCPU / DefaultCPUShares),
+		},
+	}
+}
+
+// pendingResources returns the resource changes waiting for the VM to be restarted, or nil
+func pendingResources(extraConfig []types.BaseOptionValue) *Resources {
+	value := extraConfigOption(extraConfig, pendingResourcesKey)
+	if value == nil {
+		return nil
+	}
+
+	blob, ok := value.Value.(string)
+	if !ok || blob == "" {
+		return nil
+	}
+
+	res := &Resources{}
+	if err := json.Unmarshal([]byte(blob), res); err != nil {
+		log.Warnf("Ignoring invalid pending resources %q: %s", blob, err)
+		return nil
+	}
+
+	return res
+}
+
+// UpdateResources applies the resource settings to the container VM. Those that can't be changed
+// while it is running are recorded and applied when it is next started, and their names returned.
+// A ResourcesError is returned if the VM's host can't provide them.
+func UpdateResources(ctx context.Context, sess *session.Session, id string, res Resources) ([]string, error) {
+	defer trace.End(trace.Begin(id))
+
+	// the memory of a VM is a multiple of 4MB
+	if res.MemoryMB > 0 {
+		res.MemoryMB = (res.MemoryMB + 3) / 4 * 4
+	}
+
+	resourcesMutex.Lock()
+	defer resourcesMutex.Unlock()
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var mvm mo.VirtualMachine
+	props := []string{"config.hardware", "config.cpuHotAddEnabled", "config.memoryHotAddEnabled", "config.extraConfig", "runtime.powerState", "runtime.host"}
+	if err = vm.Properties(ctx, vm.Reference(), props, &mvm); err != nil {
+		return nil, err
+	}
+
+	if mvm.Config == nil {
+		return nil, ErrNotContainer
+	}
+
+	// the VM has to fit on its host with the vCPUs and memory asked for
+	if (res.CPUCount > 0 || res.MemoryMB > 0) && mvm.Runtime.Host != nil {
+		cpus, memory := mvm.Config.Hardware.NumCPU, int64(mvm.Config.Hardware.MemoryMB)
+		if res.CPUCount > 0 {
+			cpus = res.CPUCount
+		}
+		if res.MemoryMB > 0 {
+			memory = res.MemoryMB
+		}
+
+		host := object.NewHostSystem(vm.Client(), *mvm.Runtime.Host)
+		if _, err = CheckHostLimits(ctx, []*object.HostSystem{host}, cpus, memory); err != nil {
+			return nil, err
+		}
+	}
+
+	running := mvm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff
+	spec, restart, err := resourcesSpec(mvm.Config, running, res)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, *spec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restart, nil
+}
+
+// resourcesSpec returns the reconfigure spec applying the resource settings to a VM with the given
+// config, and the names of the settings deferred until the VM is powered off. The vCPU count and
+// memory size of a running VM can only be increased, and only if hot-add is enabled. Shares and
+// reservations can always be changed.
+func resourcesSpec(config *types.VirtualMachineConfigInfo, running bool, res Resources) (*types.VirtualMachineConfigSpec, []string, error) {
+	spec := &types.VirtualMachineConfigSpec{}
+	var restart []string
+
+	pending := pendingResources(config.ExtraConfig)
+	if pending == nil {
+		pending = &Resources{}
+	}
+
+	cpus := config.Hardware.NumCPU
+	cpuHotAdd := config.CpuHotAddEnabled != nil && *config.CpuHotAddEnabled
+	switch {
+	case res.CPUCount <= 0:
+	case res.CPUCount == cpus:
+		pending.CPUCount = 0
+	case !running || (cpuHotAdd && res.CPUCount > cpus):
+		spec.NumCPUs = res.CPUCount
+		cpus = res.CPUCount
+		pending.CPUCount = 0
+	default:
+		pending.CPUCount = res.CPUCount
+		restart = append(restart, "cpus")
+	}
+
+	memory := int64(config.Hardware.MemoryMB)
+	memoryHotAdd := config.MemoryHotAddEnabled != nil && *config.MemoryHotAddEnabled
+	switch {
+	case res.MemoryMB <= 0:
+	case res.MemoryMB == memory:
+		pending.MemoryMB = 0
+	case !running || (memoryHotAdd && res.MemoryMB > memory):
+		spec.MemoryMB = res.MemoryMB
+		memory = res.MemoryMB
+		pending.MemoryMB = 0
+	default:
+		pending.MemoryMB = res.MemoryMB
+		restart = append(restart, "memory")
+	}
+
+	if res.MemoryReservationMB > 0 {
+		// the reservation has to fit within the memory the VM will have
+		limit := memory
+		if pending.MemoryMB > 0 {
+			limit = pending.MemoryMB
+		}
+		if res.MemoryReservationMB > limit {
+			err := fmt.Errorf("memory reservation of %dMB exceeds the memory limit of %dMB", res.MemoryReservationMB, limit)
+			return nil, nil, &ResourcesError{Err: err}
+		}
+
+		spec.MemoryAllocation = &types.ResourceAllocationInfo{Reservation: res.MemoryReservationMB}
+	}
+
+	if res.CPUShares > 0 {
+		spec.CpuAllocation = CPUAllocation(res.CPUShares, cpus)
+		// the shares are rescaled for a vCPU count that's yet to be applied
+		pending.CPUShares = res.CPUShares
+	}
+	if pending.CPUCount == 0 {
+		pending.CPUShares = 0
+	}
+
+	// an empty value removes the key once there's nothing left pending
+	blob := ""
+	if pending.CPUCount > 0 || pending.MemoryMB > 0 {
+		data, err := json.Marshal(pending)
+		if err != nil {
+			return nil, nil, err
+		}
+		blob = string(data)
+	}
+	spec.ExtraConfig = []types.BaseOptionValue{
+		&types.OptionValue{Key: pendingResourcesKey, Value: blob},
+	}
+
+	return spec, restart, nil
+}
+
+// ApplyPendingResources makes the resource changes deferred by UpdateResources. The container VM
+// must be powered off.
+func ApplyPendingResources(ctx context.Context, sess *session.Session, id string, pending *Resources) error {
+	defer trace.End(trace.Begin(id))
+
+	resourcesMutex.Lock()
+	defer resourcesMutex.Unlock()
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		NumCPUs:  pending.CPUCount,
+		MemoryMB: pending.MemoryMB,
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: pendingResourcesKey, Value: ""},
+		},
+	}
+	if pending.CPUCount > 0 && pending.CPUShares > 0 {
+		spec.CpuAllocation = CPUAllocation(pending.CPUShares, pending.CPUCount)
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+	return err
+}
+
+// ContainerVMSize returns the default resources of container VMs from the VCH configuration in
+// the extraConfig of the appliance VM. The fallback applies to whatever it doesn't set, and is
+// returned along with any error decoding it. DefaultCPUCount and DefaultMemoryMB apply to
+// whatever neither sets.
+func ContainerVMSize(extraConfig []types.BaseOptionValue, fallback Resources) (Resources, error) {
+	res := Resources{CPUCount: fallback.CPUCount, MemoryMB: fallback.MemoryMB}
+	if res.CPUCount <= 0 {
+		res.CPUCount = DefaultCPUCount
+	}
+	if res.MemoryMB <= 0 {
+		res.MemoryMB = DefaultMemoryMB
+	}
+
+	value := extraConfigOption(extraConfig, metadata.VCHConfigKey)
+	if value == nil {
+		return res, nil
+	}
+
+	blob, ok := value.Value.(string)
+	if !ok || blob == "" {
+		return res, nil
+	}
+
+	config, err := metadata.DecodeVCHConfig(blob)
+	if err != nil {
+		return res, err
+	}
+
+	if config.ContainerVMSize.NumCPUs > 0 {
+		res.CPUCount = config.ContainerVMSize.NumCPUs
+	}
+	// an unlimited memory limit is -1, which doesn't size a VM
+	if config.ContainerVMSize.Memory.Limit > 0 {
+		res.MemoryMB = config.ContainerVMSize.Memory.Limit
+	}
+
+	return res, nil
+}
+
+// LoadContainerVMSize reads the default resources of container VMs from the VCH configuration of
+// the named appliance VM, using the fallback for what it doesn't set. The fallback is returned
+// along with any error.
+func LoadContainerVMSize(ctx context.Context, sess *session.Session, name string, fallback Resources) (Resources, error) {
+	defer trace.End(trace.Begin(name))
+
+	var mvm mo.VirtualMachine
+	vm, err := sess.Finder.VirtualMachine(ctx, name)
+	if err == nil {
+		err = vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &mvm)
+	}
+	if err == nil && mvm.Config == nil {
+		err = fmt.Errorf("no config for appliance VM %s", name)
+	}
+	if err != nil {
+		res, _ := ContainerVMSize(nil, fallback)
+		return res, err
+	}
+
+	return ContainerVMSize(mvm.Config.ExtraConfig, fallback)
+}
+
+// CheckHostLimits returns the hosts that can run a container VM with the given number of vCPUs and
+// memory, or a ResourcesError if none of them can
+func CheckHostLimits(ctx context.Context, hosts []*object.HostSystem, cpus int32, memoryMB int64) ([]*object.HostSystem, error) {
+	defer trace.End(trace.Begin(""))
+
+	hws := make([]*types.HostHardwareSummary, len(hosts))
+	for i, host := range hosts {
+		var mhost mo.HostSystem
+		if err := host.Properties(ctx, host.Reference(), []string{"summary.hardware"}, &mhost); err != nil {
+			return nil, err
+		}
+
+		if mhost.Summary.Hardware == nil {
+			log.Warnf("No hardware summary for host %s, not placing containers on it", host.Reference().Value)
+			continue
+		}
+		hws[i] = mhost.Summary.Hardware
+	}
+
+	fit, err := checkLimits(hws, cpus, memoryMB)
+	if err != nil {
+		return nil, &ResourcesError{Err: err}
+	}
+
+	var fitting []*object.HostSystem
+	for _, i := range fit {
+		fitting = append(fitting, hosts[i])
+	}
+
+	return fitting, nil
+}
+
+// checkLimits returns the indexes of the hardware that can provide the vCPUs and memory. If none
+// can, the error describes the largest hardware available. Nil entries are skipped.
+func checkLimits(hws []*types.HostHardwareSummary, cpus int32, memoryMB int64) ([]int, error) {
+	var fit []int
+	var maxThreads int32
+	var maxMemory int64
+
+	for i, hw := range hws {
+		if hw == nil {
+			continue
+		}
+
+		threads := int32(hw.NumCpuThreads)
+		memory := hw.MemorySize / (1024 * 1024)
+		if cpus <= threads && memoryMB <= memory {
+			fit = append(fit, i)
+		}
+
+		if threads > maxThreads {
+			maxThreads = threads
+		}
+		if memory > maxMemory {
+			maxMemory = memory
+		}
+	}
+
+	switch {
+	case len(fit) > 0:
+		return fit, nil
+	case cpus > maxThreads:
+		return nil, fmt.Errorf("%d vCPUs requested, the largest host has %d", cpus, maxThreads)
+	case memoryMB > maxMemory:
+		return nil, fmt.Errorf("%dMB of memory requested, the largest host has %dMB", memoryMB, maxMemory)
+	default:
+		return nil, fmt.Errorf("no host has both %d vCPUs and %dMB of memory", cpus, memoryMB)
+	}
+}
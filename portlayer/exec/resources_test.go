@@ -0,0 +1,229 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/metadata"
+)
+
+func vmConfig(cpus int32, memoryMB int32, hotAdd bool, pending string) *types.VirtualMachineConfigInfo {
+	config := &types.VirtualMachineConfigInfo{
+		Hardware:            types.VirtualHardware{NumCPU: cpus, MemoryMB: memoryMB},
+		CpuHotAddEnabled:    &hotAdd,
+		MemoryHotAddEnabled: &hotAdd,
+	}
+	if pending != "" {
+		config.ExtraConfig = []types.BaseOptionValue{
+			&types.OptionValue{Key: pendingResourcesKey, Value: pending},
+		}
+	}
+	return config
+}
+
+func TestResourcesSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *types.VirtualMachineConfigInfo
+		running bool
+		res     Resources
+
+		cpus     int32
+		memory   int64
+		restart  string
+		pending  *Resources
+		shares   int32
+		reserved int64
+	}{
+		{
+			name:   "stopped",
+			config: vmConfig(2, 2048, false, ""),
+			res:    Resources{CPUCount: 1, MemoryMB: 512, CPUShares: 512, MemoryReservationMB: 256},
+			cpus:   1, memory: 512, shares: 500, reserved: 256,
+		},
+		{
+			name:    "running without hot-add",
+			config:  vmConfig(2, 2048, false, ""),
+			running: true,
+			res:     Resources{CPUCount: 4, MemoryMB: 4096, CPUShares: 2048},
+			restart: "cpus,memory",
+			pending: &Resources{CPUCount: 4, MemoryMB: 4096, CPUShares: 2048},
+			// scaled for the vCPUs the VM has until it's restarted
+			shares: 4000,
+		},
+		{
+			name:    "running with hot-add",
+			config:  vmConfig(2, 2048, true, ""),
+			running: true,
+			res:     Resources{CPUCount: 4, MemoryMB: 4096},
+			cpus:    4, memory: 4096,
+		},
+		{
+			name:    "hot-add can't remove",
+			config:  vmConfig(2, 2048, true, ""),
+			running: true,
+			res:     Resources{CPUCount: 1, MemoryMB: 1024},
+			restart: "cpus,memory",
+			pending: &Resources{CPUCount: 1, MemoryMB: 1024},
+		},
+		{
+			name:    "revert pending",
+			config:  vmConfig(2, 2048, false, `{"CPUCount":4,"MemoryMB":4096}`),
+			running: true,
+			res:     Resources{CPUCount: 2},
+			pending: &Resources{MemoryMB: 4096},
+		},
+		{
+			name:    "reservation within pending memory",
+			config:  vmConfig(2, 2048, false, `{"MemoryMB":4096}`),
+			running: true,
+			res:     Resources{MemoryReservationMB: 3072},
+			pending: &Resources{MemoryMB: 4096}, reserved: 3072,
+		},
+	}
+
+	for _, test := range tests {
+		spec, restart, err := resourcesSpec(test.config, test.running, test.res)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if spec.NumCPUs != test.cpus || spec.MemoryMB != test.memory {
+			t.Errorf("%s: expected %d vCPUs and %dMB, got %d and %dMB", test.name, test.cpus, test.memory, spec.NumCPUs, spec.MemoryMB)
+		}
+
+		if strings.Join(restart, ",") != test.restart {
+			t.Errorf("%s: expected %q to need a restart, got %q", test.name, test.restart, restart)
+		}
+
+		pending := pendingResources(spec.ExtraConfig)
+		if (pending == nil) != (test.pending == nil) || (pending != nil && *pending != *test.pending) {
+			t.Errorf("%s: expected pending %+v, got %+v", test.name, test.pending, pending)
+		}
+
+		var shares int32
+		if spec.CpuAllocation != nil {
+			shares = spec.CpuAllocation.GetResourceAllocationInfo().Shares.Shares
+		}
+		if shares != test.shares {
+			t.Errorf("%s: expected %d CPU shares, got %d", test.name, test.shares, shares)
+		}
+
+		var reserved int64
+		if spec.MemoryAllocation != nil {
+			reserved = spec.MemoryAllocation.GetResourceAllocationInfo().Reservation
+		}
+		if reserved != test.reserved {
+			t.Errorf("%s: expected %dMB reserved, got %d", test.name, test.reserved, reserved)
+		}
+	}
+
+	// the reservation can't exceed the memory of the VM
+	_, _, err := resourcesSpec(vmConfig(2, 2048, false, ""), false, Resources{MemoryMB: 512, MemoryReservationMB: 1024})
+	if _, ok := err.(*ResourcesError); !ok {
+		t.Errorf("Expected a resources error for reservation exceeding memory, got %#v", err)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	hws := []*types.HostHardwareSummary{
+		{NumCpuThreads: 8, MemorySize: 16 * 1024 * 1024 * 1024},
+		nil,
+		{NumCpuThreads: 4, MemorySize: 64 * 1024 * 1024 * 1024},
+	}
+
+	tests := []struct {
+		cpus   int32
+		memory int64
+		fit    []int
+	}{
+		{1, 512, []int{0, 2}},
+		{8, 16384, []int{0}},
+		{4, 65536, []int{2}},
+		// no single host has both, though some host has each
+		{8, 65536, nil},
+		{9, 512, nil},
+		{1, 65537, nil},
+	}
+
+	for _, test := range tests {
+		fit, err := checkLimits(hws, test.cpus, test.memory)
+		if !reflect.DeepEqual(fit, test.fit) || (err == nil) != (test.fit != nil) {
+			t.Errorf("%d vCPUs and %dMB: expected hosts %v, got %v with error %v", test.cpus, test.memory, test.fit, fit, err)
+		}
+	}
+}
+
+func TestContainerVMSize(t *testing.T) {
+	blob := func(size metadata.Resources) string {
+		b, err := metadata.EncodeVCHConfig(&metadata.VirtualContainerHostConfigSpec{ContainerVMSize: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		value    string
+		fallback Resources
+		cpus     int32
+		memory   int64
+	}{
+		{"", Resources{}, DefaultCPUCount, DefaultMemoryMB},
+		{"", Resources{CPUCount: 1, MemoryMB: 1024}, 1, 1024},
+		{blob(metadata.Resources{}), Resources{}, DefaultCPUCount, DefaultMemoryMB},
+		{blob(metadata.Resources{}), Resources{CPUCount: 1, MemoryMB: 1024}, 1, 1024},
+		{blob(metadata.Resources{NumCPUs: 4}), Resources{}, 4, DefaultMemoryMB},
+		{blob(metadata.Resources{NumCPUs: 4}), Resources{CPUCount: 1, MemoryMB: 1024}, 4, 1024},
+		{blob(metadata.Resources{Memory: types.ResourceAllocationInfo{Limit: 512}}), Resources{}, DefaultCPUCount, 512},
+		{blob(metadata.Resources{Memory: types.ResourceAllocationInfo{Limit: -1}}), Resources{}, DefaultCPUCount, DefaultMemoryMB},
+	}
+
+	for _, test := range tests {
+		extraConfig := []types.BaseOptionValue{&types.OptionValue{Key: metadata.VCHConfigKey, Value: test.value}}
+		res, err := ContainerVMSize(extraConfig, test.fallback)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.CPUCount != test.cpus || res.MemoryMB != test.memory {
+			t.Errorf("%q with %+v: expected %d vCPUs and %dMB, got %d and %dMB", test.value, test.fallback, test.cpus, test.memory, res.CPUCount, res.MemoryMB)
+		}
+	}
+
+	// the blob install.sh writes for -n 4 -r 4096
+	extraConfig := []types.BaseOptionValue{&types.OptionValue{Key: metadata.VCHConfigKey, Value: "eyJDb250YWluZXJWTVNpemUiOnsiTnVtQ1BVcyI6NCwiTWVtb3J5Ijp7IkxpbWl0Ijo0MDk2fX19"}}
+	res, err := ContainerVMSize(extraConfig, Resources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CPUCount != 4 || res.MemoryMB != 4096 {
+		t.Errorf("Expected 4 vCPUs and 4096MB from the installer's VCH config, got %+v", res)
+	}
+
+	extraConfig = []types.BaseOptionValue{&types.OptionValue{Key: metadata.VCHConfigKey, Value: "not base64"}}
+	res, err = ContainerVMSize(extraConfig, Resources{CPUCount: 1})
+	if err == nil {
+		t.Error("Expected an error for an invalid VCH config")
+	}
+	if res.CPUCount != 1 || res.MemoryMB != DefaultMemoryMB {
+		t.Errorf("Expected the fallback with an invalid VCH config, got %+v", res)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/metadata"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"golang.org/x/net/context"
+)
+
+// The restart policies, named as with docker
+const (
+	RestartNo            = "no"
+	RestartAlways        = "always"
+	RestartOnFailure     = "on-failure"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+const (
+	// restartDelayMin is the delay before the first restart, which doubles with each restart after it
+	restartDelayMin = 100 * time.Millisecond
+	// restartDelayMax bounds the delay between restarts
+	restartDelayMax = time.Minute
+)
+
+// ErrAutoRemoveConflict is returned when a container that's removed once it exits is given a
+// policy that would restart it
+var ErrAutoRemoveConflict = errors.New("containers that are removed when they exit can't have a restart policy")
+
+// ErrNoContainerVM is returned when the port layer metadata of a container is needed but it was
+// created before that was persisted
+var ErrNoContainerVM = errors.New("container has no port layer metadata")
+
+// ValidateRestartPolicy returns an error if the restart policy isn't one docker would accept
+func ValidateRestartPolicy(policy metadata.RestartPolicy) error {
+	switch policy.Name {
+	case "", RestartNo, RestartAlways, RestartUnlessStopped:
+		if policy.MaximumRetryCount != 0 {
+			return fmt.Errorf("maximum restart count not valid with restart policy type '%s'", policy.Name)
+		}
+	case RestartOnFailure:
+		if policy.MaximumRetryCount < 0 {
+			return errors.New("maximum restart count must be a positive integer")
+		}
+	default:
+		return fmt.Errorf("invalid restart policy '%s'", policy.Name)
+	}
+
+	return nil
+}
+
+// Restarts reports whether the restart policy ever restarts a container
+func Restarts(policy metadata.RestartPolicy) bool {
+	return policy.Name != "" && policy.Name != RestartNo
+}
+
+// ShouldRestart reports whether the restart policy of the container calls for it to be started
+// again now that its primary process has exited with status. A container stopped by a client
+// isn't restarted whatever the policy.
+func ShouldRestart(vmconfig *metadata.ContainerVM, status int) bool {
+	if vmconfig == nil || vmconfig.StoppedByUser {
+		return false
+	}
+
+	policy := vmconfig.RestartPolicy
+	switch policy.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		return status != 0 && (policy.MaximumRetryCount == 0 || vmconfig.RestartCount < policy.MaximumRetryCount)
+	default:
+		return false
+	}
+}
+
+// RestartDelay returns how long to wait before restarting a container that has already been
+// restarted count times, so that one failing as soon as it starts doesn't hog the host
+func RestartDelay(count int) time.Duration {
+	delay := restartDelayMin
+	for i := 0; i < count && delay < restartDelayMax; i++ {
+		delay *= 2
+	}
+
+	if delay > restartDelayMax {
+		return restartDelayMax
+	}
+	return delay
+}
+
+// SetRestartPolicy changes the restart policy of the container
+func SetRestartPolicy(ctx context.Context, sess *session.Session, id string, policy metadata.RestartPolicy) error {
+	defer trace.End(trace.Begin(id))
+
+	if err := ValidateRestartPolicy(policy); err != nil {
+		return err
+	}
+
+	return updateContainerVM(ctx, sess, id, func(vmconfig *metadata.ContainerVM) error {
+		if vmconfig.AutoRemove && Restarts(policy) {
+			return ErrAutoRemoveConflict
+		}
+
+		vmconfig.RestartPolicy = policy
+		return nil
+	})
+}
+
+// SetStoppedByUser records whether the container was stopped by a client. Marking it as started
+// by a client also resets the restart count, as with docker. Containers without port layer
+// metadata have no restart policy so there's nothing to record.
+func SetStoppedByUser(ctx context.Context, sess *session.Session, id string, stopped bool) error {
+	defer trace.End(trace.Begin(id))
+
+	err := updateContainerVM(ctx, sess, id, func(vmconfig *metadata.ContainerVM) error {
+		vmconfig.StoppedByUser = stopped
+		if !stopped {
+			vmconfig.RestartCount = 0
+		}
+		return nil
+	})
+	if err == ErrNoContainerVM {
+		return nil
+	}
+	return err
+}
+
+// CountRestart increments the number of times the restart policy has started the container
+func CountRestart(ctx context.Context, sess *session.Session, id string) error {
+	defer trace.End(trace.Begin(id))
+
+	return updateContainerVM(ctx, sess, id, func(vmconfig *metadata.ContainerVM) error {
+		vmconfig.RestartCount++
+		return nil
+	})
+}
+
+// updateContainerVM applies update to the port layer metadata of the container VM
+func updateContainerVM(ctx context.Context, sess *session.Session, id string, update func(*metadata.ContainerVM) error) error {
+	// the metadata shares the serialization of executor config updates as renames touch both
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var mvm mo.VirtualMachine
+	if err = vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &mvm); err != nil {
+		return err
+	}
+
+	if mvm.Config == nil {
+		return ErrNotContainer
+	}
+
+	vmconfig, err := ContainerVM(mvm.Config.ExtraConfig)
+	if err != nil {
+		return err
+	}
+	if vmconfig == nil {
+		return ErrNoContainerVM
+	}
+
+	if err = update(vmconfig); err != nil {
+		return err
+	}
+
+	blob, err := metadata.EncodeContainerVM(vmconfig)
+	if err != nil {
+		return err
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: metadata.ContainerVMKey, Value: blob},
+		},
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return vm.Reconfigure(ctx, spec)
+	})
+	return err
+}
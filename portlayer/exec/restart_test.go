@@ -0,0 +1,116 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/vic/metadata"
+)
+
+func TestValidateRestartPolicy(t *testing.T) {
+	valid := []metadata.RestartPolicy{
+		{},
+		{Name: RestartNo},
+		{Name: RestartAlways},
+		{Name: RestartUnlessStopped},
+		{Name: RestartOnFailure},
+		{Name: RestartOnFailure, MaximumRetryCount: 3},
+	}
+	for _, policy := range valid {
+		if err := ValidateRestartPolicy(policy); err != nil {
+			t.Errorf("%+v: %s", policy, err)
+		}
+	}
+
+	invalid := []metadata.RestartPolicy{
+		{Name: "sometimes"},
+		{Name: RestartAlways, MaximumRetryCount: 3},
+		{Name: RestartOnFailure, MaximumRetryCount: -1},
+	}
+	for _, policy := range invalid {
+		if err := ValidateRestartPolicy(policy); err == nil {
+			t.Errorf("%+v: expected error", policy)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  metadata.RestartPolicy
+		count   int
+		stopped bool
+		status  int
+		restart bool
+	}{
+		{"none", metadata.RestartPolicy{}, 0, false, 1, false},
+		{"no", metadata.RestartPolicy{Name: RestartNo}, 0, false, 1, false},
+		{"always after success", metadata.RestartPolicy{Name: RestartAlways}, 5, false, 0, true},
+		{"always after stop", metadata.RestartPolicy{Name: RestartAlways}, 0, true, 137, false},
+		{"unless-stopped", metadata.RestartPolicy{Name: RestartUnlessStopped}, 0, false, 0, true},
+		{"unless-stopped after stop", metadata.RestartPolicy{Name: RestartUnlessStopped}, 0, true, 0, false},
+		{"on-failure after success", metadata.RestartPolicy{Name: RestartOnFailure}, 0, false, 0, false},
+		{"on-failure unlimited", metadata.RestartPolicy{Name: RestartOnFailure}, 100, false, 1, true},
+		{"on-failure within limit", metadata.RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}, 2, false, 1, true},
+		{"on-failure at limit", metadata.RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}, 3, false, 1, false},
+	}
+
+	for _, test := range tests {
+		vmconfig := &metadata.ContainerVM{
+			RestartPolicy: test.policy,
+			RestartCount:  test.count,
+			StoppedByUser: test.stopped,
+		}
+		if restart := ShouldRestart(vmconfig, test.status); restart != test.restart {
+			t.Errorf("%s: expected restart to be %t", test.name, test.restart)
+		}
+	}
+
+	if ShouldRestart(nil, 1) {
+		t.Error("Expected no restart without port layer metadata")
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	expected := map[int]time.Duration{
+		0:    100 * time.Millisecond,
+		1:    200 * time.Millisecond,
+		3:    800 * time.Millisecond,
+		10:   time.Minute,
+		1000: time.Minute,
+	}
+
+	for count, delay := range expected {
+		if d := RestartDelay(count); d != delay {
+			t.Errorf("%d restarts: expected %s, got %s", count, delay, d)
+		}
+	}
+}
+
+func TestRestarts(t *testing.T) {
+	for _, name := range []string{"", RestartNo} {
+		if Restarts(metadata.RestartPolicy{Name: name}) {
+			t.Errorf("%q: expected no restarts", name)
+		}
+	}
+
+	for _, name := range []string{RestartAlways, RestartOnFailure, RestartUnlessStopped} {
+		if !Restarts(metadata.RestartPolicy{Name: name}) {
+			t.Errorf("%q: expected restarts", name)
+		}
+	}
+}
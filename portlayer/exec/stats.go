@@ -0,0 +1,242 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"golang.org/x/net/context"
+)
+
+// realtimeInterval is the ID of the vSphere real-time performance interval, which is also its
+// sampling period in seconds
+const realtimeInterval = 20
+
+// The performance counters sampled for container VMs, named as group.counter.rollup. The
+// aggregate instance of each is used, which covers all of the vCPUs, NICs or disks of the VM.
+const (
+	// milliseconds of CPU time used over the interval
+	counterCPUUsed = "cpu.used.summation"
+	// kilobytes of host memory backing the guest memory
+	counterMemConsumed = "mem.consumed.average"
+	// kilobytes per second
+	counterNetReceived    = "net.received.average"
+	counterNetTransmitted = "net.transmitted.average"
+	// packets over the interval
+	counterNetPacketsRx = "net.packetsRx.summation"
+	counterNetPacketsTx = "net.packetsTx.summation"
+	// kilobytes per second
+	counterDiskRead  = "disk.read.average"
+	counterDiskWrite = "disk.write.average"
+	// requests over the interval
+	counterDiskReads  = "disk.numberRead.summation"
+	counterDiskWrites = "disk.numberWrite.summation"
+)
+
+var statsCounters = []string{
+	counterCPUUsed,
+	counterMemConsumed,
+	counterNetReceived,
+	counterNetTransmitted,
+	counterNetPacketsRx,
+	counterNetPacketsTx,
+	counterDiskRead,
+	counterDiskWrite,
+	counterDiskReads,
+	counterDiskWrites,
+}
+
+// the IDs of the performance counters by name, which don't change for the life of the server
+var (
+	perfCounters      map[string]int32
+	perfCountersMutex sync.Mutex
+)
+
+// Stats is the activity of a container VM over a sampling interval
+type Stats struct {
+	// Read is the end of the interval
+	Read     time.Time
+	Interval time.Duration
+
+	NumCPUs int32
+	// CPUUsed is the CPU time used across all vCPUs
+	CPUUsed time.Duration
+
+	// MemoryUsage and MemoryLimit are in bytes
+	MemoryUsage uint64
+	MemoryLimit uint64
+
+	NetworkRxBytes   uint64
+	NetworkTxBytes   uint64
+	NetworkRxPackets uint64
+	NetworkTxPackets uint64
+
+	DiskReadBytes  uint64
+	DiskWriteBytes uint64
+	DiskReads      uint64
+	DiskWrites     uint64
+}
+
+// ContainerStats returns up to the given number of the most recent real-time performance samples
+// of the container VM, oldest first. A container that isn't running has no samples.
+func ContainerStats(ctx context.Context, sess *session.Session, id string, samples int) ([]Stats, error) {
+	defer trace.End(trace.Begin(id))
+
+	vm, err := sess.Finder.VirtualMachine(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var mvm mo.VirtualMachine
+	if err = vm.Properties(ctx, vm.Reference(), []string{"config.hardware", "runtime.powerState"}, &mvm); err != nil {
+		return nil, err
+	}
+
+	if mvm.Config == nil {
+		return nil, ErrNotContainer
+	}
+
+	if mvm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+		return nil, nil
+	}
+
+	counters, err := counterIDs(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := types.PerfQuerySpec{
+		Entity:     vm.Reference(),
+		MaxSample:  int32(samples),
+		IntervalId: realtimeInterval,
+	}
+	names := make(map[int32]string)
+	for _, name := range statsCounters {
+		if key, ok := counters[name]; ok {
+			spec.MetricId = append(spec.MetricId, types.PerfMetricId{CounterId: key})
+			names[key] = name
+		}
+	}
+
+	req := types.QueryPerf{
+		This:      *sess.Vim25().ServiceContent.PerfManager,
+		QuerySpec: []types.PerfQuerySpec{spec},
+	}
+
+	res, err := methods.QueryPerf(ctx, sess.Vim25(), &req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, base := range res.Returnval {
+		if metric, ok := base.(*types.PerfEntityMetric); ok {
+			hw := mvm.Config.Hardware
+			return statsFromMetric(metric, names, hw.NumCPU, hw.MemoryMB), nil
+		}
+	}
+
+	// newly powered on VMs have yet to be sampled
+	return nil, nil
+}
+
+// counterIDs returns the IDs of the performance counters of the server by name
+func counterIDs(ctx context.Context, sess *session.Session) (map[string]int32, error) {
+	perfCountersMutex.Lock()
+	defer perfCountersMutex.Unlock()
+
+	if perfCounters != nil {
+		return perfCounters, nil
+	}
+
+	var pm mo.PerformanceManager
+	pc := property.DefaultCollector(sess.Vim25())
+	if err := pc.RetrieveOne(ctx, *sess.Vim25().ServiceContent.PerfManager, []string{"perfCounter"}, &pm); err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]int32, len(pm.PerfCounter))
+	for _, info := range pm.PerfCounter {
+		name := fmt.Sprintf("%s.%s.%s", info.GroupInfo.GetElementDescription().Key, info.NameInfo.GetElementDescription().Key, info.RollupType)
+		counters[name] = info.Key
+	}
+
+	perfCounters = counters
+	return perfCounters, nil
+}
+
+// statsFromMetric converts the sampled counter values of a VM into Stats. Counters are
+// identified by name through names, and those the server doesn't have are left at zero.
+func statsFromMetric(metric *types.PerfEntityMetric, names map[int32]string, numCPUs, memoryMB int32) []Stats {
+	stats := make([]Stats, len(metric.SampleInfo))
+	for i, info := range metric.SampleInfo {
+		stats[i] = Stats{
+			Read:        info.Timestamp,
+			Interval:    time.Duration(info.Interval) * time.Second,
+			NumCPUs:     numCPUs,
+			MemoryLimit: uint64(memoryMB) * 1024 * 1024,
+		}
+	}
+
+	for _, base := range metric.Value {
+		series, ok := base.(*types.PerfMetricIntSeries)
+		if !ok || series.Id.Instance != "" {
+			continue
+		}
+
+		for i, value := range series.Value {
+			// -1 marks a sample that has no value
+			if i >= len(stats) || value < 0 {
+				continue
+			}
+
+			s := &stats[i]
+			v := uint64(value)
+			seconds := uint64(s.Interval / time.Second)
+
+			switch names[series.Id.CounterId] {
+			case counterCPUUsed:
+				s.CPUUsed = time.Duration(value) * time.Millisecond
+			case counterMemConsumed:
+				s.MemoryUsage = v * 1024
+			case counterNetReceived:
+				s.NetworkRxBytes = v * 1024 * seconds
+			case counterNetTransmitted:
+				s.NetworkTxBytes = v * 1024 * seconds
+			case counterNetPacketsRx:
+				s.NetworkRxPackets = v
+			case counterNetPacketsTx:
+				s.NetworkTxPackets = v
+			case counterDiskRead:
+				s.DiskReadBytes = v * 1024 * seconds
+			case counterDiskWrite:
+				s.DiskWriteBytes = v * 1024 * seconds
+			case counterDiskReads:
+				s.DiskReads = v
+			case counterDiskWrites:
+				s.DiskWrites = v
+			}
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func intSeries(key int32, instance string, values ...int64) types.BasePerfMetricSeries {
+	return &types.PerfMetricIntSeries{
+		PerfMetricSeries: types.PerfMetricSeries{
+			Id: types.PerfMetricId{CounterId: key, Instance: instance},
+		},
+		Value: values,
+	}
+}
+
+func TestStatsFromMetric(t *testing.T) {
+	names := map[int32]string{
+		1: counterCPUUsed,
+		2: counterMemConsumed,
+		3: counterNetReceived,
+		4: counterDiskWrites,
+	}
+
+	start := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	metric := &types.PerfEntityMetric{
+		SampleInfo: []types.PerfSampleInfo{
+			{Timestamp: start, Interval: realtimeInterval},
+			{Timestamp: start.Add(realtimeInterval * time.Second), Interval: realtimeInterval},
+		},
+		Value: []types.BasePerfMetricSeries{
+			intSeries(1, "", 4000, 10000),
+			// per vCPU values are covered by the aggregate
+			intSeries(1, "0", 2000, 5000),
+			intSeries(2, "", 1024, -1),
+			intSeries(3, "", 5, 0),
+			intSeries(4, "", 7, 9),
+			// counters we don't sample are ignored
+			intSeries(99, "", 1, 1),
+		},
+	}
+
+	stats := statsFromMetric(metric, names, 2, 512)
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(stats))
+	}
+
+	first := stats[0]
+	if !first.Read.Equal(start) || first.Interval != 20*time.Second || first.NumCPUs != 2 {
+		t.Errorf("Unexpected sample info: %+v", first)
+	}
+	if first.CPUUsed != 4*time.Second {
+		t.Errorf("Expected 4s of CPU time, got %s", first.CPUUsed)
+	}
+	if first.MemoryUsage != 1024*1024 || first.MemoryLimit != 512*1024*1024 {
+		t.Errorf("Unexpected memory usage %d of %d", first.MemoryUsage, first.MemoryLimit)
+	}
+	// the rate is over the whole interval
+	if first.NetworkRxBytes != 5*1024*20 {
+		t.Errorf("Expected %d bytes received, got %d", 5*1024*20, first.NetworkRxBytes)
+	}
+	if first.DiskWrites != 7 {
+		t.Errorf("Expected 7 disk writes, got %d", first.DiskWrites)
+	}
+
+	second := stats[1]
+	if second.CPUUsed != 10*time.Second || second.DiskWrites != 9 {
+		t.Errorf("Unexpected second sample: %+v", second)
+	}
+	// samples without a value are left at zero
+	if second.MemoryUsage != 0 {
+		t.Errorf("Expected no memory usage for missing value, got %d", second.MemoryUsage)
+	}
+}
@@ -34,6 +34,10 @@ type Image struct {
 	Parent *url.URL
 
 	Store *url.URL
+
+	// Metadata associated with the image, saved with the layer.  The keys
+	// and values are opaque to the storage layer.
+	Metadata map[string][]byte
 }
 
 func Parse(u *url.URL) (*Image, error) {
@@ -0,0 +1,201 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Reference names an image in an image store, as docker's repository:tag
+// and repository@digest references do.
+type Reference struct {
+	// Repository is the normalized name of the repository, eg busybox
+	Repository string `json:"repository"`
+	// Tag is the tag of the image in the repository, empty if the image is
+	// referred to by digest alone
+	Tag string `json:"tag,omitempty"`
+	// Digest is the digest of the manifest the image was pulled with, if
+	// known
+	Digest string `json:"digest,omitempty"`
+	// ImageID is the ID of the top layer of the image
+	ImageID string `json:"imageID"`
+}
+
+// String returns the reference as docker formats it
+func (r Reference) String() string {
+	if r.Tag != "" {
+		return r.Repository + ":" + r.Tag
+	}
+	return r.Repository + "@" + r.Digest
+}
+
+// names reports whether the two references name the same image, regardless
+// of which image that is
+func (r Reference) names(other Reference) bool {
+	if r.Repository != other.Repository {
+		return false
+	}
+
+	if r.Tag != "" || other.Tag != "" {
+		return r.Tag == other.Tag
+	}
+	return r.Digest == other.Digest
+}
+
+// ReferenceStorer persists the references to the images in an image store
+type ReferenceStorer interface {
+
+	// ReadReferences returns the references saved with the image store, or
+	// none if nothing has been saved yet.
+	ReadReferences(ctx context.Context, store *url.URL) ([]Reference, error)
+
+	// WriteReferences replaces the references saved with the image store.
+	WriteReferences(ctx context.Context, store *url.URL, refs []Reference) error
+}
+
+// ReferenceCache keeps the references to the images in each image store in
+// memory.  The references of a store are read from the data store the first
+// time they're needed, and written back to it whenever they change.
+type ReferenceCache struct {
+	refs     map[url.URL][]Reference
+	refsLock sync.Mutex
+
+	// The implementation that persists the references.
+	DataStore ReferenceStorer
+}
+
+// references returns the references of the store, reading them from the data
+// store if they aren't cached.  The caller must hold refsLock.
+func (c *ReferenceCache) references(ctx context.Context, store *url.URL) ([]Reference, error) {
+	if refs, ok := c.refs[*store]; ok {
+		return refs, nil
+	}
+
+	refs, err := c.DataStore.ReadReferences(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.refs == nil {
+		c.refs = make(map[url.URL][]Reference)
+	}
+	c.refs[*store] = refs
+
+	return refs, nil
+}
+
+// ListReferences returns all of the references to images in the store
+func (c *ReferenceCache) ListReferences(ctx context.Context, store *url.URL) ([]Reference, error) {
+	c.refsLock.Lock()
+	defer c.refsLock.Unlock()
+
+	refs, err := c.references(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]Reference(nil), refs...), nil
+}
+
+// AddReference makes the reference refer to its image, replacing whichever
+// image it referred to before.
+func (c *ReferenceCache) AddReference(ctx context.Context, store *url.URL, ref Reference) error {
+	if ref.Repository == "" || ref.ImageID == "" || (ref.Tag == "" && ref.Digest == "") {
+		return fmt.Errorf("reference %q is incomplete", ref)
+	}
+
+	c.refsLock.Lock()
+	defer c.refsLock.Unlock()
+
+	refs, err := c.references(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	updated := make([]Reference, 0, len(refs)+1)
+	for _, r := range refs {
+		if !r.names(ref) {
+			updated = append(updated, r)
+		}
+	}
+	updated = append(updated, ref)
+
+	if err = c.DataStore.WriteReferences(ctx, store, updated); err != nil {
+		return err
+	}
+
+	c.refs[*store] = updated
+	return nil
+}
+
+// RemoveReference removes the reference, returning it with the ID of the
+// image it referred to.  The image itself is left in the store.
+func (c *ReferenceCache) RemoveReference(ctx context.Context, store *url.URL, ref Reference) (*Reference, error) {
+	removed, err := c.remove(ctx, store, ref.names)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(removed) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &removed[0], nil
+}
+
+// RemoveImageReferences removes all of the references to the image, returning
+// those that were removed.
+func (c *ReferenceCache) RemoveImageReferences(ctx context.Context, store *url.URL, imageID string) ([]Reference, error) {
+	return c.remove(ctx, store, func(r Reference) bool {
+		return r.ImageID == imageID
+	})
+}
+
+// remove removes the references that match from the store, writing the
+// references through only if there were any.
+func (c *ReferenceCache) remove(ctx context.Context, store *url.URL, match func(Reference) bool) ([]Reference, error) {
+	c.refsLock.Lock()
+	defer c.refsLock.Unlock()
+
+	refs, err := c.references(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Reference
+	updated := make([]Reference, 0, len(refs))
+	for _, r := range refs {
+		if match(r) {
+			removed = append(removed, r)
+		} else {
+			updated = append(updated, r)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	if err = c.DataStore.WriteReferences(ctx, store, updated); err != nil {
+		return nil, err
+	}
+
+	c.refs[*store] = updated
+	return removed, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"net/url"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/portlayer/util"
+)
+
+// MockReferenceStore keeps the references it's given in memory
+type MockReferenceStore struct {
+	saved  map[url.URL][]Reference
+	writes int
+}
+
+func (m *MockReferenceStore) ReadReferences(ctx context.Context, store *url.URL) ([]Reference, error) {
+	return m.saved[*store], nil
+}
+
+func (m *MockReferenceStore) WriteReferences(ctx context.Context, store *url.URL, refs []Reference) error {
+	if m.saved == nil {
+		m.saved = make(map[url.URL][]Reference)
+	}
+	m.saved[*store] = refs
+	m.writes++
+	return nil
+}
+
+func TestAddReference(t *testing.T) {
+	ds := &MockReferenceStore{}
+	c := &ReferenceCache{DataStore: ds}
+
+	store, err := util.StoreNameToURL("testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	refs, err := c.ListReferences(context.TODO(), store)
+	if !assert.NoError(t, err) || !assert.Empty(t, refs) {
+		return
+	}
+
+	latest := Reference{Repository: "busybox", Tag: "latest", Digest: "sha256:1234", ImageID: "layer1"}
+	byDigest := Reference{Repository: "busybox", Digest: "sha256:1234", ImageID: "layer1"}
+	other := Reference{Repository: "myimage", Tag: "latest", ImageID: "layer1"}
+	for _, ref := range []Reference{latest, byDigest, other} {
+		if !assert.NoError(t, c.AddReference(context.TODO(), store, ref)) {
+			return
+		}
+	}
+
+	refs, err = c.ListReferences(context.TODO(), store)
+	if !assert.NoError(t, err) || !assert.Equal(t, []Reference{latest, byDigest, other}, refs) {
+		return
+	}
+
+	// retagging moves the tag to the new image
+	moved := Reference{Repository: "busybox", Tag: "latest", ImageID: "layer2"}
+	if !assert.NoError(t, c.AddReference(context.TODO(), store, moved)) {
+		return
+	}
+
+	refs, err = c.ListReferences(context.TODO(), store)
+	if !assert.NoError(t, err) || !assert.Equal(t, []Reference{byDigest, other, moved}, refs) {
+		return
+	}
+
+	// the references survive the cache
+	fresh := &ReferenceCache{DataStore: ds}
+	refs, err = fresh.ListReferences(context.TODO(), store)
+	if !assert.NoError(t, err) || !assert.Equal(t, []Reference{byDigest, other, moved}, refs) {
+		return
+	}
+
+	// references need a name and an image
+	writes := ds.writes
+	for _, ref := range []Reference{
+		{Repository: "busybox", ImageID: "layer1"},
+		{Tag: "latest", ImageID: "layer1"},
+		{Repository: "busybox", Tag: "latest"},
+	} {
+		assert.Error(t, c.AddReference(context.TODO(), store, ref))
+	}
+	assert.Equal(t, writes, ds.writes)
+}
+
+func TestRemoveReference(t *testing.T) {
+	ds := &MockReferenceStore{}
+	c := &ReferenceCache{DataStore: ds}
+
+	store, err := util.StoreNameToURL("testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	latest := Reference{Repository: "busybox", Tag: "latest", ImageID: "layer1"}
+	old := Reference{Repository: "busybox", Tag: "old", ImageID: "layer1"}
+	other := Reference{Repository: "myimage", Tag: "latest", ImageID: "layer2"}
+	for _, ref := range []Reference{latest, old, other} {
+		if !assert.NoError(t, c.AddReference(context.TODO(), store, ref)) {
+			return
+		}
+	}
+
+	// references are removed by name, whichever image they refer to
+	removed, err := c.RemoveReference(context.TODO(), store, Reference{Repository: "busybox", Tag: "old"})
+	if !assert.NoError(t, err) || !assert.Equal(t, &old, removed) {
+		return
+	}
+
+	_, err = c.RemoveReference(context.TODO(), store, Reference{Repository: "busybox", Tag: "old"})
+	if !assert.True(t, os.IsNotExist(err)) {
+		return
+	}
+
+	refs, err := c.RemoveImageReferences(context.TODO(), store, "layer1")
+	if !assert.NoError(t, err) || !assert.Equal(t, []Reference{latest}, refs) {
+		return
+	}
+
+	// nothing is written when nothing is removed
+	writes := ds.writes
+	refs, err = c.RemoveImageReferences(context.TODO(), store, "layer1")
+	if !assert.NoError(t, err) || !assert.Empty(t, refs) {
+		return
+	}
+	assert.Equal(t, writes, ds.writes)
+	assert.Equal(t, []Reference{other}, ds.saved[*store])
+}
+
+func TestReferenceString(t *testing.T) {
+	assert.Equal(t, "busybox:latest", Reference{Repository: "busybox", Tag: "latest", Digest: "sha256:1234"}.String())
+	assert.Equal(t, "busybox@sha256:1234", Reference{Repository: "busybox", Digest: "sha256:1234"}.String())
+}
@@ -18,6 +18,7 @@ import (
 	"io"
 	"net/url"
 
+	"github.com/docker/docker/pkg/archive"
 	"golang.org/x/net/context"
 )
 
@@ -40,14 +41,42 @@ type ImageStorer interface {
 	ListImageStores(ctx context.Context) ([]*url.URL, error)
 
 	// WriteImage creates a new image layer from the given parent.  Eg
-	// parentImage + newLayer = new Image built from parent
+	// parentImage + newLayer = new Image built from parent.  Nothing is left
+	// in the store unless the image is written completely and the tar matches
+	// the checksum.
 	//
 	// parent - The parent image to create the new image from.
 	// ID - textual ID for the image to be written
+	// meta - metadata associated with the image
+	// sum - the sha256 checksum of the image tar
 	// r - the image tar to be written
-	WriteImage(ctx context.Context, parent *Image, ID string, r io.Reader) (*Image,
+	WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image,
 		error)
 
+	// CommitImage creates a new image layer from the given parent holding the
+	// changes made in the root disk of a stopped container created from it.
+	//
+	// parent - The image the container was created from.
+	// ID - textual ID for the image to be written
+	// containerID - The container whose changes make up the new layer
+	// meta - metadata associated with the image
+	CommitImage(ctx context.Context, parent *Image, ID, containerID string, meta map[string][]byte) (*Image,
+		error)
+
+	// ContainerChanges returns the paths added, changed and deleted in the root
+	// disk of a stopped container relative to the image it was created from.
+	//
+	// parent - The image the container was created from.
+	// containerID - The container to compare with the image
+	ContainerChanges(ctx context.Context, parent *Image, containerID string) ([]archive.Change, error)
+
+	// ExportContainer returns the whole filesystem of a stopped container as
+	// an uncompressed tar.  The filesystem stays available until the returned
+	// reader is closed.
+	//
+	// containerID - The container to export
+	ExportContainer(ctx context.Context, containerID string) (io.ReadCloser, error)
+
 	// GetImage queries the image store for the specified image.
 	//
 	// store - The image store to query name - The name of the image (optional)
@@ -57,4 +86,10 @@ type ImageStorer interface {
 	// ListImages returns a list of Images given a list of image IDs, or all
 	// images in the image store if no param is passed.
 	ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*Image, error)
+
+	// DeleteImage removes the image layer from the image store.  Layers that
+	// other layers or container disks are derived from must not be deleted.
+	//
+	// image - The image to be deleted
+	DeleteImage(ctx context.Context, image *Image) error
 }
@@ -15,13 +15,14 @@
 package storage
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"sync"
 
+	"github.com/docker/docker/pkg/archive"
 	"golang.org/x/net/context"
 
 	"github.com/vmware/vic/portlayer/util"
@@ -31,6 +32,9 @@ var Scratch = Image{
 	ID: "scratch",
 }
 
+// ErrImageInUse is returned when deleting an image that other images are derived from
+var ErrImageInUse = errors.New("image has dependent child images")
+
 // NameLookupCache the global view of all of the image stores.  To avoid unnecessary
 // lookups, the image cache keeps an in memory map of the store URI to the map
 // of images on disk.
@@ -74,6 +78,15 @@ func (c *NameLookupCache) CreateImageStore(ctx context.Context, storeName string
 		return nil, os.ErrExist
 	}
 
+	// a store on the datastore that isn't cached was created by an earlier
+	// process, and is cached from now on
+	if u, err = c.DataStore.GetImageStore(ctx, storeName); err == nil {
+		if err = c.loadImageStore(ctx, u); err != nil {
+			return nil, err
+		}
+		return nil, os.ErrExist
+	}
+
 	u, err = c.DataStore.CreateImageStore(ctx, storeName)
 	if err != nil {
 		return nil, err
@@ -89,7 +102,7 @@ func (c *NameLookupCache) CreateImageStore(ctx context.Context, storeName string
 	c.storeCache[*u] = make(map[string]Image)
 
 	// Create the root image
-	scratch, err := c.DataStore.WriteImage(ctx, &Image{Store: u}, Scratch.ID, nil)
+	scratch, err := c.DataStore.WriteImage(ctx, &Image{Store: u}, Scratch.ID, nil, "", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +111,46 @@ func (c *NameLookupCache) CreateImageStore(ctx context.Context, storeName string
 	return u, nil
 }
 
+// Rehydrate populates the cache with the image stores and images already in
+// the data store, which were written before the process started.
+func (c *NameLookupCache) Rehydrate(ctx context.Context) error {
+	stores, err := c.DataStore.ListImageStores(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		if err = c.loadImageStore(ctx, store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadImageStore caches the images in the store as the data store has them
+func (c *NameLookupCache) loadImageStore(ctx context.Context, store *url.URL) error {
+	images, err := c.DataStore.ListImages(ctx, store, nil)
+	if err != nil {
+		return err
+	}
+
+	cached := make(map[string]Image, len(images))
+	for _, i := range images {
+		cached[i.ID] = *i
+	}
+
+	c.storeCacheLock.Lock()
+	defer c.storeCacheLock.Unlock()
+
+	if c.storeCache == nil {
+		c.storeCache = make(map[url.URL]map[string]Image)
+	}
+
+	c.storeCache[*store] = cached
+	return nil
+}
+
 // ListImageStores returns a list of strings representing all existing image stores
 func (c *NameLookupCache) ListImageStores(ctx context.Context) ([]*url.URL, error) {
 	c.storeCacheLock.Lock()
@@ -110,25 +163,44 @@ func (c *NameLookupCache) ListImageStores(ctx context.Context) ([]*url.URL, erro
 	return stores, nil
 }
 
-func (c *NameLookupCache) WriteImage(ctx context.Context, parent *Image, ID, sum string, r io.Reader) (*Image, error) {
+func (c *NameLookupCache) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
 	// Check the parent exists (at least in the cache).
 	p, err := c.GetImage(ctx, parent.Store, parent.ID)
 	if err != nil {
 		return nil, fmt.Errorf("parent (%s) doesn't exist in %s", parent.ID, parent.Store.String())
 	}
 
-	h := sha256.New()
-	t := io.TeeReader(r, h)
-
-	i, err := c.DataStore.WriteImage(ctx, p, ID, t)
+	// the image is only written if it matches the checksum
+	i, err := c.DataStore.WriteImage(ctx, p, ID, meta, sum, r)
 	if err != nil {
 		return nil, err
 	}
 
-	actualSum := fmt.Sprintf("sha256:%x", h.Sum(nil))
-	if actualSum != sum {
-		// TODO(jzt): cleanup?
-		return nil, fmt.Errorf("Failed to validate image checksum. Expected %s, got %s", sum, actualSum)
+	// Add the new image to the cache
+	c.storeCacheLock.Lock()
+	defer c.storeCacheLock.Unlock()
+	c.storeCache[*p.Store][i.ID] = *i
+
+	return i, nil
+}
+
+// CommitImage creates a new image in the store of the parent from the changes made in the
+// container, and adds it to the cache.
+func (c *NameLookupCache) CommitImage(ctx context.Context, parent *Image, ID, containerID string, meta map[string][]byte) (*Image, error) {
+	// Check the parent exists (at least in the cache).
+	p, err := c.GetImage(ctx, parent.Store, parent.ID)
+	if err != nil {
+		return nil, fmt.Errorf("parent (%s) doesn't exist in %s", parent.ID, parent.Store.String())
+	}
+
+	// we expect this not to exist.
+	if _, err = c.GetImage(ctx, p.Store, ID); err == nil {
+		return nil, os.ErrExist
+	}
+
+	i, err := c.DataStore.CommitImage(ctx, p, ID, containerID, meta)
+	if err != nil {
+		return nil, err
 	}
 
 	// Add the new image to the cache
@@ -139,6 +211,22 @@ func (c *NameLookupCache) WriteImage(ctx context.Context, parent *Image, ID, sum
 	return i, nil
 }
 
+// ContainerChanges returns the changes made in the container relative to the parent
+func (c *NameLookupCache) ContainerChanges(ctx context.Context, parent *Image, containerID string) ([]archive.Change, error) {
+	// Check the parent exists (at least in the cache).
+	p, err := c.GetImage(ctx, parent.Store, parent.ID)
+	if err != nil {
+		return nil, fmt.Errorf("parent (%s) doesn't exist in %s", parent.ID, parent.Store.String())
+	}
+
+	return c.DataStore.ContainerChanges(ctx, p, containerID)
+}
+
+// ExportContainer returns the filesystem of the container as a tar
+func (c *NameLookupCache) ExportContainer(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	return c.DataStore.ExportContainer(ctx, containerID)
+}
+
 // GetImage gets the specified image from the given store by retreiving it from the cache.
 func (c *NameLookupCache) GetImage(ctx context.Context, store *url.URL, ID string) (*Image, error) {
 	c.storeCacheLock.Lock()
@@ -185,3 +273,67 @@ func (c *NameLookupCache) ListImages(ctx context.Context, store *url.URL, IDs []
 
 	return imageList, nil
 }
+
+// DeleteImage removes the image from its store and the cache.  Images that
+// are the parent of other images can't be deleted, nor can the root of the
+// store.
+func (c *NameLookupCache) DeleteImage(ctx context.Context, image *Image) error {
+	c.storeCacheLock.Lock()
+	defer c.storeCacheLock.Unlock()
+
+	s, ok := c.storeCache[*image.Store]
+	if !ok {
+		return fmt.Errorf("store (%s) doesn't exist", image.Store.String())
+	}
+
+	i, ok := s[image.ID]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if i.ID == Scratch.ID || len(children(s, &i)) > 0 {
+		return ErrImageInUse
+	}
+
+	if err := c.DataStore.DeleteImage(ctx, &i); err != nil {
+		return err
+	}
+
+	delete(s, i.ID)
+	return nil
+}
+
+// Children returns the images in the store of the image that are derived from it
+func (c *NameLookupCache) Children(ctx context.Context, image *Image) ([]*Image, error) {
+	c.storeCacheLock.Lock()
+	defer c.storeCacheLock.Unlock()
+
+	s, ok := c.storeCache[*image.Store]
+	if !ok {
+		return nil, fmt.Errorf("store (%s) doesn't exist", image.Store.String())
+	}
+
+	i, ok := s[image.ID]
+	if !ok {
+		return nil, fmt.Errorf("store (%s) doesn't have image %s", image.Store.String(), image.ID)
+	}
+
+	return children(s, &i), nil
+}
+
+// children returns the images in the store whose parent is the image
+func children(store map[string]Image, image *Image) []*Image {
+	if image.SelfLink == nil {
+		return nil
+	}
+
+	var imageList []*Image
+	for _, v := range store {
+		if v.Parent != nil && v.Parent.String() == image.SelfLink.String() {
+			child := v
+			imageList = append(imageList, &child)
+		}
+	}
+
+	return imageList
+}
@@ -15,24 +15,38 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"testing"
 
 	"golang.org/x/net/context"
 
+	"github.com/docker/docker/pkg/archive"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/vic/portlayer/util"
 )
 
 type MockDataStore struct {
+	// the images written to each store
+	db map[url.URL]map[string]Image
 }
 
 // GetImageStore checks to see if a named image store exists and returls the
 // URL to it if so or error.
 func (c *MockDataStore) GetImageStore(ctx context.Context, storeName string) (*url.URL, error) {
-	return nil, nil
+	u, err := util.StoreNameToURL(storeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.db[*u]; !ok {
+		return nil, os.ErrNotExist
+	}
+	return u, nil
 }
 
 func (c *MockDataStore) CreateImageStore(ctx context.Context, storeName string) (*url.URL, error) {
@@ -41,31 +55,91 @@ func (c *MockDataStore) CreateImageStore(ctx context.Context, storeName string)
 		return nil, err
 	}
 
+	if c.db == nil {
+		c.db = make(map[url.URL]map[string]Image)
+	}
+	c.db[*u] = make(map[string]Image)
+
 	return u, nil
 }
 
 func (c *MockDataStore) ListImageStores(ctx context.Context) ([]*url.URL, error) {
-	return nil, nil
+	var stores []*url.URL
+	for key := range c.db {
+		u := key
+		stores = append(stores, &u)
+	}
+	return stores, nil
 }
 
-func (c *MockDataStore) WriteImage(ctx context.Context, parent *Image, ID string, r io.Reader) (*Image, error) {
+func (c *MockDataStore) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
+	storeName, err := util.StoreName(parent.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is written unless the image matches the checksum
+	if r != nil {
+		h := sha256.New()
+		if _, err = io.Copy(h, r); err != nil {
+			return nil, err
+		}
+		if actualSum := fmt.Sprintf("sha256:%x", h.Sum(nil)); actualSum != sum {
+			return nil, fmt.Errorf("Failed to validate image checksum. Expected %s, got %s", sum, actualSum)
+		}
+	}
+
+	selfLink, err := util.ImageURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
 	i := Image{
-		ID:     ID,
-		Store:  parent.Store,
-		Parent: parent.SelfLink,
+		ID:       ID,
+		SelfLink: selfLink,
+		Store:    parent.Store,
+		Parent:   parent.SelfLink,
+		Metadata: meta,
 	}
+	c.db[*parent.Store][ID] = i
 
 	return &i, nil
 }
 
+func (c *MockDataStore) CommitImage(ctx context.Context, parent *Image, ID, containerID string, meta map[string][]byte) (*Image, error) {
+	return c.WriteImage(ctx, parent, ID, meta, "", nil)
+}
+
+func (c *MockDataStore) ContainerChanges(ctx context.Context, parent *Image, containerID string) ([]archive.Change, error) {
+	return nil, nil
+}
+
+func (c *MockDataStore) ExportContainer(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	return nil, nil
+}
+
 // GetImage gets the specified image from the given store by retreiving it from the cache.
 func (c *MockDataStore) GetImage(ctx context.Context, store *url.URL, ID string) (*Image, error) {
-	return nil, nil
+	i, ok := c.db[*store][ID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &i, nil
 }
 
 // ListImages resturns a list of Images for a list of IDs, or all if no IDs are passed
 func (c *MockDataStore) ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*Image, error) {
-	return nil, nil
+	var images []*Image
+	for _, v := range c.db[*store] {
+		i := v
+		images = append(images, &i)
+	}
+	return images, nil
+}
+
+func (c *MockDataStore) DeleteImage(ctx context.Context, image *Image) error {
+	delete(c.db[*image.Store], image.ID)
+	return nil
 }
 
 func TestListImages(t *testing.T) {
@@ -90,7 +164,7 @@ func TestListImages(t *testing.T) {
 	for i := 1; i < 50; i++ {
 		id := fmt.Sprintf("ID-%d", i)
 
-		img, err := s.WriteImage(context.TODO(), &parent, id, testSum, nil)
+		img, err := s.WriteImage(context.TODO(), &parent, id, nil, testSum, nil)
 		if !assert.NoError(t, err) {
 			return
 		}
@@ -134,3 +208,209 @@ func TestListImages(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteImageChecksum(t *testing.T) {
+	s := &NameLookupCache{
+		DataStore: &MockDataStore{},
+	}
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := Scratch
+	parent.Store = storeURL
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// an image that fails to validate isn't cached
+	_, err = s.WriteImage(context.TODO(), &parent, "layer", nil, testSum, bytes.NewReader([]byte("garbage")))
+	if !assert.Error(t, err) {
+		return
+	}
+	_, err = s.GetImage(context.TODO(), storeURL, "layer")
+	if !assert.Error(t, err) {
+		return
+	}
+
+	// and can be written again
+	img, err := s.WriteImage(context.TODO(), &parent, "layer", nil, testSum, bytes.NewReader(nil))
+	if !assert.NoError(t, err) {
+		return
+	}
+	cached, err := s.GetImage(context.TODO(), storeURL, "layer")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, img, cached)
+}
+
+func TestCommitImage(t *testing.T) {
+	s := &NameLookupCache{
+		DataStore: &MockDataStore{},
+	}
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := Scratch
+	parent.Store = storeURL
+	meta := map[string][]byte{"config": []byte(`{"Cmd":["sh"]}`)}
+
+	img, err := s.CommitImage(context.TODO(), &parent, "committed", "container", meta)
+	if !assert.NoError(t, err) || !assert.NotNil(t, img) || !assert.Equal(t, meta, img.Metadata) {
+		return
+	}
+
+	// the commit is available as an image in its own right
+	cached, err := s.GetImage(context.TODO(), storeURL, "committed")
+	if !assert.NoError(t, err) || !assert.Equal(t, img, cached) {
+		return
+	}
+
+	// IDs can't be reused
+	_, err = s.CommitImage(context.TODO(), &parent, "committed", "container", nil)
+	if !assert.True(t, os.IsExist(err)) {
+		return
+	}
+
+	// the parent has to be known
+	parent.ID = "nosuchimage"
+	_, err = s.CommitImage(context.TODO(), &parent, "orphan", "container", nil)
+	assert.Error(t, err)
+}
+
+func TestContainerChanges(t *testing.T) {
+	s := &NameLookupCache{
+		DataStore: &MockDataStore{},
+	}
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := Scratch
+	parent.Store = storeURL
+
+	_, err = s.ContainerChanges(context.TODO(), &parent, "container")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the container has to have been created from a known image
+	parent.ID = "nosuchimage"
+	_, err = s.ContainerChanges(context.TODO(), &parent, "container")
+	assert.Error(t, err)
+}
+
+func TestDeleteImage(t *testing.T) {
+	s := &NameLookupCache{
+		DataStore: &MockDataStore{},
+	}
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	scratch, err := s.GetImage(context.TODO(), storeURL, Scratch.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	parent, err := s.WriteImage(context.TODO(), scratch, "parent", nil, testSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	child, err := s.WriteImage(context.TODO(), parent, "child", nil, testSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	children, err := s.Children(context.TODO(), parent)
+	if !assert.NoError(t, err) || !assert.Equal(t, []*Image{child}, children) {
+		return
+	}
+
+	// neither the root of the store nor parents can be deleted
+	for _, image := range []*Image{scratch, parent} {
+		if !assert.Equal(t, ErrImageInUse, s.DeleteImage(context.TODO(), image)) {
+			return
+		}
+	}
+
+	if !assert.NoError(t, s.DeleteImage(context.TODO(), child)) {
+		return
+	}
+	_, err = s.GetImage(context.TODO(), storeURL, child.ID)
+	if !assert.Error(t, err) {
+		return
+	}
+
+	// the parent can go once its child has
+	if !assert.NoError(t, s.DeleteImage(context.TODO(), parent)) {
+		return
+	}
+	assert.True(t, os.IsNotExist(s.DeleteImage(context.TODO(), parent)))
+}
+
+func TestRehydrate(t *testing.T) {
+	ds := &MockDataStore{}
+	s := &NameLookupCache{
+		DataStore: ds,
+	}
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := Scratch
+	parent.Store = storeURL
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	meta := map[string][]byte{"config": []byte(`{"Cmd":["sh"]}`)}
+	img, err := s.WriteImage(context.TODO(), &parent, "layer", meta, testSum, nil)
+	if !assert.NoError(t, err) || !assert.Equal(t, meta, img.Metadata) {
+		return
+	}
+
+	// a new cache over the same data store knows nothing until it's rehydrated
+	fresh := &NameLookupCache{
+		DataStore: ds,
+	}
+	if _, err = fresh.GetImageStore(context.TODO(), "testStore"); !assert.Error(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, fresh.Rehydrate(context.TODO())) {
+		return
+	}
+
+	cached, err := fresh.GetImage(context.TODO(), storeURL, img.ID)
+	if !assert.NoError(t, err) || !assert.Equal(t, img, cached) {
+		return
+	}
+	_, err = fresh.GetImage(context.TODO(), storeURL, Scratch.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// a store that's only on the data store already exists, and is cached from then on
+	fresh = &NameLookupCache{
+		DataStore: ds,
+	}
+	_, err = fresh.CreateImageStore(context.TODO(), "testStore")
+	if !assert.True(t, os.IsExist(err)) {
+		return
+	}
+
+	cached, err = fresh.GetImage(context.TODO(), storeURL, img.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, img, cached)
+}
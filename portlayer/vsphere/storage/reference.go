@@ -0,0 +1,83 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+
+	"github.com/vmware/vic/pkg/trace"
+	portlayer "github.com/vmware/vic/portlayer/storage"
+	"github.com/vmware/vic/portlayer/util"
+	"golang.org/x/net/context"
+)
+
+// The references to the images in a store are kept in a file in the store
+// directory, alongside the image directories.
+const referencesFile = "references.json"
+
+// Returns the path of the references file relative to the datastore
+func referencesPath(storeName string) string {
+	return path.Join(datastoreParentPath, storeName, referencesFile)
+}
+
+// ReadReferences returns the references saved with the image store, or none
+// if nothing has been saved yet.
+func (v *ImageStore) ReadReferences(ctx context.Context, store *url.URL) ([]portlayer.Reference, error) {
+	defer trace.End(trace.Begin(store.String()))
+
+	storeName, err := util.StoreName(store)
+	if err != nil {
+		return nil, err
+	}
+
+	p := referencesPath(storeName)
+	if ok, err := fileExists(ctx, v.s.Datastore, p); err != nil || !ok {
+		return nil, err
+	}
+
+	blob, err := readFile(ctx, v.s.Datastore, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []portlayer.Reference
+	if err = json.Unmarshal(blob, &refs); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// WriteReferences replaces the references saved with the image store.  The
+// previous references are kept if the write fails.
+func (v *ImageStore) WriteReferences(ctx context.Context, store *url.URL, refs []portlayer.Reference) error {
+	defer trace.End(trace.Begin(store.String()))
+
+	storeName, err := util.StoreName(store)
+	if err != nil {
+		return err
+	}
+
+	blob, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+
+	// the references would be lost along with every tag if the file were left
+	// half written
+	return v.replaceFile(ctx, referencesPath(storeName), blob)
+}
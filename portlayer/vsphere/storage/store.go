@@ -15,19 +15,28 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
 	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/disk"
 	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
 	portlayer "github.com/vmware/vic/portlayer/storage"
 	"github.com/vmware/vic/portlayer/util"
 	"golang.org/x/net/context"
@@ -39,6 +48,14 @@ var datastoreParentPath = "VIC"
 const (
 	defaultDiskLabel = "containerfs"
 	defaultDiskSize  = 8388608
+
+	// The metadata of an image is kept in a file in the image directory,
+	// alongside its disk.
+	metadataFile = "metadata.json"
+
+	// An image is staged while it's written by keeping a marker file in the
+	// image directory, which is removed once the image is complete.
+	stagingFile = "staging"
 )
 
 type ImageStore struct {
@@ -66,6 +83,12 @@ func NewImageStore(ctx context.Context, s *session.Session) (*ImageStore, error)
 		return nil, err
 	}
 
+	// writes that were interrupted by the port layer stopping left their
+	// images incomplete
+	if err = vis.sweep(ctx); err != nil {
+		return nil, err
+	}
+
 	return vis, nil
 }
 
@@ -86,6 +109,21 @@ func (v *ImageStore) imageDiskDatastoreURI(storeName, imageName string) string {
 	return path.Join(v.imageDirDatastoreURI(storeName, imageName), imageName+".vmdk")
 }
 
+// Returns the path of the disk of an image relative to the datastore
+func diskPath(storeName, imageName string) string {
+	return path.Join(datastoreParentPath, storeName, imageName, imageName+".vmdk")
+}
+
+// Returns the path of the metadata file of an image relative to the datastore
+func metadataPath(storeName, imageName string) string {
+	return path.Join(datastoreParentPath, storeName, imageName, metadataFile)
+}
+
+// Returns the path of the staging marker of an image relative to the datastore
+func stagingPath(storeName, imageName string) string {
+	return path.Join(datastoreParentPath, storeName, imageName, stagingFile)
+}
+
 func (v *ImageStore) CreateImageStore(ctx context.Context, storeName string) (*url.URL, error) {
 	// convert the store name to a port layer url.
 	u, err := util.StoreNameToURL(storeName)
@@ -154,12 +192,16 @@ func (v *ImageStore) ListImageStores(ctx context.Context) ([]*url.URL, error) {
 }
 
 // WriteImage creates a new image layer from the given parent.
-// Eg parentImage + newLayer = new Image built from parent
+// Eg parentImage + newLayer = new Image built from parent.  The image is
+// staged until the tar has been validated against the checksum, and removed
+// if it can't be completed.
 //
 // parent - The parent image to create the new image from.
 // ID - textual ID for the image to be written
+// meta - metadata associated with the image
+// sum - the sha256 checksum of the image tar
 // Tag - the tag of the image to be written
-func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID string, r io.Reader) (*portlayer.Image, error) {
+func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*portlayer.Image, error) {
 
 	storeName, err := util.StoreName(parent.Store)
 	if err != nil {
@@ -171,77 +213,599 @@ func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID
 		return nil, err
 	}
 
+	// Only scratch, the root of the image store, has no parent.
+	if ID != portlayer.Scratch.ID && parent.ID == "" {
+		return nil, fmt.Errorf("parent ID is empty")
+	}
+
 	// Create the image directory in the store.
 	imageDirDsURI := v.imageDirDatastoreURI(storeName, ID)
 	if err = v.fm.MakeDirectory(ctx, imageDirDsURI, nil, false); err != nil {
 		return nil, err
 	}
 
-	ImageDiskDsURI := v.imageDiskDatastoreURI(storeName, ID)
 	log.Infof("Creating image %s", ID)
 
-	// If this is scratch, then it's the root of the image store.  All images
-	// will be descended from this created and prepared fs.
+	err = v.stageImage(ctx, storeName, ID, meta, func() error {
+		h := sha256.New()
+		if err := v.writeDisk(ctx, storeName, parent.ID, ID, io.TeeReader(r, h)); err != nil {
+			return err
+		}
+
+		// scratch is created rather than extracted from a tar
+		if ID == portlayer.Scratch.ID {
+			return nil
+		}
+
+		actualSum := fmt.Sprintf("sha256:%x", h.Sum(nil))
+		if actualSum != sum {
+			return fmt.Errorf("Failed to validate image checksum. Expected %s, got %s", sum, actualSum)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newImage := &portlayer.Image{
+		ID:       ID,
+		SelfLink: imageURL,
+		Parent:   parent.SelfLink,
+		Store:    parent.Store,
+		Metadata: meta,
+	}
+
+	return newImage, nil
+}
+
+// writeDisk creates the disk of the image as a child of the disk of the
+// parent, and extracts the tar onto it.  All of the tar is read, whether or
+// not the archive ends before it does.  If this is scratch, then it's the
+// root of the image store, and is created with an empty filesystem all images
+// will be descended from.
+func (v *ImageStore) writeDisk(ctx context.Context, storeName, parentID, ID string, r io.Reader) error {
+	imageDiskDsURI := v.imageDiskDatastoreURI(storeName, ID)
+
 	if ID == portlayer.Scratch.ID {
 		// Create the disk
-		vmdisk, err := v.dm.CreateAndAttach(ctx, ImageDiskDsURI, "", defaultDiskSize, os.O_RDWR)
+		vmdisk, err := v.dm.CreateAndAttach(ctx, imageDiskDsURI, "", defaultDiskSize, os.O_RDWR)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		defer v.dm.Detach(ctx, vmdisk)
 
 		// Make the filesystem and set its label to defaultDiskLabel
-		if err = vmdisk.Mkfs(defaultDiskLabel); err != nil {
-			return nil, err
+		return vmdisk.Mkfs(defaultDiskLabel)
+	}
+
+	// Create the disk
+	parentDiskDsURI := v.imageDiskDatastoreURI(storeName, parentID)
+	vmdisk, err := v.dm.CreateAndAttach(ctx, imageDiskDsURI, parentDiskDsURI, 0, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer v.dm.Detach(ctx, vmdisk)
+
+	dir, err := ioutil.TempDir("", "mnt-"+ID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := vmdisk.Mount(dir, nil); err != nil {
+		return err
+	}
+	defer vmdisk.Unmount()
+
+	// Untar the archive
+	if err = archive.Untar(r, dir, &archive.TarOptions{}); err != nil {
+		return err
+	}
+
+	// the checksum covers whatever follows the end of the archive too
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// CommitImage creates a new image layer from the given parent holding the changes made in the
+// root disk of the container.  The container must be stopped, and created from the parent.
+// The image is staged until all of the changes have been written.
+//
+// parent - The image the container was created from.
+// ID - textual ID for the image to be written
+// containerID - The container whose changes make up the new layer
+// meta - metadata associated with the image
+func (v *ImageStore) CommitImage(ctx context.Context, parent *portlayer.Image, ID, containerID string, meta map[string][]byte) (*portlayer.Image, error) {
+	defer trace.End(trace.Begin(ID))
+
+	storeName, err := util.StoreName(parent.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	imageURL, err := util.ImageURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the image directory in the store.
+	imageDirDsURI := v.imageDirDatastoreURI(storeName, ID)
+	if err = v.fm.MakeDirectory(ctx, imageDirDsURI, nil, false); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Committing container %s to image %s", containerID, ID)
+
+	err = v.stageImage(ctx, storeName, ID, meta, func() error {
+		return v.commitDisk(ctx, storeName, parent.ID, ID, containerID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newImage := &portlayer.Image{
+		ID:       ID,
+		SelfLink: imageURL,
+		Parent:   parent.SelfLink,
+		Store:    parent.Store,
+		Metadata: meta,
+	}
+
+	return newImage, nil
+}
+
+// commitDisk creates the disk of the image as a child of the disk of the
+// parent, and writes the changes made in the root disk of the container to it.
+func (v *ImageStore) commitDisk(ctx context.Context, storeName, parentID, ID, containerID string) error {
+	imageDiskDsURI := v.imageDiskDatastoreURI(storeName, ID)
+
+	// The new layer is a sibling of the container disk, so only the changes
+	// the container made need to be written to it.
+	parentDiskDsURI := v.imageDiskDatastoreURI(storeName, parentID)
+	vmdisk, err := v.dm.CreateAndAttach(ctx, imageDiskDsURI, parentDiskDsURI, 0, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer v.dm.Detach(ctx, vmdisk)
+
+	dir, err := ioutil.TempDir("", "mnt-"+ID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := vmdisk.Mount(dir, nil); err != nil {
+		return err
+	}
+	defer vmdisk.Unmount()
+
+	return v.containerChanges(ctx, storeName, parentID, containerID, func(root string, changes []archive.Change) error {
+		layer, err := archive.ExportChanges(root, changes, nil, nil)
+		if err != nil {
+			return err
 		}
-	} else {
+		defer layer.Close()
+
+		// the layer carries whiteouts for whatever the container removed
+		_, err = archive.ApplyLayer(dir, layer)
+		return err
+	})
+}
 
-		if parent.ID == "" {
-			return nil, fmt.Errorf("parent ID is empty")
+// stageImage completes the image in the image directory, which must have been
+// created, by calling write with the image marked as staged.  The image is
+// complete once write has returned and the metadata has been saved, at which
+// point the marker is removed.  Otherwise the image is removed, so that it can
+// be written again.
+func (v *ImageStore) stageImage(ctx context.Context, storeName, ID string, meta map[string][]byte, write func() error) error {
+	err := v.writeStagedImage(ctx, storeName, ID, meta, write)
+	if err != nil {
+		log.Warnf("Removing incomplete image %s: %s", ID, err)
+		if rerr := v.removeImage(ctx, storeName, ID); rerr != nil {
+			log.Warnf("Unable to remove incomplete image %s: %s", ID, rerr)
 		}
+	}
 
-		// Create the disk
-		parentDiskDsURI := v.imageDiskDatastoreURI(storeName, parent.ID)
-		vmdisk, err := v.dm.CreateAndAttach(ctx, ImageDiskDsURI, parentDiskDsURI, 0, os.O_RDWR)
+	return err
+}
+
+// writeStagedImage writes the image between marking it as staged and removing the marker
+func (v *ImageStore) writeStagedImage(ctx context.Context, storeName, ID string, meta map[string][]byte, write func() error) error {
+	if err := writeFile(ctx, v.s.Datastore, stagingPath(storeName, ID), nil); err != nil {
+		return err
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	if err := v.writeMetadata(ctx, storeName, ID, meta); err != nil {
+		return err
+	}
+
+	return tasks.Wait(ctx, func(ctx context.Context) (tasks.Waiter, error) {
+		return v.fm.DeleteDatastoreFile(ctx, v.s.Datastore.Path(stagingPath(storeName, ID)), v.s.Datacenter)
+	})
+}
+
+// ContainerChanges returns the paths added, changed and deleted in the root disk of the
+// container relative to the image it was created from.  The container must be stopped.
+//
+// parent - The image the container was created from.
+// containerID - The container to compare with the image
+func (v *ImageStore) ContainerChanges(ctx context.Context, parent *portlayer.Image, containerID string) ([]archive.Change, error) {
+	defer trace.End(trace.Begin(containerID))
+
+	storeName, err := util.StoreName(parent.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []archive.Change
+	err = v.containerChanges(ctx, storeName, parent.ID, containerID, func(root string, c []archive.Change) error {
+		changes = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ExportContainer returns the whole filesystem of the container, resolved
+// through the parent chain of its root disk, as an uncompressed tar.  The
+// container must be stopped.  The disk stays mounted until the returned reader
+// is closed.
+//
+// containerID - The container to export
+func (v *ImageStore) ExportContainer(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	defer trace.End(trace.Begin(containerID))
+
+	root, unmount, err := v.mountDiskRO(ctx, v.containerDiskDatastoreURI(containerID))
+	if err != nil {
+		return nil, err
+	}
+
+	// lost+found belongs to the disk rather than the container
+	tar, err := archive.TarWithOptions(root, &archive.TarOptions{
+		Compression:     archive.Uncompressed,
+		ExcludePatterns: []string{"lost+found"},
+	})
+	if err != nil {
+		unmount()
+		return nil, err
+	}
+
+	return &exportReader{ReadCloser: tar, unmount: unmount}, nil
+}
+
+// exportReader unmounts the exported disk once the tar of it has been closed
+type exportReader struct {
+	io.ReadCloser
+	unmount func()
+}
+
+func (e *exportReader) Close() error {
+	err := e.ReadCloser.Close()
+	e.unmount()
+	return err
+}
+
+// containerChanges mounts the root disk of the container and the disk of the
+// image it was created from, then calls fn with the root of the container
+// filesystem and the changes made to it.  The disks are unmounted once fn
+// returns.
+func (v *ImageStore) containerChanges(ctx context.Context, storeName, imageID, containerID string, fn func(root string, changes []archive.Change) error) error {
+	root, unmountContainer, err := v.mountDiskRO(ctx, v.containerDiskDatastoreURI(containerID))
+	if err != nil {
+		return err
+	}
+	defer unmountContainer()
+
+	image, unmountImage, err := v.mountDiskRO(ctx, v.imageDiskDatastoreURI(storeName, imageID))
+	if err != nil {
+		return err
+	}
+	defer unmountImage()
+
+	changes, err := archive.ChangesDirs(root, image)
+	if err != nil {
+		return err
+	}
+
+	return fn(root, changes)
+}
+
+// Uri to the root disk of a container, created alongside the container VM
+func (v *ImageStore) containerDiskDatastoreURI(containerID string) string {
+	return v.s.Datastore.Path(path.Join(containerID, containerID+".vmdk"))
+}
+
+// mountDiskRO mounts the disk on a temporary directory without modifying it.
+// A nonpersistent child is attached in place of the disk, which may be the
+// parent of others.  The returned function unmounts the disk and removes the
+// child.
+func (v *ImageStore) mountDiskRO(ctx context.Context, diskDsURI string) (string, func(), error) {
+	// the child is named uniquely so the disk can be mounted more than once
+	childDsURI := fmt.Sprintf("%s-%s-ro.vmdk", strings.TrimSuffix(diskDsURI, ".vmdk"), stringid.TruncateID(stringid.GenerateNonCryptoID()))
+
+	vmdisk, err := v.dm.CreateAndAttach(ctx, childDsURI, diskDsURI, 0, os.O_RDONLY)
+	if err != nil {
+		return "", nil, err
+	}
+
+	release := func() {
+		if err := v.dm.Detach(ctx, vmdisk); err != nil {
+			log.Warnf("Unable to detach %s: %s", childDsURI, err)
+			return
+		}
+
+		err := tasks.Wait(ctx, func(ctx context.Context) (tasks.Waiter, error) {
+			return object.NewVirtualDiskManager(v.s.Vim25()).DeleteVirtualDisk(ctx, childDsURI, v.s.Datacenter)
+		})
 		if err != nil {
-			return nil, err
+			log.Warnf("Unable to remove %s: %s", childDsURI, err)
 		}
-		defer v.dm.Detach(ctx, vmdisk)
+	}
+
+	dir, err := ioutil.TempDir("", "mnt-ro-")
+	if err != nil {
+		release()
+		return "", nil, err
+	}
 
-		dir, err := ioutil.TempDir("", "mnt-"+ID)
+	if err := vmdisk.Mount(dir, []string{"ro"}); err != nil {
+		os.RemoveAll(dir)
+		release()
+		return "", nil, err
+	}
+
+	unmount := func() {
+		if err := vmdisk.Unmount(); err != nil {
+			log.Warnf("Unable to unmount %s: %s", childDsURI, err)
+		}
+		os.RemoveAll(dir)
+		release()
+	}
+
+	return dir, unmount, nil
+}
+
+// DeleteImage removes the disk of the image layer and the directory holding
+// it.  The layer must not be the parent of other layers or container disks,
+// which would be left without it.
+//
+// image - The image to be deleted
+func (v *ImageStore) DeleteImage(ctx context.Context, image *portlayer.Image) error {
+	defer trace.End(trace.Begin(image.ID))
+
+	storeName, err := util.StoreName(image.Store)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Deleting image %s", image.ID)
+	return v.removeImage(ctx, storeName, image.ID)
+}
+
+// removeImage removes the disk of the image, if there is one, and the
+// directory holding it along with anything else in it.
+func (v *ImageStore) removeImage(ctx context.Context, storeName, ID string) error {
+	disk, err := fileExists(ctx, v.s.Datastore, diskPath(storeName, ID))
+	if err != nil {
+		return err
+	}
+
+	// the disk manager removes the descriptor along with the extents it refers to
+	if disk {
+		imageDiskDsURI := v.imageDiskDatastoreURI(storeName, ID)
+		err = tasks.Wait(ctx, func(ctx context.Context) (tasks.Waiter, error) {
+			return object.NewVirtualDiskManager(v.s.Vim25()).DeleteVirtualDisk(ctx, imageDiskDsURI, v.s.Datacenter)
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer os.RemoveAll(dir)
+	}
 
-		if err := vmdisk.Mount(dir, nil); err != nil {
-			return nil, err
+	imageDirDsURI := v.imageDirDatastoreURI(storeName, ID)
+	return tasks.Wait(ctx, func(ctx context.Context) (tasks.Waiter, error) {
+		return v.fm.DeleteDatastoreFile(ctx, imageDirDsURI, v.s.Datacenter)
+	})
+}
+
+// sweep removes the images in every image store that were left incomplete by
+// writes that didn't finish.  Their directories would stop the images from
+// being written again.
+func (v *ImageStore) sweep(ctx context.Context) error {
+	stores, err := v.ListImageStores(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		storeName, err := util.StoreName(store)
+		if err != nil {
+			return err
 		}
-		defer vmdisk.Unmount()
 
-		// Untar the archive
-		err = archive.Untar(r, dir, &archive.TarOptions{})
+		res, err := lsDir(ctx, v.s.Datastore, v.imageStoreDatastoreURI(storeName))
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		for _, f := range res.File {
+			folder, ok := f.(*types.FolderFileInfo)
+			if !ok {
+				continue
+			}
+
+			incomplete, err := v.incomplete(ctx, storeName, folder.Path)
+			if err != nil {
+				return err
+			}
+			if !incomplete {
+				continue
+			}
+
+			log.Infof("Removing incomplete image %s", folder.Path)
+			if err = v.removeImage(ctx, storeName, folder.Path); err != nil {
+				log.Warnf("Unable to remove incomplete image %s: %s", folder.Path, err)
+			}
 		}
 	}
 
+	return nil
+}
+
+// incomplete reports whether the image is still staged, or has no disk as
+// happens when the write stops before it's staged.
+func (v *ImageStore) incomplete(ctx context.Context, storeName, ID string) (bool, error) {
+	staged, err := fileExists(ctx, v.s.Datastore, stagingPath(storeName, ID))
+	if err != nil || staged {
+		return staged, err
+	}
+
+	disk, err := fileExists(ctx, v.s.Datastore, diskPath(storeName, ID))
+	return !disk, err
+}
+
+// writeMetadata saves the metadata of the image in the image directory.
+// Nothing is saved for images without metadata.
+func (v *ImageStore) writeMetadata(ctx context.Context, storeName, ID string, meta map[string][]byte) error {
+	if len(meta) == 0 {
+		return nil
+	}
+
+	blob, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(ctx, v.s.Datastore, metadataPath(storeName, ID), blob)
+}
+
+// readMetadata returns the metadata saved with the image, or none if the
+// image was written without any.
+func (v *ImageStore) readMetadata(ctx context.Context, storeName, ID string) (map[string][]byte, error) {
+	p := metadataPath(storeName, ID)
+	if ok, err := fileExists(ctx, v.s.Datastore, p); err != nil || !ok {
+		return nil, err
+	}
+
+	blob, err := readFile(ctx, v.s.Datastore, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta map[string][]byte
+	if err = json.Unmarshal(blob, &meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// GetImage returns the image in the store with the given ID, with the
+// metadata saved with it.  The parent of the image is the parent of its disk,
+// as named in the disk descriptor.
+func (v *ImageStore) GetImage(ctx context.Context, store *url.URL, ID string) (*portlayer.Image, error) {
+	defer trace.End(trace.Begin(ID))
+
+	storeName, err := util.StoreName(store)
+	if err != nil {
+		return nil, err
+	}
+
+	imageURL, err := util.ImageURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := readFile(ctx, v.s.Datastore, diskPath(storeName, ID))
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := v.readMetadata(ctx, storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
 	newImage := &portlayer.Image{
 		ID:       ID,
 		SelfLink: imageURL,
-		Parent:   parent.SelfLink,
-		Store:    parent.Store,
+		Store:    store,
+		Metadata: meta,
+	}
+
+	// scratch is the only image without a parent
+	if parentID := parentImageID(descriptor); parentID != "" {
+		if newImage.Parent, err = util.ImageURL(storeName, parentID); err != nil {
+			return nil, err
+		}
 	}
 
 	return newImage, nil
 }
 
-func (v *ImageStore) GetImage(ctx context.Context, store *url.URL, ID string) (*portlayer.Image, error) {
-	return nil, fmt.Errorf("not yet implemented")
+// ListImages returns the images in the store with the given IDs, or all of
+// them if no IDs are given.  Every directory in the store holds an image.
+func (v *ImageStore) ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*portlayer.Image, error) {
+	defer trace.End(trace.Begin(store.String()))
+
+	storeName, err := util.StoreName(store)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := lsDir(ctx, v.s.Datastore, v.imageStoreDatastoreURI(storeName))
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range IDs {
+		wanted[id] = true
+	}
+
+	images := []*portlayer.Image{}
+	for _, f := range res.File {
+		folder, ok := f.(*types.FolderFileInfo)
+		if !ok {
+			continue
+		}
+
+		if len(wanted) > 0 && !wanted[folder.Path] {
+			continue
+		}
+
+		image, err := v.GetImage(ctx, store, folder.Path)
+		if err != nil {
+			// a layer whose disk can't be read can't be used either
+			log.Warnf("Skipping image %s: %s", folder.Path, err)
+			continue
+		}
+		images = append(images, image)
+	}
+
+	return images, nil
 }
 
-func (v *ImageStore) ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*portlayer.Image, error) {
-	return nil, fmt.Errorf("not yet implemented")
+// parentFileNameHint matches the line of a disk descriptor naming the parent
+// of the disk, which is absent for disks without a parent
+var parentFileNameHint = regexp.MustCompile(`(?m)^parentFileNameHint="([^"]*)"`)
+
+// parentImageID returns the ID of the image whose disk the disk descriptor
+// names as its parent, or an empty string if the disk has no parent.  The
+// disk of each image is named after the image.
+func parentImageID(descriptor []byte) string {
+	m := parentFileNameHint.FindSubmatch(descriptor)
+	if m == nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(path.Base(string(m[1])), ".vmdk")
 }
 
 // Create the top level directory the image storeas are created under
@@ -291,3 +855,54 @@ func lsDir(ctx context.Context, d *object.Datastore, p string) (*types.HostDatas
 	res := info.Result.(types.HostDatastoreBrowserSearchResults)
 	return &res, nil
 }
+
+// readFile returns the content of the file at the path relative to the datastore
+func readFile(ctx context.Context, d *object.Datastore, p string) ([]byte, error) {
+	// the datastore can only download to a file
+	f, err := ioutil.TempFile("", "download-")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err = d.DownloadFile(ctx, p, f.Name(), nil); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(f.Name())
+}
+
+// writeFile replaces the content of the file at the path relative to the datastore
+func writeFile(ctx context.Context, d *object.Datastore, p string, blob []byte) error {
+	param := soap.DefaultUpload
+	param.ContentLength = int64(len(blob))
+
+	return d.Upload(ctx, bytes.NewReader(blob), p, &param)
+}
+
+// replaceFile replaces the content of the file at the path relative to the
+// datastore.  The content is uploaded alongside and moved over the file, so a
+// write that doesn't finish leaves the previous content in place.
+func (v *ImageStore) replaceFile(ctx context.Context, p string, blob []byte) error {
+	tmp := p + ".tmp"
+	if err := writeFile(ctx, v.s.Datastore, tmp, blob); err != nil {
+		return err
+	}
+
+	return tasks.Wait(ctx, func(ctx context.Context) (tasks.Waiter, error) {
+		return v.fm.MoveDatastoreFile(ctx, v.s.Datastore.Path(tmp), v.s.Datacenter, v.s.Datastore.Path(p), v.s.Datacenter, true)
+	})
+}
+
+// fileExists reports whether there's a file at the path relative to the datastore
+func fileExists(ctx context.Context, d *object.Datastore, p string) (bool, error) {
+	if _, err := d.Stat(ctx, p); err != nil {
+		if _, ok := err.(object.DatastoreNoSuchFileError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -205,7 +205,8 @@ func TestCreateImageLayers(t *testing.T) {
 		h.Write(buf.Bytes())
 		sum := fmt.Sprintf("sha256:%x", h.Sum(nil))
 
-		newImage, err := vsis.WriteImage(context.TODO(), parent, dirName, sum, buf)
+		meta := map[string][]byte{"layer": []byte(dirName)}
+		newImage, err := vsis.WriteImage(context.TODO(), parent, dirName, meta, sum, buf)
 		if !assert.NoError(t, err) || !assert.NotNil(t, newImage) {
 			return
 		}
@@ -217,6 +218,12 @@ func TestCreateImageLayers(t *testing.T) {
 	// verify we did anything by attaching the last layer rdonly
 	v := vsis.DataStore.(*ImageStore)
 
+	// the metadata is read back from the datastore
+	saved, err := v.GetImage(context.TODO(), storeURL, parent.ID)
+	if !assert.NoError(t, err) || !assert.Equal(t, parent.Metadata, saved.Metadata) {
+		return
+	}
+
 	roDisk, err := mountLayerRO(v, parent)
 	if !assert.NoError(t, err) {
 		return
@@ -259,6 +266,163 @@ func TestCreateImageLayers(t *testing.T) {
 	}
 }
 
+func TestWriteImageStaging(t *testing.T) {
+	vsis, client, err := setup(t)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Nuke the parent image store directory
+	defer rm(t, client, "")
+
+	storeURL, err := vsis.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+	parent, err := vsis.GetImage(context.TODO(), storeURL, portlayer.Scratch.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	body := "This archive contains a text file."
+	if err = tw.WriteHeader(&tar.Header{Name: "readme.txt", Mode: 0644, Size: int64(len(body))}); err != nil {
+		log.Fatalln(err)
+	}
+	if _, err = tw.Write([]byte(body)); err != nil {
+		log.Fatalln(err)
+	}
+	if err = tw.Close(); err != nil {
+		log.Fatalln(err)
+	}
+	layer := buf.Bytes()
+	sum := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	v := vsis.DataStore.(*ImageStore)
+	imageDir := path.Join(datastoreParentPath, "testStore", "layer")
+
+	// an image that doesn't match the checksum isn't left behind
+	_, err = vsis.WriteImage(context.TODO(), parent, "layer", nil, "sha256:garbage", bytes.NewReader(layer))
+	if !assert.Error(t, err) {
+		return
+	}
+	exists, err := fileExists(context.TODO(), v.s.Datastore, imageDir)
+	if !assert.NoError(t, err) || !assert.False(t, exists) {
+		return
+	}
+
+	// so it can be written again
+	_, err = vsis.WriteImage(context.TODO(), parent, "layer", nil, sum, bytes.NewReader(layer))
+	if !assert.NoError(t, err) {
+		return
+	}
+	incomplete, err := v.incomplete(context.TODO(), "testStore", "layer")
+	if !assert.NoError(t, err) || !assert.False(t, incomplete) {
+		return
+	}
+
+	// images still staged when the image store starts are removed
+	staged := v.imageDirDatastoreURI("testStore", "staged")
+	if !assert.NoError(t, v.fm.MakeDirectory(context.TODO(), staged, nil, false)) {
+		return
+	}
+	if !assert.NoError(t, writeFile(context.TODO(), v.s.Datastore, stagingPath("testStore", "staged"), nil)) {
+		return
+	}
+
+	v, err = NewImageStore(context.TODO(), client)
+	if !assert.NoError(t, err) {
+		return
+	}
+	exists, err = fileExists(context.TODO(), v.s.Datastore, path.Join(datastoreParentPath, "testStore", "staged"))
+	if !assert.NoError(t, err) || !assert.False(t, exists) {
+		return
+	}
+	exists, err = fileExists(context.TODO(), v.s.Datastore, imageDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, exists)
+}
+
+func TestReferences(t *testing.T) {
+	vsis, client, err := setup(t)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Nuke the parent image store directory
+	defer rm(t, client, "")
+
+	storeURL, err := vsis.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	refs := &portlayer.ReferenceCache{DataStore: vsis.DataStore.(*ImageStore)}
+
+	// a new store has no references
+	saved, err := refs.ListReferences(context.TODO(), storeURL)
+	if !assert.NoError(t, err) || !assert.Empty(t, saved) {
+		return
+	}
+
+	ref := portlayer.Reference{Repository: "busybox", Tag: "latest", ImageID: portlayer.Scratch.ID}
+	if !assert.NoError(t, refs.AddReference(context.TODO(), storeURL, ref)) {
+		return
+	}
+
+	// the references are read back from the datastore
+	refs = &portlayer.ReferenceCache{DataStore: vsis.DataStore.(*ImageStore)}
+	saved, err = refs.ListReferences(context.TODO(), storeURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []portlayer.Reference{ref}, saved)
+
+	// replacing the references leaves nothing behind in the store
+	other := portlayer.Reference{Repository: "busybox", Tag: "other", ImageID: portlayer.Scratch.ID}
+	if !assert.NoError(t, refs.AddReference(context.TODO(), storeURL, other)) {
+		return
+	}
+	exists, err := fileExists(context.TODO(), client.Datastore, referencesPath("testStore")+".tmp")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, exists)
+
+	refs = &portlayer.ReferenceCache{DataStore: vsis.DataStore.(*ImageStore)}
+	saved, err = refs.ListReferences(context.TODO(), storeURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []portlayer.Reference{ref, other}, saved)
+}
+
+func TestParentImageID(t *testing.T) {
+	descriptors := map[string]string{
+		"": `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="vmfsSparse"
+`,
+		"parentImage": `# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=fffffffe
+createType="vmfsSparse"
+parentFileNameHint="/vmfs/volumes/datastore1/VIC/testStore/parentImage/parentImage.vmdk"
+`,
+		"scratch": `parentFileNameHint="[datastore1] VIC/testStore/scratch/scratch.vmdk"`,
+	}
+
+	for expected, descriptor := range descriptors {
+		assert.Equal(t, expected, parentImageID([]byte(descriptor)))
+	}
+}
+
 func mountLayerRO(v *ImageStore, parent *portlayer.Image) (*disk.VirtualDisk, error) {
 	roName := v.imageStoreDatastoreURI("testStore") + "/" + parent.ID + "-ro.vmdk"
 	parentDsURI := v.imageDiskDatastoreURI("testStore", parent.ID)